@@ -7,6 +7,7 @@ type (
 		right   *avlNode[T]
 		parent  *avlNode[T]
 		balance int
+		count   int // nodes in the subtree
 		value   T
 	}
 
@@ -313,6 +314,7 @@ func (op *avlOperation[T]) insertNode(parent *avlNode[T], node *avlNode[T]) (out
 			key:    op.key,
 			value:  op.value,
 			parent: parent,
+			count:  1,
 		}
 		op.leaf = out
 		op.added = true
@@ -325,6 +327,7 @@ func (op *avlOperation[T]) insertNode(parent *avlNode[T], node *avlNode[T]) (out
 		balanced = true
 	} else if cmp > 0 {
 		node.left, balanced = op.insertNode(node, node.left)
+		node.updateCount()
 		if !balanced {
 			node.balance--
 			if node.balance < -1 {
@@ -334,6 +337,7 @@ func (op *avlOperation[T]) insertNode(parent *avlNode[T], node *avlNode[T]) (out
 		}
 	} else {
 		node.right, balanced = op.insertNode(node, node.right)
+		node.updateCount()
 		if !balanced {
 			node.balance++
 			if node.balance > 1 {
@@ -383,6 +387,7 @@ func (op *avlOperation[T]) deleteNode(node *avlNode[T]) (out *avlNode[T], rebala
 
 	if cmp >= 0 {
 		node.left, rebalanced = op.deleteNode(node.left)
+		node.updateCount()
 		if !rebalanced {
 			node.balance++
 			if node.balance > 1 {
@@ -393,6 +398,7 @@ func (op *avlOperation[T]) deleteNode(node *avlNode[T]) (out *avlNode[T], rebala
 		}
 	} else {
 		node.right, rebalanced = op.deleteNode(node.right)
+		node.updateCount()
 		if !rebalanced {
 			node.balance--
 			if node.balance < -1 {
@@ -407,6 +413,18 @@ func (op *avlOperation[T]) deleteNode(node *avlNode[T]) (out *avlNode[T], rebala
 	return
 }
 
+// worker to recompute the subtree node count from the children
+func (node *avlNode[T]) updateCount() {
+	node.count = 1 + node.left.subtreeCount() + node.right.subtreeCount()
+}
+
+func (node *avlNode[T]) subtreeCount() int {
+	if node == nil {
+		return 0
+	}
+	return node.count
+}
+
 // worker to update the balance factor
 func (node *avlNode[T]) adjustBalance(second *avlNode[T], third *avlNode[T], direction int) {
 	switch third.balance {
@@ -435,6 +453,8 @@ func (node *avlNode[T]) rotateLeft(middle *avlNode[T]) *avlNode[T] {
 		middle.parent = nodeParent
 		node.balance = 0
 		middle.balance = 0
+		node.updateCount()
+		middle.updateCount()
 		return middle
 	} else {
 		// left-right rotation
@@ -453,6 +473,9 @@ func (node *avlNode[T]) rotateLeft(middle *avlNode[T]) *avlNode[T] {
 		node.parent = third
 		middle.parent = third
 		third.parent = nodeParent
+		middle.updateCount()
+		node.updateCount()
+		third.updateCount()
 		return third
 	}
 }
@@ -469,6 +492,8 @@ func (node *avlNode[T]) rotateRight(middle *avlNode[T]) *avlNode[T] {
 		middle.parent = nodeParent
 		node.balance = 0
 		middle.balance = 0
+		node.updateCount()
+		middle.updateCount()
 		return middle
 	} else {
 		// right-left rotation
@@ -487,6 +512,9 @@ func (node *avlNode[T]) rotateRight(middle *avlNode[T]) *avlNode[T] {
 		node.parent = third
 		middle.parent = third
 		third.parent = nodeParent
+		middle.updateCount()
+		node.updateCount()
+		third.updateCount()
 		return third
 	}
 }
@@ -502,6 +530,8 @@ func (node *avlNode[T]) deleteRotateLeft(middle *avlNode[T]) (out *avlNode[T], r
 		middle.parent = nodeParent
 		node.balance = -1
 		middle.balance = 1
+		node.updateCount()
+		middle.updateCount()
 		return middle, true
 	} else {
 		return node.rotateLeft(middle), false
@@ -519,6 +549,8 @@ func (node *avlNode[T]) deleteRotateRight(middle *avlNode[T]) (out *avlNode[T],
 		middle.parent = nodeParent
 		node.balance = 1
 		middle.balance = -1
+		node.updateCount()
+		middle.updateCount()
 		return middle, true
 	} else {
 		return node.rotateRight(middle), false
@@ -551,6 +583,100 @@ func (node *avlNode[T]) iterateNext(iter avlIterator[T]) bool {
 	return true
 }
 
+// iterates the keys from `low` to `high` inclusive, in ascending or descending
+// order; a nil bound is unbounded
+func (tree *avlTree[T]) IterateRange(low, high []byte, descending bool, iter avlIterator[T]) bool {
+	return tree.root.iterateRange(low, high, descending, iter)
+}
+
+func (node *avlNode[T]) iterateRange(low, high []byte, descending bool, iter avlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	// the left subtree can hold keys in range only if this key is above low,
+	// and the right subtree only if this key is below high
+	searchLeft := low == nil || keyCompare(low, node.key) > 0
+	searchRight := high == nil || keyCompare(node.key, high) > 0
+	inRange := (low == nil || keyCompare(low, node.key) >= 0) && (high == nil || keyCompare(node.key, high) >= 0)
+
+	first, second := node.left, node.right
+	searchFirst, searchSecond := searchLeft, searchRight
+	if descending {
+		first, second = second, first
+		searchFirst, searchSecond = searchSecond, searchFirst
+	}
+
+	if searchFirst && !first.iterateRange(low, high, descending, iter) {
+		return false
+	}
+	if inRange && !iter(node) {
+		return false
+	}
+	if searchSecond && !second.iterateRange(low, high, descending, iter) {
+		return false
+	}
+	return true
+}
+
+// Returns the number of keys in the AVL tree
+func (tree *avlTree[T]) Count() int {
+	return tree.nodes
+}
+
+// iterates the AVL tree in sorted order, starting with the node at `index`
+func (tree *avlTree[T]) IterateFrom(index int, iter avlIterator[T]) bool {
+	if index < 0 {
+		index = 0
+	}
+	return tree.root.iterateFrom(index, iter)
+}
+
+func (node *avlNode[T]) iterateFrom(index int, iter avlIterator[T]) bool {
+	if node == nil {
+		return true
+	}
+
+	leftCount := node.left.subtreeCount()
+	if index > leftCount {
+		return node.right.iterateFrom(index-leftCount-1, iter)
+	}
+
+	if index < leftCount && !node.left.iterateFrom(index, iter) {
+		return false
+	}
+	if !iter(node) {
+		return false
+	}
+	return node.right.iterateNext(iter)
+}
+
+// Makes a structural copy of the AVL tree, converting each node value with copyValue
+func (tree *avlTree[T]) Clone(copyValue func(value T) T) *avlTree[T] {
+	return &avlTree[T]{
+		root:  tree.root.clone(nil, copyValue),
+		nodes: tree.nodes,
+	}
+}
+
+// recursive worker that copies a node and its children
+func (node *avlNode[T]) clone(parent *avlNode[T], copyValue func(value T) T) *avlNode[T] {
+	if node == nil {
+		return nil
+	}
+
+	out := &avlNode[T]{
+		key:     node.key,
+		parent:  parent,
+		balance: node.balance,
+		count:   node.count,
+		value:   copyValue(node.value),
+	}
+	out.left = node.left.clone(out, copyValue)
+	out.right = node.right.clone(out, copyValue)
+	return out
+}
+
 // Unlinks the key nodes to empty the tree
 func (tree *avlTree[T]) Clear() {
 	if tree.root != nil {
@@ -66,10 +66,25 @@ func (node *avlNode[T]) checkParentLinks() bool {
 	return true
 }
 
+func (node *avlNode[T]) checkCounts() bool {
+	if node == nil {
+		return true
+	}
+
+	if !node.left.checkCounts() || !node.right.checkCounts() {
+		return false
+	}
+
+	return node.count == 1+node.left.subtreeCount()+node.right.subtreeCount()
+}
+
 func (tree *avlTree[T]) isValid() bool {
 	if !tree.root.checkBalanceFactors() {
 		return false
 	}
+	if !tree.root.checkCounts() || tree.root.subtreeCount() != tree.nodes {
+		return false
+	}
 	if !tree.root.checkParentLinks() {
 		return false
 	}
@@ -1054,3 +1069,116 @@ func TestAvlClear(t *testing.T) {
 		t.Fatal("not found 2")
 	}
 }
+
+func TestAvlClone(t *testing.T) {
+	tree := newAvlTree[float64]()
+
+	for i := 0; i < 100; i++ {
+		n := float64(rand.Intn(1000))
+		tree.Set(floatToBytes(n), n)
+	}
+
+	tree2 := tree.Clone(func(value float64) float64 { return value })
+	if !checkTree(tree2) || tree2.nodes != tree.nodes {
+		tree2.printTreeBalance("-----------------")
+		t.Fatal("clone invalid")
+	}
+
+	tree.Set(floatToBytes(5000), 5000)
+	tree.Delete(tree.root.key)
+
+	if tree2.Find(floatToBytes(5000)) != nil {
+		t.Error("clone shares nodes")
+	}
+	if !checkTree(tree2) {
+		t.Error("clone altered")
+	}
+}
+
+func TestAvlIterateRange(t *testing.T) {
+	tree := newAvlTree[float64]()
+
+	for i := 0; i < 100; i += 2 {
+		tree.Set(floatToBytes(float64(i)), float64(i))
+	}
+
+	collect := func(low, high []byte, descending bool, limit int) (values []float64) {
+		tree.IterateRange(low, high, descending, func(node *avlNode[float64]) bool {
+			values = append(values, node.value)
+			return len(values) < limit
+		})
+		return
+	}
+
+	values := collect(floatToBytes(10), floatToBytes(20), false, 100)
+	if len(values) != 6 || values[0] != 10 || values[5] != 20 {
+		t.Errorf("ascending %v", values)
+	}
+
+	values = collect(floatToBytes(11), floatToBytes(19), true, 100)
+	if len(values) != 4 || values[0] != 18 || values[3] != 12 {
+		t.Errorf("descending %v", values)
+	}
+
+	values = collect(nil, nil, true, 3)
+	if len(values) != 3 || values[0] != 98 || values[2] != 94 {
+		t.Errorf("unbounded %v", values)
+	}
+
+	values = collect(floatToBytes(95), nil, false, 100)
+	if len(values) != 2 || values[0] != 96 {
+		t.Errorf("low bound %v", values)
+	}
+
+	if values = collect(floatToBytes(21), floatToBytes(21), false, 100); len(values) != 0 {
+		t.Errorf("empty range %v", values)
+	}
+}
+
+func TestAvlOrderStatistics(t *testing.T) {
+	tree := newAvlTree[float64]()
+
+	for _, i := range rand.Perm(200) {
+		tree.Set(floatToBytes(float64(i*2)), float64(i*2))
+	}
+	for i := 0; i < 200; i += 4 {
+		tree.Delete(floatToBytes(float64(i * 2)))
+	}
+
+	if !checkTree(tree) || tree.Count() != 150 {
+		t.Fatal("tree invalid")
+	}
+
+	ordered := []float64{}
+	tree.Iterate(func(node *avlNode[float64]) bool {
+		ordered = append(ordered, node.value)
+		return true
+	})
+
+	for index := range ordered {
+		tree.IterateFrom(index, func(node *avlNode[float64]) bool {
+			if node.value != ordered[index] {
+				t.Errorf("iterate from %d: %v", index, node.value)
+			}
+			return false
+		})
+	}
+
+	values := []float64{}
+	tree.IterateFrom(146, func(node *avlNode[float64]) bool {
+		values = append(values, node.value)
+		return true
+	})
+	if len(values) != 4 || values[0] != 390 || values[3] != 398 {
+		t.Errorf("iterate from %v", values)
+	}
+
+	values = values[:0]
+	tree.IterateFrom(10, func(node *avlNode[float64]) bool {
+		values = append(values, node.value)
+		return len(values) < 2
+	})
+	if len(values) != 2 || values[0] != ordered[10] || values[1] != ordered[11] {
+		t.Errorf("iterate from middle %v", values)
+	}
+}
@@ -1,8 +1,6 @@
 module github.com/jimsnab/go-treestore
 
-go 1.21
-
-toolchain go1.21.4
+go 1.23
 
 require (
 	github.com/Knetic/govaluate v3.0.0+incompatible
@@ -1,5 +1,7 @@
 package treestore
 
+import "sort"
+
 type (
 	AutoLinkPath TokenSet
 
@@ -87,6 +89,7 @@ func (ts *TreeStore) DefineAutoLinkKey(dataParentSk, autoLinkSk StoreKey, fields
 		kn, _ = ts.ensureKeyExclusive(dataParentSk, false)
 	}
 
+	ts.preserveKeyNode(kn)
 	kals := kn.autoLinks
 	if kals == nil {
 		kals = &keyAutoLinks{
@@ -107,6 +110,12 @@ func (ts *TreeStore) DefineAutoLinkKey(dataParentSk, autoLinkSk StoreKey, fields
 	kals.autoLinkMap[autoLinkSk.Path] = &kald
 	ts.populateAutoLink(dataParentSk, kn)
 	autoLinkCreated = true
+
+	escapedFields := make([]EscapedSubPath, 0, len(fields))
+	for _, field := range fields {
+		escapedFields = append(escapedFields, EscapeSubPath(field))
+	}
+	ts.logMutation(&walRecord{Op: walDefineAutoLinkKey, Sk: dataParentSk.Tokens, Sk2: autoLinkSk.Tokens, Fields: escapedFields})
 	return
 }
 
@@ -128,8 +137,10 @@ func (ts *TreeStore) RemoveAutoLinkKey(dataParentSk, autoLinkSk StoreKey) (recor
 		if ki != nil {
 			_, defined := ki.autoLinkMap[autoLinkSk.Path]
 			if defined {
+				ts.preserveKeyNode(kn)
 				delete(ki.autoLinkMap, autoLinkSk.Path)
 				autoLinkRemoved = ts.deleteKeyTreeLocked(autoLinkSk)
+				ts.logMutation(&walRecord{Op: walRemoveAutoLinkKey, Sk: dataParentSk.Tokens, Sk2: autoLinkSk.Tokens})
 			}
 		}
 	}
@@ -148,7 +159,7 @@ func (ts *TreeStore) populateAutoLink(dataParentSk StoreKey, dataParentKn *keyNo
 
 	dataParentKn.nextLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
 		kn := node.value
-		if !kn.isExpired() {
+		if !kn.isExpired(ts.expirationTick()) {
 			tokens := append(dataParentSk.Tokens, kn.key)
 			ts.addAutoLinks(tokens, kn, true)
 		}
@@ -226,7 +237,7 @@ func (ts *TreeStore) iterateRecordFieldWorker(crs *changedRecordState, subPath S
 		default:
 			tree.Iterate(func(node *avlNode[*keyNode]) bool {
 				kn := node.value
-				if !kn.isExpired() {
+				if !kn.isExpired(ts.expirationTick()) {
 					callback(kn.key, affected)
 				}
 				return true
@@ -302,7 +313,7 @@ func (ts *TreeStore) processKeyLinks(tokens TokenSet, recordKn *keyNode, removal
 			// one or more autoLinks are defined at this record container key
 			crs.recordSk = MakeStoreKeyFromTokenSegments(tokens[0:end]...)
 
-			for _, kald := range kn.autoLinks.autoLinkMap {
+			for _, kald := range kn.autoLinks.sortedDefinitions() {
 				// process this auto-link definition
 				crs.alBaseSk = kald.autoLinkSk
 				ts.processAutoLinkPaths(&crs, kald.fields)
@@ -311,6 +322,22 @@ func (ts *TreeStore) processKeyLinks(tokens TokenSet, recordKn *keyNode, removal
 	}
 }
 
+// worker - returns the auto-link definitions ordered by auto-link key, so that
+// auto-link keys are always created (and assigned addresses) in the same order
+func (kals *keyAutoLinks) sortedDefinitions() []*keyAutoLinkDefinition {
+	paths := make([]string, 0, len(kals.autoLinkMap))
+	for path := range kals.autoLinkMap {
+		paths = append(paths, string(path))
+	}
+	sort.Strings(paths)
+
+	kalds := make([]*keyAutoLinkDefinition, 0, len(paths))
+	for _, path := range paths {
+		kalds = append(kalds, kals.autoLinkMap[TokenPath(path)])
+	}
+	return kalds
+}
+
 // Creation of some or all of the sk occurred. Caller must hold write lock on ts.keyNodeMu.
 func (ts *TreeStore) addAutoLinks(tokens TokenSet, kn *keyNode, tree bool) {
 	ts.processKeyLinks(tokens, kn, false, tree)
@@ -324,6 +351,7 @@ func (ts *TreeStore) removeAutoLinks(tokens TokenSet, kn *keyNode, tree bool) {
 // Record key was destroyed. Caller must hold write lock on ts.keyNodeMu.
 func (ts *TreeStore) purgeIndicies(kn *keyNode) {
 	if kn.autoLinks != nil {
+		ts.preserveKeyNode(kn)
 		for _, kald := range kn.autoLinks.autoLinkMap {
 			ts.deleteKeyTreeLocked(kald.autoLinkSk)
 		}
@@ -332,6 +360,7 @@ func (ts *TreeStore) purgeIndicies(kn *keyNode) {
 }
 
 // Returns all auto-link definitions defined for the specified data key, or nil if none.
+// The definitions are ordered by auto-link key.
 func (ts *TreeStore) GetAutoLinkDefinition(dataParentSk StoreKey) (alds []AutoLinkDefinition) {
 	level, tokenIndex, kn, expired := ts.locateKeyNodeForRead(dataParentSk)
 	defer ts.completeKeyNodeRead(level)
@@ -342,7 +371,7 @@ func (ts *TreeStore) GetAutoLinkDefinition(dataParentSk StoreKey) (alds []AutoLi
 
 	if kn.autoLinks != nil && len(kn.autoLinks.autoLinkMap) > 0 {
 		alds = make([]AutoLinkDefinition, 0, len(kn.autoLinks.autoLinkMap))
-		for _, kald := range kn.autoLinks.autoLinkMap {
+		for _, kald := range kn.autoLinks.sortedDefinitions() {
 			elem := AutoLinkDefinition{
 				AutoLinkSk: kald.autoLinkSk,
 				Fields:     kald.fields,
@@ -0,0 +1,147 @@
+package treestore
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	// Determines when the tree store is saved automatically. A save occurs
+	// when either threshold is reached; a zero threshold is not used.
+	AutosavePolicy struct {
+		FileName  string
+		Interval  time.Duration // save periodically
+		Mutations int           // save after this many changes
+		Keep      int           // number of prior snapshots retained as FileName.1 through FileName.Keep
+	}
+
+	autosaver struct {
+		l         lane.Lane
+		policy    AutosavePolicy
+		mutations atomic.Int64
+		trigger   chan struct{}
+		stop      chan struct{}
+		done      chan struct{}
+		saved     bool
+	}
+)
+
+// Starts saving the tree store in the background according to `policy`.
+// Saves are made with SaveOnline, so other operations continue while the
+// snapshot is written, and the result of each save is logged to `l`.
+//
+// Call Close to stop autosave with a final save.
+func (ts *TreeStore) EnableAutosave(l lane.Lane, policy AutosavePolicy) (err error) {
+	if policy.FileName == "" {
+		err = errors.New("autosave file name is required")
+	} else if policy.Interval <= 0 && policy.Mutations <= 0 {
+		err = errors.New("autosave requires an interval or a mutation count")
+	} else if policy.Interval < 0 || policy.Mutations < 0 || policy.Keep < 0 {
+		err = errors.New("autosave policy values can't be negative")
+	}
+	if err != nil {
+		l.Errorf("failed to enable autosave: %s", err.Error())
+		return
+	}
+
+	as := &autosaver{
+		l:       l,
+		policy:  policy,
+		trigger: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if !ts.autosave.CompareAndSwap(nil, as) {
+		err = errors.New("autosave is already enabled")
+		l.Errorf("failed to enable autosave %s: %s", policy.FileName, err.Error())
+		return
+	}
+
+	go ts.autosaveRoutine(as)
+	return
+}
+
+// Ends any watches, stops the expiration sweeper and autosave, if enabled,
+// making a final save, and then closes the write-ahead log, if enabled. The tree store should not
+// be used afterward.
+func (ts *TreeStore) Close() (err error) {
+	ts.stopExpirationSweeper()
+	ts.stopHistoryCompaction()
+	ts.closeWatchers()
+
+	if as := ts.autosave.Swap(nil); as != nil {
+		close(as.stop)
+		<-as.done
+
+		err = ts.autosaveNow(as)
+	}
+
+	if walErr := ts.DisableWriteAheadLog(ts.l); err == nil {
+		err = walErr
+	}
+	return
+}
+
+// worker - counts a change toward the autosave mutation threshold
+func (ts *TreeStore) countMutation() {
+	as := ts.autosave.Load()
+	if as == nil {
+		return
+	}
+
+	n := as.mutations.Add(1)
+	if as.policy.Mutations > 0 && n >= int64(as.policy.Mutations) {
+		select {
+		case as.trigger <- struct{}{}:
+		default:
+			// a save is already pending
+		}
+	}
+}
+
+func (ts *TreeStore) autosaveRoutine(as *autosaver) {
+	defer close(as.done)
+
+	var tick <-chan time.Time
+	if as.policy.Interval > 0 {
+		ticker := time.NewTicker(as.policy.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-as.stop:
+			return
+		case <-tick:
+		case <-as.trigger:
+		}
+
+		ts.autosaveNow(as)
+	}
+}
+
+// worker - saves the store and logs the result; an unchanged store is not
+// saved again
+func (ts *TreeStore) autosaveNow(as *autosaver) (err error) {
+	start := time.Now()
+	mutations := as.mutations.Swap(0)
+	if mutations == 0 && as.saved {
+		return
+	}
+
+	if err = ts.saveOnline(as.l, as.policy.FileName, as.policy.Keep); err != nil {
+		// retry with the next trigger
+		as.mutations.Add(mutations)
+		as.l.Errorf("treestore: autosave %s failed: %s", as.policy.FileName, err.Error())
+		return
+	}
+
+	as.saved = true
+	as.l.Infof("treestore: autosave %s: mutations:%d elapsed:%s", as.policy.FileName, mutations, time.Since(start))
+	return
+}
@@ -0,0 +1,165 @@
+package treestore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func autosaveTestValue(t *testing.T, fileName string) any {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.Load(ts.l, fileName); err != nil {
+		t.Fatalf("load %s: %s", fileName, err.Error())
+	}
+
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("counter"))
+	return val
+}
+
+func TestAutosaveMutations(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	err := ts.EnableAutosave(ts.l, AutosavePolicy{FileName: "/test.db", Mutations: 3, Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ts.EnableAutosave(ts.l, AutosavePolicy{FileName: "/test.db", Mutations: 3}); err == nil {
+		t.Error("expected already enabled error")
+	}
+
+	waitForSave := func(expected int) {
+		for i := 0; i < 1000; i++ {
+			exists, _ := afero.Exists(fs, "/test.db")
+			if exists && autosaveTestValue(t, "/test.db") == expected {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("autosave of %d did not occur", expected)
+	}
+
+	for n := 1; n <= 9; n++ {
+		ts.SetKeyValue(MakeStoreKey("counter"), n)
+		if n%3 == 0 {
+			waitForSave(n)
+		}
+	}
+
+	ts.SetKeyValue(MakeStoreKey("counter"), 10)
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the final save, and the prior two snapshots
+	if val := autosaveTestValue(t, "/test.db"); val != 10 {
+		t.Errorf("final save %v", val)
+	}
+	if val := autosaveTestValue(t, "/test.db.1"); val != 9 {
+		t.Errorf("rotation 1 %v", val)
+	}
+	if val := autosaveTestValue(t, "/test.db.2"); val != 6 {
+		t.Errorf("rotation 2 %v", val)
+	}
+
+	exists, _ := afero.Exists(fs, "/test.db.3")
+	if exists {
+		t.Error("too many snapshots kept")
+	}
+}
+
+type snapshotRenameFailFs struct {
+	afero.Fs
+}
+
+func (sfs snapshotRenameFailFs) Rename(oldname, newname string) error {
+	if oldname == "/test.db.tmp" {
+		return os.ErrPermission
+	}
+	return sfs.Fs.Rename(oldname, newname)
+}
+
+func TestAutosaveRotationRenameFailure(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+
+	ts.SetKeyValue(MakeStoreKey("counter"), 1)
+	if err := ts.saveOnline(ts.l, "/test.db", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// the new snapshot can't be put in place after the rotation
+	ts.SetKeyValue(MakeStoreKey("counter"), 2)
+	ts.SetFileSystem(snapshotRenameFailFs{afs})
+	if err := ts.saveOnline(ts.l, "/test.db", 2); err == nil {
+		t.Error("rename failure not reported")
+	}
+
+	fs = afs
+	if val := autosaveTestValue(t, "/test.db"); val != 1 {
+		t.Errorf("prior snapshot %v", val)
+	}
+	if val := autosaveTestValue(t, "/test.db.1"); val != 1 {
+		t.Errorf("rotation 1 %v", val)
+	}
+}
+
+func TestAutosaveInterval(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetKeyValue(MakeStoreKey("counter"), 1)
+
+	err := ts.EnableAutosave(ts.l, AutosavePolicy{FileName: "/test.db", Interval: time.Millisecond * 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		if exists, _ := afero.Exists(fs, "/test.db"); exists {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if val := autosaveTestValue(t, "/test.db"); val != 1 {
+		t.Errorf("interval save %v", val)
+	}
+
+	// unchanged, so the close doesn't save again
+	info1, _ := fs.Stat("/test.db")
+	time.Sleep(time.Millisecond * 20)
+	if err = ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info2, _ := fs.Stat("/test.db")
+	if info1.ModTime() != info2.ModTime() {
+		t.Error("unchanged store saved")
+	}
+}
+
+func TestAutosavePolicy(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	policies := []AutosavePolicy{
+		{Mutations: 1},
+		{FileName: "/test.db"},
+		{FileName: "/test.db", Mutations: 1, Keep: -1},
+	}
+
+	for i, policy := range policies {
+		if err := ts.EnableAutosave(ts.l, policy); err == nil {
+			t.Errorf("case %d: expected policy error", i)
+		}
+	}
+
+	// close without autosave is harmless
+	if err := ts.Close(); err != nil {
+		t.Error(err)
+	}
+}
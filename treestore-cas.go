@@ -0,0 +1,82 @@
+package treestore
+
+// The cas map holds the version of each key node that has had a value
+// written. Address 0 is never assigned to a key node, so its entry holds the
+// most recent version issued. Versions are drawn from this store-wide
+// sequence, so a key that is deleted and created again never repeats a version
+// seen by a caller.
+
+// worker - assigns the next version to a key node after its value is
+// written or cleared; the caller must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) bumpKeyVersion(kn *keyNode) {
+	ts.preserveKeyVersion(kn.address)
+	version := ts.cas[0] + 1
+	ts.cas[0] = version
+	ts.cas[kn.address] = version
+}
+
+// worker - forgets the version of a key node that is deleted; the caller must
+// hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) dropKeyVersion(kn *keyNode) {
+	ts.preserveKeyVersion(kn.address)
+	delete(ts.cas, kn.address)
+}
+
+// Navigates to the key and returns the current value, flags that indicate if
+// the key exists, and if so, if it has a value, and the key version to pass
+// to SetKeyValueCas.
+//
+// Each write of the key value increases the version. A key that does not
+// exist, or has never had a value, has version 0.
+func (ts *TreeStore) GetKeyValueWithVersion(sk StoreKey) (value any, keyExists, valueExists bool, version uint64) {
+	// the versions are written under the exclusive key node lock
+	ts.keyNodeMu.RLock()
+	defer ts.keyNodeMu.RUnlock()
+
+	level, index, kn, expired := ts.locateKeyNodeForReadLocked(sk)
+	defer ts.completeKeyNodeRead(level)
+
+	if index >= len(sk.Tokens) && !expired {
+		keyExists = true
+		if kn.current != nil {
+			valueExists = true
+			value = kn.current.value
+		}
+		version = ts.cas[kn.address]
+	}
+	return
+}
+
+// Sets a key value like SetKeyValue, but only if the key version is
+// `expectedVersion`, as returned by GetKeyValueWithVersion. Specify 0 to
+// require that the key has no value history, such as a new key.
+//
+// Returns the key `address` and the new `version` if the value is set. If
+// the version does not match, `swapped` is false, the address is 0, and the
+// current version is returned.
+func (ts *TreeStore) SetKeyValueCas(sk StoreKey, value any, expectedVersion uint64) (address StoreAddress, version uint64, swapped bool) {
+	// the key node linkage may change
+	ts.keyNodeMu.Lock()
+	defer ts.sanityCheck()
+	defer ts.keyNodeMu.Unlock()
+
+	_, index, kn, expired := ts.locateKeyNodeForLock(sk)
+	if index >= len(sk.Tokens) && !expired {
+		version = ts.cas[kn.address]
+	}
+
+	if version != expectedVersion {
+		return
+	}
+
+	kn, _, oldValue := ts.setKeyValueLocked(sk, value)
+
+	address = kn.address
+	version = ts.cas[kn.address]
+	swapped = true
+
+	// replays as a plain set, which issues the same version
+	ts.logMutation(&walRecord{Op: walSetKeyValue, Sk: sk.Tokens, Value: value})
+	ts.watchSetLocked(sk, kn, oldValue)
+	return
+}
@@ -0,0 +1,142 @@
+package treestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func TestCasSetKeyValue(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("counter")
+
+	_, keyExists, _, version := ts.GetKeyValueWithVersion(sk)
+	if keyExists || version != 0 {
+		t.Fatal("missing key")
+	}
+
+	addr, v1, swapped := ts.SetKeyValueCas(sk, 1, 0)
+	if !swapped || addr == 0 || v1 == 0 {
+		t.Fatal("create")
+	}
+
+	// stale version
+	addr, current, swapped := ts.SetKeyValueCas(sk, 2, 0)
+	if swapped || addr != 0 || current != v1 {
+		t.Error("stale create")
+	}
+
+	value, keyExists, valueExists, version := ts.GetKeyValueWithVersion(sk)
+	if value != 1 || !keyExists || !valueExists || version != v1 {
+		t.Error("get first")
+	}
+
+	_, v2, swapped := ts.SetKeyValueCas(sk, 2, v1)
+	if !swapped || v2 <= v1 {
+		t.Error("update")
+	}
+
+	if _, _, swapped = ts.SetKeyValueCas(sk, 3, v1); swapped {
+		t.Error("stale update")
+	}
+
+	// other writes bump the version too
+	ts.SetKeyValue(sk, 4)
+	_, _, _, v3 := ts.GetKeyValueWithVersion(sk)
+	if v3 <= v2 {
+		t.Error("set key value version")
+	}
+
+	ts.CalculateKeyValue(sk, "i+1")
+	_, _, _, v4 := ts.GetKeyValueWithVersion(sk)
+	if v4 <= v3 {
+		t.Error("calculate version")
+	}
+
+	val, _, _ := ts.GetKeyValue(sk)
+	if val != 5 {
+		t.Error("final value")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestCasDeleteAndRecreate(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("key")
+
+	_, v1, _ := ts.SetKeyValueCas(sk, "a", 0)
+	ts.DeleteKey(sk)
+
+	_, _, _, version := ts.GetKeyValueWithVersion(sk)
+	if version != 0 {
+		t.Error("deleted version")
+	}
+
+	ts.SetKeyValue(sk, "b")
+	_, _, _, v2 := ts.GetKeyValueWithVersion(sk)
+	if v2 == v1 {
+		t.Error("version reissued")
+	}
+
+	// clearing a value of a key that has children changes the version
+	ts.SetKey(MakeStoreKey("key", "child"))
+	ts.DeleteKeyWithValue(sk, false)
+	value, keyExists, valueExists, v3 := ts.GetKeyValueWithVersion(sk)
+	if value != nil || !keyExists || valueExists || v3 <= v2 {
+		t.Error("cleared value version")
+	}
+
+	if _, _, swapped := ts.SetKeyValueCas(sk, "c", v2); swapped {
+		t.Error("stale version after clear")
+	}
+	if _, _, swapped := ts.SetKeyValueCas(sk, "c", v3); !swapped {
+		t.Error("set after clear")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestCasSaveLoad(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	sk := MakeStoreKey("key")
+
+	ts.SetKeyValue(sk, 1)
+	_, v1, _ := ts.SetKeyValueCas(sk, 2, 1)
+	if v1 != 2 {
+		t.Fatalf("version %d", v1)
+	}
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, version := ts2.GetKeyValueWithVersion(sk)
+	if version != v1 {
+		t.Error("loaded version")
+	}
+
+	ts2.SetKeyValue(MakeStoreKey("other"), 1)
+	_, _, _, version = ts2.GetKeyValueWithVersion(MakeStoreKey("other"))
+	if version <= v1 {
+		t.Error("version sequence not restored")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
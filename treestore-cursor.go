@@ -0,0 +1,137 @@
+package treestore
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+type (
+	// An opaque continuation point for paged iteration. The empty cursor
+	// starts at the beginning.
+	//
+	// A cursor holds the last key of a page, and the next page begins with
+	// the first key that follows it in key order. Keys inserted or deleted
+	// between pages don't shift the pages, and a cursor remains valid after
+	// its key is deleted.
+	PageCursor string
+)
+
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// worker - encodes the key at the end of a page
+func makePageCursor(tokens TokenSet) PageCursor {
+	var b []byte
+	for _, token := range tokens {
+		b = binary.AppendUvarint(b, uint64(len(token)))
+		b = append(b, token...)
+	}
+	return PageCursor(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// worker - decodes the key of a cursor; the empty cursor is a nil token set
+func (pc PageCursor) tokens() (tokens TokenSet, err error) {
+	if pc == "" {
+		return
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(string(pc))
+	if err != nil || len(b) == 0 {
+		err = ErrInvalidCursor
+		return
+	}
+
+	tokens = TokenSet{}
+	for len(b) > 0 {
+		length, n := binary.Uvarint(b)
+		if n <= 0 || length > uint64(len(b)-n) {
+			tokens = nil
+			err = ErrInvalidCursor
+			return
+		}
+		b = b[n:]
+		tokens = append(tokens, TokenSegment(b[:length]))
+		b = b[length:]
+	}
+	return
+}
+
+// Like GetLevelKeys, but pages with a cursor instead of an offset. Returns up
+// to `limit` keys that follow `cursor`, and the cursor for the next page, which
+// is empty when there are no more keys. If the store key does not exist, the
+// return `keys` will be nil.
+//
+// The cursor must have been returned by a prior call for the same store key.
+func (ts *TreeStore) GetLevelKeysPage(sk StoreKey, pattern string, cursor PageCursor, limit int) (keys []LevelKey, next PageCursor, err error) {
+	after, err := cursor.tokens()
+	if err != nil {
+		return
+	}
+
+	var kr *KeyRange
+	if after != nil {
+		if len(after) != len(sk.Tokens)+1 || !isTokenSetPrefix(sk.Tokens, after) {
+			err = ErrInvalidCursor
+			return
+		}
+		kr = &KeyRange{From: after[len(sk.Tokens)], FromExclusive: true}
+	}
+
+	keys = []LevelKey{}
+	exists := ts.iterateLevelKeys(sk, pattern, kr, 0, limit > 0, func(lk LevelKey) bool {
+		if len(keys) >= limit {
+			next = makePageCursor(AppendStoreKeySegments(sk, keys[len(keys)-1].Segment).Tokens)
+			return false
+		}
+		keys = append(keys, lk)
+		return true
+	})
+
+	if !exists {
+		keys = nil
+	}
+	return
+}
+
+// Like GetMatchingKeys, but pages with a cursor instead of an offset. Returns
+// up to `limit` keys that follow `cursor`, and the cursor for the next page,
+// which is empty when there are no more keys.
+//
+// Keys are returned in key order, with a parent before its children. Each
+// page seeks to its cursor rather than walking the keys before it.
+func (ts *TreeStore) GetMatchingKeysPage(skPattern StoreKey, cursor PageCursor, limit int, leaves bool) (keys []*KeyMatch, next PageCursor, err error) {
+	after, err := cursor.tokens()
+	if err != nil {
+		return
+	}
+
+	keys = []*KeyMatch{}
+
+	if limit <= 0 {
+		return
+	}
+
+	if len(skPattern.Tokens) == 0 {
+		// the sentinel is the only match, and a cursor can only follow it
+		if after == nil {
+			keys = ts.GetMatchingKeys(skPattern, 0, 1, leaves)
+		}
+		return
+	}
+
+	var lastKey TokenPath
+	ts.iterateFull(skPattern, 0, after, nil, func(km *KeyMatch, patternEnd bool) bool {
+		if leaves && km.HasChildren && !patternEnd {
+			return true
+		}
+		if len(keys) >= limit {
+			next = makePageCursor(TokenPathToTokenSet(lastKey))
+			return false
+		}
+		keys = append(keys, km)
+		lastKey = km.Key
+		return true
+	})
+
+	return
+}
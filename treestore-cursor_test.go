@@ -0,0 +1,155 @@
+package treestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func TestLevelKeysPage(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	base := MakeStoreKey("base")
+	for _, seg := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		ts.SetKeyValue(AppendStoreKeySegmentStrings(base, seg), seg)
+	}
+
+	keys, next, err := ts.GetLevelKeysPage(base, "*", "", 3)
+	if err != nil || len(keys) != 3 || string(keys[2].Segment) != "c" || next == "" {
+		t.Fatal("first page")
+	}
+
+	// keys inserted before the cursor don't shift the next page
+	ts.SetKey(AppendStoreKeySegmentStrings(base, "aa"))
+	ts.SetKey(AppendStoreKeySegmentStrings(base, "cc"))
+	ts.DeleteKey(AppendStoreKeySegmentStrings(base, "c"))
+
+	keys, next, err = ts.GetLevelKeysPage(base, "*", next, 3)
+	if err != nil || len(keys) != 3 || string(keys[0].Segment) != "cc" || string(keys[2].Segment) != "e" || next == "" {
+		t.Fatal("second page")
+	}
+
+	keys, next, err = ts.GetLevelKeysPage(base, "*", next, 3)
+	if err != nil || len(keys) != 2 || string(keys[1].Segment) != "g" || next != "" {
+		t.Fatal("last page")
+	}
+
+	// no match, and an exact fit, have no next page
+	keys, next, _ = ts.GetLevelKeysPage(base, "x*", "", 2)
+	if len(keys) != 0 || next != "" {
+		t.Error("no match page")
+	}
+	keys, next, _ = ts.GetLevelKeysPage(base, "a*", "", 2)
+	if len(keys) != 2 || next != "" {
+		t.Error("exact page")
+	}
+
+	keys, _, err = ts.GetLevelKeysPage(MakeStoreKey("missing"), "*", "", 3)
+	if keys != nil || err != nil {
+		t.Error("missing key")
+	}
+
+	if _, _, err = ts.GetLevelKeysPage(base, "*", "not*base64", 3); err != ErrInvalidCursor {
+		t.Error("bad cursor")
+	}
+	_, next, _ = ts.GetLevelKeysPage(base, "*", "", 1)
+	if _, _, err = ts.GetLevelKeysPage(MakeStoreKey("other"), "*", next, 3); err != ErrInvalidCursor {
+		t.Error("cursor of another key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestMatchingKeysPage(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 3; j++ {
+			ts.SetKeyValue(MakeStoreKey("a", fmt.Sprintf("%d", i), "c", fmt.Sprintf("%d", j)), i*10+j)
+		}
+		ts.SetKeyValue(MakeStoreKey("a", fmt.Sprintf("%d", i), "d"), i)
+	}
+
+	patterns := []StoreKey{
+		MakeStoreKey("**"),
+		MakeStoreKey("a", "*", "c", "*"),
+		MakeStoreKey("a", "**", "1"),
+		MakeStoreKey("a", "2", "*"),
+	}
+
+	for _, pattern := range patterns {
+		for _, leaves := range []bool{false, true} {
+			all := ts.GetMatchingKeys(pattern, 0, 1000, leaves)
+
+			paged := []*KeyMatch{}
+			cursor := PageCursor("")
+			for {
+				keys, next, err := ts.GetMatchingKeysPage(pattern, cursor, 4, leaves)
+				if err != nil {
+					t.Fatal(err)
+				}
+				paged = append(paged, keys...)
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+
+			if len(paged) != len(all) {
+				t.Fatalf("%s leaves=%v: %d paged of %d", pattern.Path, leaves, len(paged), len(all))
+			}
+			for i := range all {
+				if paged[i].Key != all[i].Key {
+					t.Errorf("%s leaves=%v: key %s at %d", pattern.Path, leaves, paged[i].Key, i)
+				}
+			}
+		}
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestMatchingKeysPageChanges(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	pattern := MakeStoreKey("k", "*")
+	for _, seg := range []string{"1", "3", "5", "7"} {
+		ts.SetKey(MakeStoreKey("k", seg))
+	}
+
+	keys, next, _ := ts.GetMatchingKeysPage(pattern, "", 2, false)
+	if len(keys) != 2 || keys[1].Key != "/k/3" {
+		t.Fatal("first page")
+	}
+
+	// the cursor key is deleted and keys are inserted on either side
+	ts.DeleteKey(MakeStoreKey("k", "3"))
+	ts.SetKey(MakeStoreKey("k", "2"))
+	ts.SetKey(MakeStoreKey("k", "4"))
+
+	keys, next, _ = ts.GetMatchingKeysPage(pattern, next, 2, false)
+	if len(keys) != 2 || keys[0].Key != "/k/4" || keys[1].Key != "/k/5" || next == "" {
+		t.Fatal("second page")
+	}
+
+	// a cursor below the pattern depth resumes with the next sibling
+	keys, _, _ = ts.GetMatchingKeysPage(pattern, makePageCursor(MakeStoreKey("k", "5", "x").Tokens), 2, false)
+	if len(keys) != 1 || keys[0].Key != "/k/7" {
+		t.Error("deep cursor")
+	}
+
+	keys, next, _ = ts.GetMatchingKeysPage(MakeStoreKey(), "", 2, false)
+	if len(keys) != 1 || next != "" {
+		t.Error("sentinel")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
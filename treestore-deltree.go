@@ -12,7 +12,31 @@ func (ts *TreeStore) DeleteKeyTree(sk StoreKey) (removed bool) {
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
-	return ts.deleteKeyTreeLocked(sk)
+	return ts.deleteKeyTreeLogged(sk)
+}
+
+// worker - performs DeleteKeyTree; the caller must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) deleteKeyTreeLogged(sk StoreKey) (removed bool) {
+	var events []*KeyEvent
+	if len(sk.Tokens) == 0 {
+		// only the sentinel value is cleared
+		if ev := ts.keyEventLocked(sk); ev != nil && ev.HasValue {
+			events = append(events, ev)
+		}
+	} else {
+		events = ts.keyTreeEventsLocked(sk)
+	}
+
+	found, _ := ts.locateDeleteTargetLocked(sk)
+	removed = ts.deleteKeyTreeLocked(sk)
+	if found {
+		ts.logMutation(&walRecord{Op: walDeleteKeyTree, Sk: sk.Tokens})
+	}
+
+	for _, ev := range events {
+		ts.raiseKeyEvent(ev)
+	}
+	return
 }
 
 func (ts *TreeStore) deleteKeyTreeLocked(sk StoreKey) (removed bool) {
@@ -31,18 +55,24 @@ func (ts *TreeStore) deleteKeyTreeLocked(sk StoreKey) (removed bool) {
 		removed = true
 	}
 
+	ts.preserveKeyNode(kn)
 	ts.removeAutoLinks(sk.Tokens, kn, true)
 	ts.discardChildren(sk, kn)
 
-	if ts.removeKeyFromIndexLocked(sk) || expired {
+	if (ts.removeKeyFromIndexLocked(sk) || expired) && kn.current != nil {
 		kn.current = nil
+		ts.bumpKeyVersion(kn)
 	}
 	kn.history = nil
+	kn.historyPruned = false
 	kn.metadata = nil
 
 	if kn.nextLevel == nil {
 		// permanently delete the node
+		ts.preserveRemovedKeyNode(level, kn)
 		delete(ts.addresses, kn.address)
+		ts.dropKeyVersion(kn)
+		ts.dropKeyExpiration(kn)
 		level.tree.Delete(kn.key)
 		kn.ownerTree = nil
 
@@ -50,6 +80,7 @@ func (ts *TreeStore) deleteKeyTreeLocked(sk StoreKey) (removed bool) {
 		if level.tree.nodes == 0 {
 			parent := level.parent
 			if parent != nil {
+				ts.preserveKeyNode(parent)
 				parent.nextLevel = nil
 				level.parent = nil
 			}
@@ -64,6 +64,17 @@ func (ts *TreeStore) DiagDump() bool {
 		}
 	}
 
+	ts.expirations.mu.Lock()
+	for pos, entry := range ts.expirations.entries {
+		kn := entry.kn
+		if ts.addresses[kn.address] != kn {
+			treeStoreDump.errors = append(treeStoreDump.errors, fmt.Sprintf("expiration index entry %d key node %p is not in the address index", pos, kn))
+		} else if kn.expiryPos != pos+1 || kn.expiration != entry.expiration {
+			treeStoreDump.errors = append(treeStoreDump.errors, fmt.Sprintf("expiration index entry %d mismatches key node address %04X", pos, kn.address))
+		}
+	}
+	ts.expirations.mu.Unlock()
+
 	for _, err := range treeStoreDump.errors {
 		fmt.Printf("error: %s\n", err)
 	}
@@ -0,0 +1,233 @@
+package treestore
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	// A key node with an expiration has one entry, and the key node holds
+	// the position of its entry so that the entry can be updated or removed
+	// when the expiration changes or the key is deleted.
+	expirationEntry struct {
+		expiration int64
+		kn         *keyNode
+	}
+
+	expirationHeap []expirationEntry
+
+	expirationIndex struct {
+		mu      sync.Mutex
+		entries expirationHeap
+	}
+
+	expirationSweeper struct {
+		stop chan struct{}
+		done chan struct{}
+	}
+)
+
+func (eh expirationHeap) Len() int           { return len(eh) }
+func (eh expirationHeap) Less(i, j int) bool { return eh[i].expiration < eh[j].expiration }
+func (eh expirationHeap) Swap(i, j int) {
+	eh[i], eh[j] = eh[j], eh[i]
+	eh[i].kn.expiryPos = i + 1
+	eh[j].kn.expiryPos = j + 1
+}
+
+func (eh *expirationHeap) Push(x any) {
+	entry := x.(expirationEntry)
+	*eh = append(*eh, entry)
+	entry.kn.expiryPos = len(*eh)
+}
+
+func (eh *expirationHeap) Pop() any {
+	old := *eh
+	n := len(old)
+	entry := old[n-1]
+	entry.kn.expiryPos = 0
+	old[n-1] = expirationEntry{}
+	*eh = old[:n-1]
+	return entry
+}
+
+// worker - sets the expiration of a key node and updates its entry in the
+// expiration index; the caller must hold a write lock on the key node
+func (ts *TreeStore) setKeyExpiration(kn *keyNode, expiration int64) {
+	ts.preserveKeyNode(kn)
+	kn.expiration = expiration
+
+	ts.expirations.mu.Lock()
+	defer ts.expirations.mu.Unlock()
+
+	if kn.expiryPos > 0 {
+		if expiration > 0 {
+			ts.expirations.entries[kn.expiryPos-1].expiration = expiration
+			heap.Fix(&ts.expirations.entries, kn.expiryPos-1)
+		} else {
+			heap.Remove(&ts.expirations.entries, kn.expiryPos-1)
+		}
+	} else if expiration > 0 {
+		heap.Push(&ts.expirations.entries, expirationEntry{expiration: expiration, kn: kn})
+	}
+}
+
+// worker - removes the expiration index entry of a key node that is being
+// deleted; the caller must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) dropKeyExpiration(kn *keyNode) {
+	ts.expirations.mu.Lock()
+	defer ts.expirations.mu.Unlock()
+
+	if kn.expiryPos > 0 {
+		heap.Remove(&ts.expirations.entries, kn.expiryPos-1)
+	}
+}
+
+// worker - rebuilds the expiration index from the key nodes; the caller must
+// hold the exclusive lock
+func (ts *TreeStore) reindexExpirations() {
+	entries := expirationHeap{}
+	for _, kn := range ts.addresses {
+		if kn.expiration > 0 {
+			entries = append(entries, expirationEntry{expiration: kn.expiration, kn: kn})
+			kn.expiryPos = len(entries)
+		} else {
+			kn.expiryPos = 0
+		}
+	}
+	heap.Init(&entries)
+
+	ts.expirations.mu.Lock()
+	ts.expirations.entries = entries
+	ts.expirations.mu.Unlock()
+}
+
+// worker - removes the earliest index entry if it is due at `now`
+func (ts *TreeStore) popExpiration(now int64) (entry expirationEntry, found bool) {
+	ts.expirations.mu.Lock()
+	defer ts.expirations.mu.Unlock()
+
+	// the same comparison as keyNode.isExpired
+	if len(ts.expirations.entries) > 0 && ts.expirations.entries[0].expiration < now {
+		entry = heap.Pop(&ts.expirations.entries).(expirationEntry)
+		found = true
+	}
+	return
+}
+
+// worker - determines if any key is expired at `now`, from the earliest entry
+// of the expiration index
+func (ts *TreeStore) mayHaveExpiredKeys(now int64) bool {
+	ts.expirations.mu.Lock()
+	defer ts.expirations.mu.Unlock()
+
+	return len(ts.expirations.entries) > 0 && ts.expirations.entries[0].expiration < now
+}
+
+// Deletes up to `limit` expired keys, and returns the number deleted. An
+// expired key that has children loses its value history, metadata and
+// expiration, and remains as a parent of the children.
+//
+// The key node linkage is locked for the duration of the call, so a large
+// number of expired keys should be swept in several calls. See also
+// EnableExpirationSweeper.
+func (ts *TreeStore) SweepExpiredKeys(limit int) (removed int) {
+	// modifies the linkage of keynodes
+	ts.keyNodeMu.Lock()
+	defer ts.sanityCheck()
+	defer ts.keyNodeMu.Unlock()
+
+	now := ts.expirationTick()
+	for removed < limit {
+		entry, found := ts.popExpiration(now)
+		if !found {
+			break
+		}
+
+		sk := MakeStoreKeyFromTokenSegments(ts.getTokenSet(entry.kn)...)
+		ev := ts.keyEventLocked(sk)
+		ts.deleteKeyLocked(sk)
+		ts.logMutation(&walRecord{Op: walDeleteKey, Sk: sk.Tokens})
+		removed++
+
+		if ev != nil {
+			ev.Kind = KeyEventExpired
+			ts.raiseKeyEvent(ev)
+		}
+	}
+
+	return
+}
+
+// Starts deleting expired keys in the background. Every `interval`, expired
+// keys are swept in batches of `batchSize`, releasing the lock between
+// batches so that other operations can proceed.
+//
+// Call Close to stop the sweeper.
+func (ts *TreeStore) EnableExpirationSweeper(l lane.Lane, interval time.Duration, batchSize int) (err error) {
+	if interval <= 0 || batchSize <= 0 {
+		err = errors.New("sweeper interval and batch size must be positive")
+		l.Errorf("failed to enable expiration sweeper: %s", err.Error())
+		return
+	}
+
+	es := &expirationSweeper{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if !ts.sweeper.CompareAndSwap(nil, es) {
+		err = errors.New("expiration sweeper is already enabled")
+		l.Errorf("failed to enable expiration sweeper: %s", err.Error())
+		return
+	}
+
+	go ts.sweeperRoutine(l, es, interval, batchSize)
+	return
+}
+
+func (ts *TreeStore) sweeperRoutine(l lane.Lane, es *expirationSweeper, interval time.Duration, batchSize int) {
+	defer close(es.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-es.stop:
+			return
+		case <-ticker.C:
+		}
+
+		total := 0
+		for {
+			removed := ts.SweepExpiredKeys(batchSize)
+			total += removed
+			if removed < batchSize {
+				break
+			}
+
+			select {
+			case <-es.stop:
+				return
+			default:
+			}
+		}
+
+		if total > 0 {
+			l.Tracef("treestore: expiration sweep: removed:%d", total)
+		}
+	}
+}
+
+// worker - stops the background sweeper, if enabled
+func (ts *TreeStore) stopExpirationSweeper() {
+	if es := ts.sweeper.Swap(nil); es != nil {
+		close(es.stop)
+		<-es.done
+	}
+}
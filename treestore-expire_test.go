@@ -2,7 +2,9 @@ package treestore
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/jimsnab/go-lane"
 )
@@ -118,3 +120,194 @@ func TestExpireReuseValue(t *testing.T) {
 		t.Error("final diag dump")
 	}
 }
+
+func TestSweepExpiredKeys(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for i := 0; i < 5; i++ {
+		ts.SetKeyValueEx(MakeStoreKey("temp", fmt.Sprintf("%d", i)), i, 0, 1, nil)
+	}
+
+	// expired, but has a live child
+	ts.SetKeyValue(MakeStoreKey("parent"), "p")
+	ts.SetKeyValue(MakeStoreKey("parent", "child"), "c")
+	ts.SetKeyValueTtl(MakeStoreKey("parent"), 1)
+
+	// replaced before the sweep
+	ts.SetKeyValueEx(MakeStoreKey("saved"), "x", 0, 1, nil)
+	ts.SetKeyValue(MakeStoreKey("saved"), "s")
+
+	// not yet expired
+	ts.SetKeyValueEx(MakeStoreKey("later"), "l", 0, time.Now().Add(time.Hour).UnixNano(), nil)
+
+	addrCount := len(ts.addresses)
+
+	if removed := ts.SweepExpiredKeys(4); removed != 4 {
+		t.Errorf("first batch %d", removed)
+	}
+	if removed := ts.SweepExpiredKeys(100); removed != 2 {
+		t.Errorf("second batch %d", removed)
+	}
+	if removed := ts.SweepExpiredKeys(100); removed != 0 {
+		t.Errorf("third batch %d", removed)
+	}
+
+	if len(ts.addresses) != addrCount-5 {
+		t.Errorf("addresses %d", len(ts.addresses))
+	}
+
+	for i := 0; i < 5; i++ {
+		if _, exists := ts.keys[TokenPath(fmt.Sprintf("/temp/%d", i))]; exists {
+			t.Error("value index")
+		}
+	}
+
+	kn := ts.addresses[ts.keys["/parent/child"]]
+	if kn == nil || kn.ownerTree.parent.current != nil || kn.ownerTree.parent.history != nil {
+		t.Error("expired parent")
+	}
+
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("parent", "child"))
+	if val != "c" {
+		t.Error("child value")
+	}
+
+	val, _, _ = ts.GetKeyValue(MakeStoreKey("saved"))
+	if val != "s" {
+		t.Error("replaced value")
+	}
+
+	val, _, _ = ts.GetKeyValue(MakeStoreKey("later"))
+	if val != "l" {
+		t.Error("future expiration")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestExpirationIndexBounded(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	later := time.Now().Add(time.Hour).UnixNano()
+	for i := 0; i < 10; i++ {
+		sk := MakeStoreKey("temp", fmt.Sprintf("%d", i))
+		ts.SetKeyValueEx(sk, i, 0, later, nil)
+		for j := 0; j < 10; j++ {
+			ts.SetKeyTtl(sk, later+int64(j))
+			ts.SetKeyValueEx(sk, j, 0, later-int64(j), nil)
+		}
+	}
+
+	if len(ts.expirations.entries) != 10 {
+		t.Errorf("entries after updates %d", len(ts.expirations.entries))
+	}
+
+	ts.SetKeyTtl(MakeStoreKey("temp", "0"), 0)
+	ts.DeleteKey(MakeStoreKey("temp", "1"))
+	ts.DeleteKeyTree(MakeStoreKey("temp", "2"))
+
+	if len(ts.expirations.entries) != 7 {
+		t.Errorf("entries after removals %d", len(ts.expirations.entries))
+	}
+
+	ts.DeleteKeyTree(MakeStoreKey("temp"))
+
+	if len(ts.expirations.entries) != 0 {
+		t.Errorf("entries after tree delete %d", len(ts.expirations.entries))
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestExpirationBoundary(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	expiration := time.Now().Add(time.Hour).UnixNano()
+	ts.SetKeyValueEx(MakeStoreKey("temp"), 1, 0, expiration, nil)
+	kn := ts.addresses[ts.keys["/temp"]]
+
+	// a key is live through its expiration tick
+	if kn.isExpired(expiration) {
+		t.Error("expired at the expiration tick")
+	}
+	if _, found := ts.popExpiration(expiration); found {
+		t.Error("popped at the expiration tick")
+	}
+
+	if !kn.isExpired(expiration + 1) {
+		t.Error("not expired after the expiration tick")
+	}
+	if entry, found := ts.popExpiration(expiration + 1); !found || entry.kn != kn {
+		t.Error("not popped after the expiration tick")
+	}
+}
+
+func TestSweepExpiredAutoLink(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.DefineAutoLinkKey(MakeStoreKey("records"), MakeStoreKey("by-name"), []SubPath{MakeSubPath("name")})
+	expiration := time.Now().Add(time.Millisecond * 10).UnixNano()
+	ts.SetKeyValueEx(MakeStoreKey("records", "1", "name", "fred"), nil, 0, expiration, nil)
+	ts.SetKey(MakeStoreKey("records", "2", "name", "wilma"))
+
+	_, exists := ts.LocateKey(MakeStoreKey("by-name", "fred"))
+	if !exists {
+		t.Fatal("auto-link not created")
+	}
+
+	time.Sleep(time.Millisecond * 20)
+	ts.SweepExpiredKeys(100)
+
+	_, exists = ts.LocateKey(MakeStoreKey("by-name", "fred"))
+	if exists {
+		t.Error("auto-link not removed")
+	}
+	_, exists = ts.LocateKey(MakeStoreKey("by-name", "wilma"))
+	if !exists {
+		t.Error("auto-link removed")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestExpirationSweeper(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableExpirationSweeper(ts.l, time.Millisecond, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	expiration := time.Now().Add(time.Millisecond * 10).UnixNano()
+	for i := 0; i < 5; i++ {
+		ts.SetKeyValueEx(MakeStoreKey("temp", fmt.Sprintf("%d", i)), i, 0, expiration, nil)
+	}
+
+	for i := 0; i < 1000; i++ {
+		ts.keyNodeMu.RLock()
+		remaining := len(ts.addresses)
+		ts.keyNodeMu.RUnlock()
+		if remaining == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sentinel and /temp
+	if len(ts.addresses) != 2 {
+		t.Errorf("addresses %d", len(ts.addresses))
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
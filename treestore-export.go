@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 )
 
 type (
@@ -58,7 +57,7 @@ func (ts *TreeStore) Export(sk StoreKey) (jsonData []byte, err error) {
 
 // worker that serializes the key node and its children
 func (ts *TreeStore) exportNode(rootSk StoreKey, kn *keyNode) (en *exportedNode, err error) {
-	now := time.Now().UnixNano()
+	now := ts.expirationTick()
 
 	en = &exportedNode{
 		Metadata: kn.metadata,
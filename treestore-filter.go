@@ -0,0 +1,191 @@
+package treestore
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Knetic/govaluate"
+)
+
+type (
+	// A key that passed the filter as it was visited, or one that must be
+	// evaluated again because the filter calls lookup()
+	filterEntry struct {
+		kvm    *KeyValueMatch
+		tokens TokenSet
+	}
+)
+
+// returned by lookup() while the level locks of a walk are held
+var errLookupDeferred = errors.New("lookup deferred")
+
+// Like GetMatchingKeyValues, but returns only the keys for which the `filter`
+// expression is true. `startAt` and `limit` count the keys that pass the filter.
+//
+// The expression language is the one of CalculateKeyValue. The key's value is
+// accessed with variable 'self', and other values with lookup(), where a path
+// without an initial slash is a child of the matching key, for example:
+//
+//	`self > 100 && lookup("status") == "open"`
+//
+// Numeric values are compared as 64-bit floating point. A key is skipped if
+// its expression fails, such as when a lookup() value doesn't exist, or has a
+// result other than true.
+//
+// The expression is evaluated as each key is visited. When it calls lookup(),
+// the key is evaluated again after the level locks are released, in batches,
+// so that the lookup can lock the level of the key it reads. An error is
+// returned if the expression is invalid.
+func (ts *TreeStore) GetMatchingKeyValuesFiltered(skPattern StoreKey, filter string, startAt, limit int) (values []*KeyValueMatch, err error) {
+	var matchSk StoreKey
+	var locked bool
+	functions := expressionFunctions(func(subpath string) (any, error) {
+		if !locked {
+			return nil, errLookupDeferred
+		}
+
+		subSk := resolveExpressionPath(matchSk, subpath)
+		ll, tokenIndex, kn, expired := ts.locateKeyNodeForReadLocked(subSk)
+		defer ts.completeKeyNodeRead(ll)
+		if tokenIndex < len(subSk.Tokens) || expired || kn.current == nil {
+			return nil, fmt.Errorf("value doesn't exist: %s", string(subSk.Path))
+		}
+		return expressionValue(kn.current.value), nil
+	})
+
+	expr, err := govaluate.NewEvaluableExpressionWithFunctions(filter, functions)
+	if err != nil {
+		return
+	}
+
+	values = []*KeyValueMatch{}
+	if limit <= 0 {
+		return
+	}
+
+	evaluate := func(value any) (passed, deferred bool) {
+		result, evalErr := expr.Evaluate(map[string]any{"self": expressionValue(value)})
+		if evalErr != nil {
+			return false, errors.Is(evalErr, errLookupDeferred)
+		}
+		passed, _ = result.(bool)
+		return
+	}
+
+	n := 0
+	emit := func(kvm *KeyValueMatch) bool {
+		if n >= startAt {
+			values = append(values, kvm)
+			if len(values) >= limit {
+				return false
+			}
+		}
+		n++
+		return true
+	}
+
+	var after TokenSet
+	for {
+		var entries []filterEntry
+		done := true
+
+		ts.walkMatchingKeys(skPattern, after, func(segments []TokenSegment, kn *keyNode, patternEnd bool) bool {
+			if kn.current == nil {
+				return true
+			}
+
+			passed, deferred := evaluate(kn.current.value)
+			if deferred {
+				entries = append(entries, filterEntry{tokens: append(TokenSet{}, segments...)})
+			} else if passed {
+				if len(entries) > 0 {
+					// keys are returned in order, after the deferred keys
+					entries = append(entries, filterEntry{kvm: keyValueMatchOf(segments, kn)})
+				} else if !emit(keyValueMatchOf(segments, kn)) {
+					return false
+				}
+			}
+
+			if len(entries) >= streamBatchSize {
+				after = append(TokenSet{}, segments...)
+				done = false
+				return false
+			}
+			return true
+		})
+
+		if len(entries) > 0 {
+			ts.keyNodeMu.RLock()
+			locked = true
+			for _, entry := range entries {
+				kvm := entry.kvm
+				if kvm == nil {
+					matchSk = MakeStoreKeyFromTokenSegments(entry.tokens...)
+					if kvm = ts.filterDeferredLocked(matchSk, evaluate); kvm == nil {
+						continue
+					}
+				}
+				if !emit(kvm) {
+					break
+				}
+			}
+			locked = false
+			ts.keyNodeMu.RUnlock()
+		}
+
+		if done || len(values) >= limit {
+			return
+		}
+	}
+}
+
+// worker - evaluates the filter of a key whose evaluation called lookup(),
+// and describes the key if it passes; the caller must hold a read lock on
+// ts.keyNodeMu
+func (ts *TreeStore) filterDeferredLocked(sk StoreKey, evaluate func(value any) (passed, deferred bool)) (kvm *KeyValueMatch) {
+	ll, tokenIndex, kn, expired := ts.locateKeyNodeForReadLocked(sk)
+	if tokenIndex < len(sk.Tokens) || expired || kn.current == nil {
+		ts.completeKeyNodeRead(ll)
+		return
+	}
+	value := kn.current.value
+	ts.completeKeyNodeRead(ll)
+
+	// lookup() locks the level of the key it reads
+	if passed, _ := evaluate(value); !passed {
+		return
+	}
+
+	ll, tokenIndex, kn, expired = ts.locateKeyNodeForReadLocked(sk)
+	defer ts.completeKeyNodeRead(ll)
+	if tokenIndex < len(sk.Tokens) || expired || kn.current == nil {
+		return
+	}
+	return keyValueMatchOf(sk.Tokens, kn)
+}
+
+// worker - describes a matching key node that has a current value; the caller
+// must hold a read lock on the level of the key node
+func keyValueMatchOf(segments []TokenSegment, kn *keyNode) *KeyValueMatch {
+	kvm := &KeyValueMatch{
+		Key:           TokenSetToTokenPath(segments),
+		HasChildren:   kn.nextLevel != nil,
+		CurrentValue:  kn.current.value,
+		Relationships: kn.current.relationships,
+	}
+	if kn.metadata != nil {
+		kvm.Metadata = kn.metadata
+	}
+	return kvm
+}
+
+// worker - converts a numeric value to the float64 used by the expression
+// operators, and passes other values through
+func expressionValue(x any) any {
+	switch x.(type) {
+	case int, int64, uint, float64:
+		return floatConverter(x)
+	default:
+		return x
+	}
+}
@@ -0,0 +1,122 @@
+package treestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func TestMatchingKeyValuesFiltered(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	statuses := []string{"open", "closed"}
+	for i := 0; i < 10; i++ {
+		ticket := MakeStoreKey("tickets", fmt.Sprintf("%d", i))
+		ts.SetKeyValue(ticket, i*30)
+		ts.SetKeyValue(AppendStoreKeySegmentStrings(ticket, "status"), statuses[i%2])
+	}
+	ts.SetKeyValue(MakeStoreKey("threshold"), 100)
+	ts.SetKey(MakeStoreKey("tickets", "10"))
+	ts.SetKeyValue(MakeStoreKey("tickets", "11"), "n/a")
+
+	pattern := MakeStoreKey("tickets", "*")
+	values, err := ts.GetMatchingKeyValuesFiltered(pattern, `self > 100 && lookup("status") == "open"`, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, kvm := range values {
+		keys = append(keys, string(kvm.Key))
+	}
+	if fmt.Sprint(keys) != "[/tickets/4 /tickets/6 /tickets/8]" {
+		t.Errorf("filtered %v", keys)
+	}
+	if values[0].CurrentValue != 120 {
+		t.Error("value type")
+	}
+
+	// startAt and limit count the filtered keys; absolute lookups work too
+	values, _ = ts.GetMatchingKeyValuesFiltered(pattern, `self >= lookup("/threshold")`, 1, 2)
+	if len(values) != 2 || values[0].Key != "/tickets/5" || values[1].Key != "/tickets/6" {
+		t.Error("paged filter")
+	}
+
+	values, _ = ts.GetMatchingKeyValuesFiltered(MakeStoreKey("tickets", "*", "status"), `self == "closed"`, 0, 100)
+	if len(values) != 5 {
+		t.Error("string filter")
+	}
+
+	// a non-boolean result, or a failed lookup, skips the key
+	if values, _ = ts.GetMatchingKeyValuesFiltered(pattern, `self + 1`, 0, 100); len(values) != 0 {
+		t.Error("non-boolean result")
+	}
+	if values, _ = ts.GetMatchingKeyValuesFiltered(pattern, `lookup("missing") == 1`, 0, 100); len(values) != 0 {
+		t.Error("missing lookup")
+	}
+
+	if _, err = ts.GetMatchingKeyValuesFiltered(pattern, `self >`, 0, 100); err == nil {
+		t.Error("invalid filter")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestMatchingKeyValuesFilteredBatches(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	count := streamBatchSize*2 + 10
+	for i := 0; i < count; i++ {
+		ticket := MakeStoreKey("tickets", fmt.Sprintf("%04d", i))
+		ts.SetKeyValue(ticket, i)
+		if i%2 == 0 {
+			ts.SetKeyValue(AppendStoreKeySegmentStrings(ticket, "status"), "open")
+		}
+	}
+
+	pattern := MakeStoreKey("tickets", "*")
+	snap := ts.Snapshot()
+	defer snap.Release()
+	ts.DeleteKeyTree(MakeStoreKey("tickets", "0000"))
+
+	// keys that call lookup() are returned in order with the other keys
+	values, err := snap.GetMatchingKeyValuesFiltered(pattern, `self % 3 == 0 || lookup("status") == "open"`, 5, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{}
+	for i := 0; i < count; i++ {
+		if i%3 == 0 || i%2 == 0 {
+			expected = append(expected, fmt.Sprintf("/tickets/%04d", i))
+		}
+	}
+	expected = expected[5:]
+
+	keys := []string{}
+	for _, kvm := range values {
+		keys = append(keys, string(kvm.Key))
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Errorf("filtered %d keys, expected %d", len(keys), len(expected))
+	}
+
+	ts.SetKeyValue(MakeStoreKey("tickets"), "all")
+	values, _ = ts.GetMatchingKeyValuesFiltered(MakeStoreKey("tickets"), `lookup("0002/status") == "open"`, 0, 1)
+	if len(values) != 1 {
+		t.Error("lookup below the matching key")
+	}
+
+	// the lookup reads the snapshot
+	values, _ = snap.GetMatchingKeyValuesFiltered(MakeStoreKey("tickets", "0002"), `lookup("/tickets/0000/status") == "open"`, 0, 1)
+	if len(values) != 1 {
+		t.Error("snapshot lookup")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
@@ -0,0 +1,570 @@
+package treestore
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+type (
+	// The content of the store at the moment a freeze was taken, which can be
+	// read while writers continue.
+	//
+	// While a freeze is held, a writer preserves the content of a key node
+	// before it first changes it, and preserves a key node that it removes
+	// from a level. A key node created after the freeze is recognized by its
+	// address. The frozen content of a key node is then its preserved
+	// content, if any, and its live content otherwise.
+	storeFreeze struct {
+		mu             sync.Mutex
+		tick           int64
+		nextAddress    uint64
+		walSequence    uint64
+		versionCounter uint64
+		retention      HistoryRetention
+		snapshotOpts   SnapshotOptions
+		nodes          map[*keyNode]*keyNode
+		removed        map[*keyTree][]*keyNode
+		versions       map[StoreAddress]uint64
+		cas            map[StoreAddress]uint64 // the versions in effect, once the store content is replaced
+	}
+
+	// Reads the key nodes of the store in the order that they are saved,
+	// either as they are, or as they were frozen.
+	storeWalker struct {
+		ts     *TreeStore
+		f      *storeFreeze // nil to read the live content
+		locked bool         // the caller holds the exclusive lock
+	}
+
+	walkedKeyNode struct {
+		dkn       diskKeyNode
+		nextLevel *keyTree
+	}
+)
+
+// the number of key nodes read from a level while the level is locked
+const walkChunkSize = 1024
+
+// Starts preserving the content of the store as it is now. The exclusive
+// lock is held only to register the freeze. Call thaw when finished.
+func (ts *TreeStore) freeze() (f *storeFreeze) {
+	ts.acquireExclusiveLock()
+	f = ts.freezeLocked()
+	ts.releaseExclusiveLock()
+	return
+}
+
+// worker - registers a freeze; the caller must hold the exclusive lock
+func (ts *TreeStore) freezeLocked() (f *storeFreeze) {
+	f = &storeFreeze{
+		tick:           ts.expirationTick(),
+		nextAddress:    ts.nextAddress.Load(),
+		walSequence:    ts.walSequence.Load(),
+		versionCounter: ts.cas[0],
+		retention:      ts.retention,
+		snapshotOpts:   ts.snapshotOpts,
+		nodes:          map[*keyNode]*keyNode{},
+		removed:        map[*keyTree][]*keyNode{},
+		versions:       map[StoreAddress]uint64{},
+	}
+	ts.freezes = append(ts.freezes, f)
+	return
+}
+
+// Stops preserving content for the freeze, and releases what it preserved.
+func (ts *TreeStore) thaw(f *storeFreeze) {
+	ts.acquireExclusiveLock()
+	ts.thawLocked(f)
+	ts.releaseExclusiveLock()
+}
+
+// worker - unregisters a freeze; the caller must hold the exclusive lock
+func (ts *TreeStore) thawLocked(f *storeFreeze) {
+	for i, other := range ts.freezes {
+		if other == f {
+			ts.freezes = append(ts.freezes[:i:i], ts.freezes[i+1:]...)
+			break
+		}
+	}
+	if len(ts.freezes) == 0 {
+		ts.freezes = nil
+	}
+}
+
+// worker - determines if a key node is part of the frozen content, given that
+// it has not been preserved; the caller must hold f.mu
+func (f *storeFreeze) existedLocked(kn *keyNode) bool {
+	return kn.address != 0 && uint64(kn.address) <= f.nextAddress
+}
+
+// worker - preserves the content of a key node before its first change
+// after the freeze
+func (f *storeFreeze) preserveKeyNode(kn *keyNode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, preserved := f.nodes[kn]; preserved || !f.existedLocked(kn) {
+		return
+	}
+
+	pkn := &keyNode{
+		key:        kn.key,
+		address:    kn.address,
+		ownerTree:  kn.ownerTree,
+		nextLevel:  kn.nextLevel,
+		expiration: kn.expiration,
+		metadata:   cloneMetadata(kn.metadata),
+		autoLinks:  cloneAutoLinks(kn.autoLinks),
+	}
+	pkn.current, pkn.history = cloneValues(kn.current, kn.history)
+	pkn.historyPruned = kn.historyPruned
+	f.nodes[kn] = pkn
+}
+
+// worker - preserves the content of a key node before a writer changes it;
+// the caller must hold the write lock on the level of the key node, or on
+// ts.keyNodeMu
+func (ts *TreeStore) preserveKeyNode(kn *keyNode) {
+	for _, f := range ts.freezes {
+		f.preserveKeyNode(kn)
+	}
+}
+
+// worker - preserves a key node before it is removed from `level`; the caller
+// must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) preserveRemovedKeyNode(level *keyTree, kn *keyNode) {
+	for _, f := range ts.freezes {
+		f.preserveKeyNode(kn)
+
+		f.mu.Lock()
+		if _, preserved := f.nodes[kn]; preserved {
+			f.removed[level] = append(f.removed[level], kn)
+		}
+		f.mu.Unlock()
+	}
+}
+
+// worker - preserves the version of a key node before it changes; the caller
+// must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) preserveKeyVersion(addr StoreAddress) {
+	for _, f := range ts.freezes {
+		f.mu.Lock()
+		if _, preserved := f.versions[addr]; !preserved && uint64(addr) <= f.nextAddress {
+			f.versions[addr] = ts.cas[addr]
+		}
+		f.mu.Unlock()
+	}
+}
+
+// worker - preserves the store content before it is replaced, as by Load or
+// Purge; the caller must hold the exclusive lock
+func (ts *TreeStore) preserveStoreContent() {
+	ts.preserveKeyNode(&ts.dbNode)
+	for _, f := range ts.freezes {
+		f.mu.Lock()
+		if f.cas == nil {
+			// the replaced versions map is not changed again
+			f.cas = ts.cas
+		}
+		f.mu.Unlock()
+	}
+}
+
+// worker - restores the content of the store to the freeze, which must have
+// been taken while the caller held the exclusive lock that it holds now.
+// Addresses and versions issued since the freeze are not reissued.
+func (ts *TreeStore) rollbackLocked(f *storeFreeze) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// put back the key nodes that were removed from their levels
+	for level, kns := range f.removed {
+		for _, kn := range kns {
+			level.tree.Set(f.nodes[kn].key, kn)
+		}
+
+		for _, other := range ts.freezes {
+			if other != f {
+				other.forgetRemoved(level, kns)
+			}
+		}
+	}
+
+	for kn, pkn := range f.nodes {
+		kn.key = pkn.key
+		kn.address = pkn.address
+		kn.ownerTree = pkn.ownerTree
+		kn.nextLevel = pkn.nextLevel
+		kn.expiration = pkn.expiration
+		kn.metadata = pkn.metadata
+		kn.autoLinks = pkn.autoLinks
+		kn.current = pkn.current
+		kn.history = pkn.history
+		kn.historyPruned = pkn.historyPruned
+		if kn.nextLevel != nil {
+			kn.nextLevel.parent = kn
+		}
+	}
+
+	for addr := range ts.cas {
+		if addr != 0 && uint64(addr) > f.nextAddress {
+			delete(ts.cas, addr)
+		}
+	}
+	for addr, version := range f.versions {
+		if version == 0 {
+			delete(ts.cas, addr)
+		} else {
+			ts.cas[addr] = version
+		}
+	}
+
+	// key nodes created since the freeze are removed while the indexes are
+	// rebuilt
+	ts.addresses = map[StoreAddress]*keyNode{ts.dbNode.address: &ts.dbNode}
+	ts.keys = map[TokenPath]StoreAddress{}
+	if ts.dbNode.current != nil {
+		ts.keys[""] = ts.dbNode.address
+	}
+	if ts.dbNode.nextLevel != nil {
+		ts.rollbackLevelLocked(f, ts.dbNode.nextLevel, TokenSet{})
+	}
+	ts.reindexExpirations()
+}
+
+// worker - removes the key nodes created since the freeze from `level` and
+// its children, and indexes the rest; the caller must hold f.mu
+func (ts *TreeStore) rollbackLevelLocked(f *storeFreeze, level *keyTree, tokens TokenSet) {
+	var created [][]byte
+	level.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+		kn := node.value
+		if _, preserved := f.nodes[kn]; !preserved && !f.existedLocked(kn) {
+			created = append(created, node.key)
+			return true
+		}
+
+		ts.addresses[kn.address] = kn
+		childTokens := append(append(TokenSet{}, tokens...), kn.key)
+		if kn.current != nil {
+			ts.keys[TokenSetToTokenPath(childTokens)] = kn.address
+		}
+		if kn.nextLevel != nil {
+			ts.rollbackLevelLocked(f, kn.nextLevel, childTokens)
+		}
+		return true
+	})
+
+	for _, key := range created {
+		level.tree.Delete(key)
+	}
+}
+
+// worker - drops the key nodes that a rollback put back into `level` from the
+// list of key nodes removed from it
+func (f *storeFreeze) forgetRemoved(level *keyTree, kns []*keyNode) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	restored := make(map[*keyNode]struct{}, len(kns))
+	for _, kn := range kns {
+		restored[kn] = struct{}{}
+	}
+
+	var removed []*keyNode
+	for _, kn := range f.removed[level] {
+		if _, found := restored[kn]; !found {
+			removed = append(removed, kn)
+		}
+	}
+	f.removed[level] = removed
+}
+
+// worker - provides the frozen content of a key node, if it is part of the
+// frozen content; the caller must hold a lock that prevents changes to `kn`
+func (sw *storeWalker) resolve(kn *keyNode) (content *keyNode, version uint64, exists bool) {
+	f := sw.f
+	if f == nil {
+		return kn, sw.ts.cas[kn.address], true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	content = f.nodes[kn]
+	if content == nil {
+		if !f.existedLocked(kn) {
+			return
+		}
+		content = kn
+	}
+
+	version = f.versionLocked(sw.ts.cas, content.address)
+	exists = true
+	return
+}
+
+// worker - locks the key node linkage and `level` for reading, unless the
+// caller holds the exclusive lock, and returns the unlock function
+func (sw *storeWalker) lockLevel(level *keyTree) func() {
+	if sw.locked {
+		return func() {}
+	}
+
+	sw.ts.keyNodeMu.RLock()
+	level.lock.RLock()
+	return func() {
+		level.lock.RUnlock()
+		sw.ts.keyNodeMu.RUnlock()
+	}
+}
+
+// worker - like lockLevel, for the level of the sentinel, which Purge replaces
+func (sw *storeWalker) lockSentinel() func() {
+	if sw.locked {
+		return func() {}
+	}
+
+	sw.ts.keyNodeMu.RLock()
+	level := sw.ts.dbNode.ownerTree
+	level.lock.RLock()
+	return func() {
+		level.lock.RUnlock()
+		sw.ts.keyNodeMu.RUnlock()
+	}
+}
+
+// Provides the snapshot options in effect when the walk began.
+func (sw *storeWalker) snapshotOptions() SnapshotOptions {
+	if sw.f != nil {
+		return sw.f.snapshotOpts
+	}
+	// the caller holds the exclusive lock
+	return sw.ts.snapshotOpts
+}
+
+// Provides the store content other than the key nodes.
+func (sw *storeWalker) content() (content diskContent) {
+	ts := sw.ts
+	unlock := sw.lockSentinel()
+	defer unlock()
+
+	dbNode, sentinelVersion, _ := sw.resolve(&ts.dbNode)
+	content = diskContent{
+		SentinelValues:        saveKeyValues(dbNode),
+		SentinelMetadata:      cloneMetadata(dbNode.metadata),
+		SentinelExpiration:    dbNode.expiration,
+		SentinelHistoryPruned: dbNode.historyPruned,
+	}
+
+	var versionCounter uint64
+	if sw.f == nil {
+		content.NextAddress = ts.nextAddress.Load()
+		content.WalSequence = ts.walSequence.Load()
+		content.Retention = ts.retention
+		versionCounter = ts.cas[0]
+	} else {
+		content.NextAddress = sw.f.nextAddress
+		content.WalSequence = sw.f.walSequence
+		content.Retention = sw.f.retention
+		versionCounter = sw.f.versionCounter
+	}
+
+	// the versions of the other key nodes are saved with the key nodes
+	if versionCounter != 0 {
+		content.Versions = append(content.Versions, diskKeyVersion{Address: 0, Version: versionCounter})
+	}
+	if sentinelVersion != 0 {
+		content.Versions = append(content.Versions, diskKeyVersion{Address: dbNode.address, Version: sentinelVersion})
+	}
+	return
+}
+
+// Calls `fn` for each key node below the sentinel, parents before children
+// and siblings in key order, stopping at the first error.
+func (sw *storeWalker) walk(fn func(dkn *diskKeyNode) error) error {
+	unlock := sw.lockSentinel()
+	dbNode, _, _ := sw.resolve(&sw.ts.dbNode)
+	level := dbNode.nextLevel
+	unlock()
+
+	return sw.walkLevel(level, uint64(dbNode.address), fn)
+}
+
+// worker - walks the key nodes of a level, reading them in chunks so that
+// the level is not locked for the duration
+func (sw *storeWalker) walkLevel(level *keyTree, parentAddress uint64, fn func(dkn *diskKeyNode) error) (err error) {
+	if level == nil {
+		return
+	}
+
+	var after []byte
+	for {
+		wkns := sw.readLevelChunk(level, parentAddress, after)
+		for _, wkn := range wkns {
+			if err = fn(&wkn.dkn); err != nil {
+				return
+			}
+			if err = sw.walkLevel(wkn.nextLevel, wkn.dkn.Address, fn); err != nil {
+				return
+			}
+		}
+
+		if len(wkns) < walkChunkSize {
+			return
+		}
+		after = wkns[len(wkns)-1].dkn.Key
+	}
+}
+
+// worker - reads up to walkChunkSize key nodes of a level that follow the
+// key `after`, or from the start if `after` is nil
+func (sw *storeWalker) readLevelChunk(level *keyTree, parentAddress uint64, after []byte) (wkns []walkedKeyNode) {
+	unlock := sw.lockLevel(level)
+	defer unlock()
+
+	// key nodes removed from the level since the freeze are merged in key order
+	var removed []*keyNode
+	if sw.f != nil {
+		sw.f.mu.Lock()
+		for _, kn := range sw.f.removed[level] {
+			if key := sw.f.nodes[kn].key; after == nil || bytes.Compare(key, after) > 0 {
+				removed = append(removed, sw.f.nodes[kn])
+			}
+		}
+		sw.f.mu.Unlock()
+		sort.Slice(removed, func(i, j int) bool {
+			return bytes.Compare(removed[i].key, removed[j].key) < 0
+		})
+	}
+
+	add := func(content *keyNode, version uint64) bool {
+		wkns = append(wkns, walkedKeyNode{
+			dkn: diskKeyNode{
+				Key:           content.key,
+				Address:       uint64(content.address),
+				ParentAddress: parentAddress,
+				Values:        saveKeyValues(content),
+				Expiration:    content.expiration,
+				Metadata:      cloneMetadata(content.metadata),
+				Indicies:      kiToDiskKi(content.autoLinks),
+				Version:       version,
+				HistoryPruned: content.historyPruned,
+			},
+			nextLevel: content.nextLevel,
+		})
+		return len(wkns) < walkChunkSize
+	}
+
+	addRemoved := func(before []byte) bool {
+		for len(removed) > 0 && (before == nil || bytes.Compare(removed[0].key, before) < 0) {
+			content := removed[0]
+			removed = removed[1:]
+			sw.f.mu.Lock()
+			version := sw.f.versionLocked(sw.ts.cas, content.address)
+			sw.f.mu.Unlock()
+			if !add(content, version) {
+				return false
+			}
+		}
+		return true
+	}
+
+	complete := level.tree.IterateRange(after, nil, false, func(node *avlNode[*keyNode]) bool {
+		if after != nil && bytes.Equal(node.key, after) {
+			return true
+		}
+		if !addRemoved(node.key) {
+			return false
+		}
+
+		content, version, exists := sw.resolve(node.value)
+		if !exists {
+			return true
+		}
+		return add(content, version)
+	})
+
+	if complete {
+		addRemoved(nil)
+	}
+	return
+}
+
+// worker - provides the frozen version of a key node, given the live
+// versions; the caller must hold f.mu
+func (f *storeFreeze) versionLocked(cas map[StoreAddress]uint64, addr StoreAddress) uint64 {
+	if version, preserved := f.versions[addr]; preserved {
+		return version
+	}
+	if f.cas != nil {
+		cas = f.cas
+	}
+	return cas[addr]
+}
+
+func cloneMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	out := make(map[string]string, len(metadata))
+	for attribute, value := range metadata {
+		out[attribute] = value
+	}
+	return out
+}
+
+func cloneAutoLinks(kals *keyAutoLinks) *keyAutoLinks {
+	if kals == nil {
+		return nil
+	}
+
+	out := &keyAutoLinks{
+		autoLinkMap: make(map[TokenPath]*keyAutoLinkDefinition, len(kals.autoLinkMap)),
+	}
+	for path, kald := range kals.autoLinkMap {
+		out.autoLinkMap[path] = &keyAutoLinkDefinition{
+			autoLinkSk: kald.autoLinkSk,
+			fields:     kald.fields,
+		}
+	}
+	return out
+}
+
+// relationships can be updated in place (such as by a key move), so the
+// value instances are copied; the current value remains the newest history entry
+func cloneValueInstance(vi *valueInstance) *valueInstance {
+	if vi == nil {
+		return nil
+	}
+
+	out := &valueInstance{
+		value: vi.value,
+	}
+	if vi.relationships != nil {
+		out.relationships = make([]StoreAddress, len(vi.relationships))
+		copy(out.relationships, vi.relationships)
+	}
+	return out
+}
+
+func cloneValues(current *valueInstance, history *avlTree[*valueInstance]) (outCurrent *valueInstance, outHistory *avlTree[*valueInstance]) {
+	if history == nil {
+		outCurrent = cloneValueInstance(current)
+		return
+	}
+
+	outHistory = history.Clone(func(vi *valueInstance) *valueInstance {
+		out := cloneValueInstance(vi)
+		if vi == current {
+			outCurrent = out
+		}
+		return out
+	})
+
+	if outCurrent == nil && current != nil {
+		outCurrent = cloneValueInstance(current)
+	}
+	return
+}
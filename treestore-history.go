@@ -0,0 +1,79 @@
+package treestore
+
+type (
+	// A value from the history of a key, with the Unix ns tick it was set.
+	HistoryValue struct {
+		Timestamp     int64
+		Value         any
+		Relationships []StoreAddress
+	}
+)
+
+// worker - converts a relative tick (the negative ns from now) to a Unix ns
+// tick; a time at or before the epoch becomes -1, which has no values
+func (ts *TreeStore) resolveTick(tickNs int64) int64 {
+	if tickNs < 0 {
+		tickNs += ts.expirationTick()
+	}
+	if tickNs <= 0 {
+		tickNs = -1
+	}
+	return tickNs
+}
+
+// Navigates to the key and returns up to `limit` values from its history
+// that were set from `fromNs` to `toNs` inclusive, in ascending time order,
+// or in descending order if `descending` is true. Specify `toNs` as 0 for no
+// upper limit.
+//
+// If more values are in the range, `nextNs` is the timestamp of the next
+// value. Pass it as `fromNs` (ascending) or `toNs` (descending) to fetch the
+// next page. Otherwise, `nextNs` is 0.
+//
+// The history kept for a key is subject to its retention policy. See
+// HistoryRetention.
+func (ts *TreeStore) GetKeyValueHistory(sk StoreKey, fromNs, toNs int64, limit int, descending bool) (values []*HistoryValue, nextNs int64) {
+	if limit <= 0 {
+		return
+	}
+
+	kn, ll := ts.getKeyNodeForValueRead(sk)
+	if kn == nil {
+		return
+	}
+	defer ts.completeKeyNodeRead(ll)
+
+	if kn.history == nil {
+		return
+	}
+
+	var low, high []byte
+	if fromNs > 0 {
+		low = unixTimestampBytes(fromNs)
+	}
+	if toNs > 0 {
+		high = unixTimestampBytes(toNs)
+	}
+
+	kn.history.IterateRange(low, high, descending, func(node *avlNode[*valueInstance]) bool {
+		tick := unixNsFromBytes(node.key)
+		if len(values) >= limit {
+			nextNs = tick
+			return false
+		}
+
+		vi := node.value
+		hv := &HistoryValue{
+			Timestamp: tick,
+			Value:     vi.value,
+		}
+		if vi.relationships != nil {
+			// relationships can be updated in place
+			hv.Relationships = make([]StoreAddress, len(vi.relationships))
+			copy(hv.Relationships, vi.relationships)
+		}
+		values = append(values, hv)
+		return true
+	})
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -42,13 +43,16 @@ func (ts *TreeStore) Import(sk StoreKey, jsonData []byte) (err error) {
 	}
 	ts.deferredRefs = nil
 
+	ts.logMutation(&walRecord{Op: walImport, Sk: sk.Tokens, JsonData: jsonData})
+	ts.watchTreeLocked(sk)
 	return
 }
 
 func (ts *TreeStore) restoreKey(rootPath TokenPath, sk StoreKey, kn *keyNode, en *exportedNode) (err error) {
 	if en != nil {
+		ts.preserveKeyNode(kn)
 		if en.Expiration != nil {
-			kn.expiration = *en.Expiration
+			ts.setKeyExpiration(kn, *en.Expiration)
 		}
 		if en.History != nil {
 			if len(en.History) == 1 && en.History[0].Timestamp == 0 {
@@ -60,6 +64,8 @@ func (ts *TreeStore) restoreKey(rootPath TokenPath, sk StoreKey, kn *keyNode, en
 			} else {
 				newest := int64(0)
 				kn.history = newAvlTree[*valueInstance]()
+				// the exported history might have been pruned
+				kn.historyPruned = true
 				for _, ev := range en.History {
 					var vi *valueInstance
 					if vi, err = ts.importValue(rootPath, kn.address, ev); err != nil {
@@ -72,6 +78,7 @@ func (ts *TreeStore) restoreKey(rootPath TokenPath, sk StoreKey, kn *keyNode, en
 				}
 			}
 			ts.keys[sk.Path] = kn.address
+			ts.bumpKeyVersion(kn)
 		}
 		kn.metadata = en.Metadata
 
@@ -79,7 +86,15 @@ func (ts *TreeStore) restoreKey(rootPath TokenPath, sk StoreKey, kn *keyNode, en
 			childLevel := newKeyTree(kn)
 			kn.nextLevel = childLevel
 
-			for childSeg, child := range en.Children {
+			// restore in sorted order so that addresses are assigned consistently
+			childSegs := make([]string, 0, len(en.Children))
+			for childSeg := range en.Children {
+				childSegs = append(childSegs, childSeg)
+			}
+			sort.Strings(childSegs)
+
+			for _, childSeg := range childSegs {
+				child := en.Children[childSeg]
 				childSk := AppendStoreKeySegmentStrings(sk, childSeg)
 				childKn := ts.appendKeyNode(childLevel, TokenStringToSegment(childSeg))
 				if err = ts.restoreKey(rootPath, childSk, childKn, child); err != nil {
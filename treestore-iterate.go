@@ -10,6 +10,11 @@ type (
 		Segment     TokenSegment `json:"segment"`
 		HasValue    bool         `json:"has_value"`
 		HasChildren bool         `json:"has_children"`
+
+		// The number of keys in the level below, including expired keys
+		// that have not been deleted yet; see CountLevelKeys for an exact
+		// count.
+		ChildCount int `json:"child_count"`
 	}
 
 	KeyMatch struct {
@@ -30,15 +35,56 @@ type (
 	}
 
 	iterateFullCallback func(km *KeyMatch, patternEnd bool) bool
+
+	// visits a key node matched by the full iteration, without making a KeyMatch
+	iterateFullVisitor func(segments []TokenSegment, kn *keyNode, patternEnd bool) bool
 )
 
 // Navigates to the specified store key and returns all of the key segments
-// matching the simple wildcard `pattern`. If the store key does not exist,
-// the return `keys` will be nil.
+// matching the segment `pattern`, which has the syntax described in
+// GetMatchingKeys. If the store key does not exist, the return `keys` will be
+// nil.
+//
+// When `pattern` is "*" and no key in the store is expired, the keys before
+// `startAt` are skipped without visiting them.
 //
 // Memory is allocated up front to hold `limit` keys, so be careful to pass
 // a reasonable limit.
 func (ts *TreeStore) GetLevelKeys(sk StoreKey, pattern string, startAt, limit int) (keys []LevelKey) {
+	keys = make([]LevelKey, 0, limit)
+
+	exists := ts.iterateLevelKeys(sk, pattern, nil, startAt, limit > 0, func(lk LevelKey) bool {
+		keys = append(keys, lk)
+		return len(keys) < limit
+	})
+
+	if !exists {
+		keys = nil
+	}
+	return
+}
+
+// Navigates to the specified store key and returns the number of keys in the
+// level below it. If the store key does not exist, `exists` will be false.
+//
+// The count is kept by the level, so the keys are visited only when some key
+// in the store is expired.
+func (ts *TreeStore) CountLevelKeys(sk StoreKey) (count int, exists bool) {
+	level, exists := ts.lockLevelBelow(sk)
+	if level == nil {
+		return
+	}
+
+	now := ts.expirationTick()
+	count = ts.countLevelKeys(level, now, ts.mayHaveExpiredKeys(now))
+	ts.completeKeyNodeRead(level)
+	return
+}
+
+// worker that read locks the level of keys below `sk`, and returns a nil level
+// if the store key does not exist or has no children; the caller must release
+// a non-nil level with completeKeyNodeRead
+func (ts *TreeStore) lockLevelBelow(sk StoreKey) (lockedLevel *keyTree, exists bool) {
 	var level *keyTree
 	var index int
 	var kn *keyNode
@@ -56,51 +102,99 @@ func (ts *TreeStore) GetLevelKeys(sk StoreKey, pattern string, startAt, limit in
 		level, index, kn, expired = ts.locateKeyNodeForRead(sk)
 	}
 
-	lockedLevel := level
-
 	if index < end || expired {
-		ts.completeKeyNodeRead(lockedLevel)
+		ts.completeKeyNodeRead(level)
 		return
 	}
 
-	keys = make([]LevelKey, 0, limit)
+	exists = true
 
 	nextLockedLevel := kn.nextLevel
 	if nextLockedLevel == nil {
 		// no children
-		ts.completeKeyNodeRead(lockedLevel)
+		ts.completeKeyNodeRead(level)
 		return
 	}
 
 	nextLockedLevel.lock.RLock()
-	lockedLevel.lock.RUnlock()
+	level.lock.RUnlock()
 	lockedLevel = nextLockedLevel
+	return
+}
+
+// worker that counts the unexpired keys of a level; the keys are visited only
+// if `mayBeExpired` is true
+func (ts *TreeStore) countLevelKeys(level *keyTree, now int64, mayBeExpired bool) (count int) {
+	if !mayBeExpired {
+		return level.tree.Count()
+	}
+
+	level.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+		if !node.value.isExpired(now) {
+			count++
+		}
+		return true
+	})
+	return
+}
 
-	if limit > 0 {
-		n := 0
-		patternRunes := []rune(pattern)
-		lockedLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+// worker that determines if a pattern matches every key segment
+func isMatchAllPattern(pattern string) bool {
+	return pattern != "" && strings.Trim(pattern, "*") == ""
+}
+
+// worker that calls the callback for each unexpired key of the level below `sk`
+// that matches `pattern`, in key order, or over the key range `kr` if it is
+// non-nil, skipping the first `skip` matches; returns false if the store key
+// does not exist
+func (ts *TreeStore) iterateLevelKeys(sk StoreKey, pattern string, kr *KeyRange, skip int, iterate bool, callback func(lk LevelKey) bool) (exists bool) {
+	lockedLevel, exists := ts.lockLevelBelow(sk)
+	if lockedLevel == nil {
+		return
+	}
+
+	if iterate {
+		now := ts.expirationTick()
+		mayBeExpired := ts.mayHaveExpiredKeys(now)
+		isMatch := ts.segmentMatcher(pattern)
+		skipped := 0
+
+		visit := func(node *avlNode[*keyNode]) bool {
 			kn := node.value
-			if kn.isExpired() {
+			if kn.isExpired(now) {
 				return true
 			}
 
-			if isPatternRunes(patternRunes, bytes.Runes(node.key)) {
-				if n >= startAt {
-					lk := LevelKey{
-						Segment:     node.key,
-						HasValue:    kn.current != nil,
-						HasChildren: kn.nextLevel != nil,
-					}
-					keys = append(keys, lk)
-					if len(keys) >= limit {
-						return false
-					}
+			if isMatch(node.key) {
+				if skipped < skip {
+					skipped++
+					return true
+				}
+
+				lk := LevelKey{
+					Segment:     node.key,
+					HasValue:    kn.current != nil,
+					HasChildren: kn.nextLevel != nil,
+				}
+				if kn.nextLevel != nil {
+					kn.nextLevel.lock.RLock()
+					lk.ChildCount = kn.nextLevel.tree.Count()
+					kn.nextLevel.lock.RUnlock()
 				}
-				n++
+				return callback(lk)
 			}
 			return true
-		})
+		}
+
+		if kr != nil {
+			kr.iterateLevel(lockedLevel, visit)
+		} else if !mayBeExpired && isMatchAllPattern(pattern) && skip > 0 {
+			// every key is a match, so the offset is a key index
+			skipped = skip
+			lockedLevel.tree.IterateFrom(skip, visit)
+		} else {
+			lockedLevel.tree.Iterate(visit)
+		}
 	}
 
 	ts.completeKeyNodeRead(lockedLevel)
@@ -108,22 +202,23 @@ func (ts *TreeStore) GetLevelKeys(sk StoreKey, pattern string, startAt, limit in
 }
 
 // worker that calls the full iterator callback
-func (ts *TreeStore) iterateFullInvokeCallback(segments []TokenSegment, kn *keyNode, patternEnd bool, callback iterateFullCallback) (stopped bool) {
-	if kn.isExpired() {
+func (ts *TreeStore) iterateFullInvokeCallback(segments []TokenSegment, kn *keyNode, patternEnd bool, tickNs int64, callback iterateFullCallback) (stopped bool) {
+	if kn.isExpired(ts.expirationTick()) {
 		return
 	}
 
+	vi := kn.valueAt(tickNs)
 	km := KeyMatch{
 		Key:         TokenSetToTokenPath(segments),
-		HasValue:    kn.current != nil,
+		HasValue:    vi != nil,
 		HasChildren: kn.nextLevel != nil,
 	}
 	if kn.metadata != nil {
 		km.Metadata = kn.metadata
 	}
-	if kn.current != nil {
-		km.CurrentValue = kn.current.value
-		km.Relationships = kn.current.relationships
+	if vi != nil {
+		km.CurrentValue = vi.value
+		km.Relationships = vi.relationships
 	}
 
 	stopped = !callback(&km, patternEnd)
@@ -131,36 +226,36 @@ func (ts *TreeStore) iterateFullInvokeCallback(segments []TokenSegment, kn *keyN
 }
 
 // worker that tests for a multi-level pattern match
-func (ts *TreeStore) iterateFullWorkerIsMatch(patternSegs []TokenSegment, candidate []TokenSegment) bool {
+func (ts *TreeStore) iterateFullWorkerIsMatch(pattern []patternSegment, candidate []TokenSegment) bool {
 	cpos := 0
 	ppos := 0
 
-	patStr := string(patternSegs[ppos])
+	multi := pattern[ppos].multi
 
 	for {
-		if ppos+2 <= len(patternSegs) && patStr == "**" && string(patternSegs[ppos+1]) == "**" {
+		if ppos+2 <= len(pattern) && multi && pattern[ppos+1].multi {
 			ppos++
-			patStr = string(patternSegs[ppos])
+			multi = pattern[ppos].multi
 		} else {
 			break
 		}
 	}
 
 	for {
-		if ppos >= len(patternSegs) {
+		if ppos >= len(pattern) {
 			break
 		}
 		if cpos >= len(candidate) {
 			break
 		}
 
-		patStr = string(patternSegs[ppos])
-		if patStr == "**" {
-			if ppos+1 >= len(patternSegs) {
+		multi = pattern[ppos].multi
+		if multi {
+			if ppos+1 >= len(pattern) {
 				return true
 			}
 			for {
-				if ts.iterateFullWorkerIsMatch(patternSegs[ppos+1:], candidate[cpos:]) {
+				if ts.iterateFullWorkerIsMatch(pattern[ppos+1:], candidate[cpos:]) {
 					return true
 				}
 				cpos++
@@ -168,7 +263,7 @@ func (ts *TreeStore) iterateFullWorkerIsMatch(patternSegs []TokenSegment, candid
 					return false
 				}
 			}
-		} else if !isPattern(string(patternSegs[ppos]), string(candidate[cpos])) {
+		} else if !pattern[ppos].matches(candidate[cpos]) {
 			return false
 		}
 
@@ -176,16 +271,41 @@ func (ts *TreeStore) iterateFullWorkerIsMatch(patternSegs []TokenSegment, candid
 		cpos++
 	}
 
-	if ppos == len(patternSegs)-1 && patStr == "**" {
+	if ppos == len(pattern)-1 && multi {
 		return true
 	}
 
-	return (ppos == len(patternSegs) && cpos == len(candidate))
+	return (ppos == len(pattern) && cpos == len(candidate))
+}
+
+// worker that determines if the node at the end of `segments` is the cursor
+// key `after` or one of its parents, which are ordered before the keys that
+// follow the cursor; also returns the cursor that applies to the node's children
+func resumeAfter(after TokenSet, segments []TokenSegment) (skip bool, childAfter TokenSet) {
+	if after == nil {
+		return
+	}
+
+	depth := len(segments) - 1
+	if !bytes.Equal(segments[depth], after[depth]) {
+		return
+	}
+
+	skip = true
+	if len(segments) < len(after) {
+		childAfter = after
+	}
+	return
 }
 
 // worker that iterates through the tree store, calling the callback for each key
 // that matches the pattern segment(s)
-func (ts *TreeStore) iterateFullWorker(patternSegs []TokenSegment, patternIndex int, segments []TokenSegment, nextLevel *keyTree, callback iterateFullCallback) (stopped bool) {
+//
+// Keys are visited in key order. If `after` is non-nil, iteration resumes with
+// the first key that follows it. If `kr` is non-nil, matches are limited to the
+// keys with a leaf segment in the range, and are visited in reverse key order
+// if the range is descending.
+func (ts *TreeStore) iterateFullWorker(pattern []patternSegment, patternIndex int, segments []TokenSegment, nextLevel *keyTree, after TokenSet, kr *KeyRange, visit iterateFullVisitor) (stopped bool) {
 	var lockedLevel *keyTree
 	if nextLevel == nil {
 		return
@@ -196,10 +316,19 @@ func (ts *TreeStore) iterateFullWorker(patternSegs []TokenSegment, patternIndex
 	ts.activeLocks.Add(1)
 
 	for {
-		seg := patternSegs[patternIndex]
-		segstr := string(seg)
-		if !strings.Contains(segstr, "*") {
+		ps := &pattern[patternIndex]
+		seg := ps.segment
+		if !ps.multi && ps.match == nil {
 			// no wildcard
+			if after != nil {
+				cmp := bytes.Compare(seg, after[len(segments)])
+				if cmp < 0 {
+					break
+				} else if cmp > 0 {
+					after = nil
+				}
+			}
+
 			avlNode := lockedLevel.tree.Find(seg)
 			if avlNode == nil {
 				break
@@ -208,10 +337,15 @@ func (ts *TreeStore) iterateFullWorker(patternSegs []TokenSegment, patternIndex
 			segments = append(segments, seg)
 			kn := avlNode.value
 
+			var skip bool
+			skip, after = resumeAfter(after, segments)
+
 			patternIndex++
-			if patternIndex >= len(patternSegs) {
+			if patternIndex >= len(pattern) {
 				// valueInstance match
-				stopped = ts.iterateFullInvokeCallback(segments, kn, true, callback)
+				if !skip && kr.contains(seg) {
+					stopped = ts.iterateFullVisit(segments, kn, true, visit)
+				}
 				break
 			}
 
@@ -223,24 +357,38 @@ func (ts *TreeStore) iterateFullWorker(patternSegs []TokenSegment, patternIndex
 			nextLevel.lock.RLock()
 			lockedLevel.lock.RUnlock()
 			lockedLevel = nextLevel
-		} else if segstr == "**" {
+		} else if ps.multi {
 			// multi-level pattern iteration
-			all := lockedLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+			var low []byte
+			if after != nil {
+				low = after[len(segments)]
+			}
+
+			all := lockedLevel.tree.IterateRange(low, nil, kr.isDescending(), func(node *avlNode[*keyNode]) bool {
 				subSegments := append(segments, node.key)
 				kn := node.value
+				skip, childAfter := resumeAfter(after, subSegments)
+				isMatch := !skip && kr.contains(node.key) && ts.iterateFullWorkerIsMatch(pattern, subSegments)
 
-				if ts.iterateFullWorkerIsMatch(patternSegs, subSegments) {
-					if ts.iterateFullInvokeCallback(subSegments, kn, false, callback) {
+				// a parent precedes its children in key order
+				if isMatch && !kr.isDescending() {
+					if ts.iterateFullVisit(subSegments, kn, false, visit) {
 						return false
 					}
 				}
 
 				// N.B., the patternIndex is not advanced - which causes the entire subtree to be examined.
 				// This could be optimized.
-				if ts.iterateFullWorker(patternSegs, patternIndex, subSegments, kn.nextLevel, callback) {
+				if ts.iterateFullWorker(pattern, patternIndex, subSegments, kn.nextLevel, childAfter, kr, visit) {
 					return false
 				}
 
+				if isMatch && kr.isDescending() {
+					if ts.iterateFullVisit(subSegments, kn, false, visit) {
+						return false
+					}
+				}
+
 				return true
 			})
 			stopped = !all
@@ -248,20 +396,49 @@ func (ts *TreeStore) iterateFullWorker(patternSegs []TokenSegment, patternIndex
 		} else {
 			// single-level pattern iteration
 			nextPatternIndex := patternIndex + 1
-			end := nextPatternIndex >= len(patternSegs)
+			end := nextPatternIndex >= len(pattern)
+			isSegMatch := ps.match
+
+			var low []byte
+			if after != nil {
+				low = after[len(segments)]
+			}
+
+			// at the last pattern level, every match has a leaf segment at
+			// this level, so the range bounds the level
+			high := []byte(nil)
+			if end && kr != nil {
+				if after == nil {
+					low = kr.From
+				}
+				high = kr.To
+			}
+
+			all := lockedLevel.tree.IterateRange(low, high, kr.isDescending(), func(node *avlNode[*keyNode]) bool {
+				// the rest of the pattern applies only below a matching segment
+				if !isSegMatch(node.key) {
+					return true
+				}
 
-			all := lockedLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
 				subSegments := append(segments, node.key)
 				kn := node.value
+				skip, childAfter := resumeAfter(after, subSegments)
+				isMatch := !skip && kr.contains(node.key) && ts.iterateFullWorkerIsMatch(pattern, subSegments)
 
-				if ts.iterateFullWorkerIsMatch(patternSegs, subSegments) {
-					if ts.iterateFullInvokeCallback(subSegments, kn, end, callback) {
+				if isMatch && !kr.isDescending() {
+					if ts.iterateFullVisit(subSegments, kn, end, visit) {
 						return false
 					}
 				}
 
 				if !end {
-					if ts.iterateFullWorker(patternSegs, nextPatternIndex, subSegments, kn.nextLevel, callback) {
+					if ts.iterateFullWorker(pattern, nextPatternIndex, subSegments, kn.nextLevel, childAfter, kr, visit) {
+						return false
+					}
+				}
+
+				if isMatch && kr.isDescending() {
+					if ts.iterateFullVisit(subSegments, kn, end, visit) {
 						return false
 					}
 				}
@@ -278,15 +455,52 @@ func (ts *TreeStore) iterateFullWorker(patternSegs []TokenSegment, patternIndex
 	return
 }
 
-func (ts *TreeStore) iterateFull(skPattern StoreKey, callback iterateFullCallback) {
+func (ts *TreeStore) iterateFull(skPattern StoreKey, tickNs int64, after TokenSet, kr *KeyRange, callback iterateFullCallback) {
+	ts.iterateFullKeys(skPattern, after, kr, func(segments []TokenSegment, kn *keyNode, patternEnd bool) bool {
+		return !ts.iterateFullInvokeCallback(segments, kn, patternEnd, tickNs, callback)
+	})
+}
+
+// worker that walks the keys matching skPattern like iterateFull, passing the
+// key nodes to the visitor
+func (ts *TreeStore) iterateFullKeys(skPattern StoreKey, after TokenSet, kr *KeyRange, visit iterateFullVisitor) {
 	segments := make([]TokenSegment, 0, len(skPattern.Tokens))
 	nextLevel := ts.dbNode.nextLevel
 
-	ts.iterateFullWorker(skPattern.Tokens, 0, segments, nextLevel, callback)
+	ts.iterateFullWorker(ts.compileKeyPattern(skPattern.Tokens), 0, segments, nextLevel, after, kr, visit)
+}
+
+// worker that calls the visitor for an unexpired key node
+func (ts *TreeStore) iterateFullVisit(segments []TokenSegment, kn *keyNode, patternEnd bool, visit iterateFullVisitor) (stopped bool) {
+	if kn.isExpired(ts.expirationTick()) {
+		return
+	}
+
+	stopped = !visit(segments, kn, patternEnd)
+	return
 }
 
 // Full iteration function walks each tree store level according to skPattern and returns every
 // detail of matching keys.
+//
+// Each segment of skPattern is matched against the key segment at its level. A
+// segment of "**" matches any number of levels. Other segments can contain:
+//
+//	"*"        any run of characters, including none
+//	"[abc]"    one of the characters; a range such as [a-z] is allowed, and
+//	           [!abc] or [^abc] matches a character that is not listed
+//	"{a,b,c}"  one of the alternatives, which can themselves be patterns
+//	"\c"       the character c literally
+//
+// A segment that begins with "re:" is instead a regular expression (in Go
+// regexp syntax) that must match the whole key segment, such as "re:v[0-9]+".
+//
+// Only "*" and "**" are available unless SetExtendedPatterns has enabled the
+// rest of the syntax; otherwise the other characters match literally.
+//
+// Patterns are matched against unescaped segments. In a token path, the
+// backslash is written as \S by EscapeTokenString, so the escape \* is written
+// \S* in a path. Use EscapePatternSegment to match a segment literally.
 func (ts *TreeStore) GetMatchingKeys(skPattern StoreKey, startAt, limit int, leaves bool) (keys []*KeyMatch) {
 	keys = []*KeyMatch{}
 
@@ -303,7 +517,7 @@ func (ts *TreeStore) GetMatchingKeys(skPattern StoreKey, startAt, limit int, lea
 			ts.activeLocks.Add(-1)
 		}()
 
-		ts.iterateFullInvokeCallback(skPattern.Tokens, &ts.dbNode, true, func(km *KeyMatch, patternEnd bool) bool {
+		ts.iterateFullInvokeCallback(skPattern.Tokens, &ts.dbNode, true, 0, func(km *KeyMatch, patternEnd bool) bool {
 			if leaves && km.HasChildren && !patternEnd {
 				return true
 			}
@@ -315,7 +529,7 @@ func (ts *TreeStore) GetMatchingKeys(skPattern StoreKey, startAt, limit int, lea
 	}
 
 	n := 0
-	ts.iterateFull(skPattern, func(km *KeyMatch, patternEnd bool) bool {
+	ts.iterateFull(skPattern, 0, nil, nil, func(km *KeyMatch, patternEnd bool) bool {
 		if leaves && km.HasChildren && !patternEnd {
 			return true
 		}
@@ -332,9 +546,66 @@ func (ts *TreeStore) GetMatchingKeys(skPattern StoreKey, startAt, limit int, lea
 	return
 }
 
+// Returns the number of keys that GetMatchingKeys would return with no limit,
+// without collecting their details.
+func (ts *TreeStore) CountMatchingKeys(skPattern StoreKey, leaves bool) (count int) {
+	ts.walkMatchingKeys(skPattern, nil, func(segments []TokenSegment, kn *keyNode, patternEnd bool) bool {
+		if !leaves || kn.nextLevel == nil || patternEnd {
+			count++
+		}
+		return true
+	})
+	return
+}
+
+// Determines if any key matches skPattern. The walk stops at the first match.
+func (ts *TreeStore) AnyMatchingKey(skPattern StoreKey) (found bool) {
+	ts.walkMatchingKeys(skPattern, nil, func(segments []TokenSegment, kn *keyNode, patternEnd bool) bool {
+		found = true
+		return false
+	})
+	return
+}
+
+// worker that passes the key nodes matching skPattern to the visitor, including
+// the sentinel for an empty pattern; a walk can resume after a key, as with
+// iterateFull
+func (ts *TreeStore) walkMatchingKeys(skPattern StoreKey, after TokenSet, visit iterateFullVisitor) {
+	if len(skPattern.Tokens) == 0 {
+		// sentinel special case
+		ts.dbNode.ownerTree.lock.RLock()
+		ts.activeLocks.Add(1)
+		defer func() {
+			ts.dbNode.ownerTree.lock.RUnlock()
+			ts.activeLocks.Add(-1)
+		}()
+
+		ts.iterateFullVisit(skPattern.Tokens, &ts.dbNode, true, visit)
+		return
+	}
+
+	ts.iterateFullKeys(skPattern, after, nil, visit)
+}
+
 // Full iteration function walks each tree store level according to skPattern and returns every
 // detail of matching keys that have values.
 func (ts *TreeStore) GetMatchingKeyValues(skPattern StoreKey, startAt, limit int) (values []*KeyValueMatch) {
+	return ts.getMatchingKeyValues(skPattern, startAt, limit, 0)
+}
+
+// Like GetMatchingKeyValues, but returns the value each matching key had at
+// `tickNs`, skipping keys that had no value then. Specify a negative `tickNs`
+// for a time relative to now, as with GetKeyValueAtTime.
+//
+// Key structure is not versioned, so keys that are deleted after `tickNs` are
+// not included.
+func (ts *TreeStore) GetMatchingKeyValuesAtTime(skPattern StoreKey, tickNs int64, startAt, limit int) (values []*KeyValueMatch) {
+	return ts.getMatchingKeyValues(skPattern, startAt, limit, ts.resolveTick(tickNs))
+}
+
+// worker - collects matching keys with their values at `tickNs`, or their
+// current values if `tickNs` is 0
+func (ts *TreeStore) getMatchingKeyValues(skPattern StoreKey, startAt, limit int, tickNs int64) (values []*KeyValueMatch) {
 	values = []*KeyValueMatch{}
 
 	if limit == 0 {
@@ -351,7 +622,7 @@ func (ts *TreeStore) GetMatchingKeyValues(skPattern StoreKey, startAt, limit int
 			ts.activeLocks.Add(-1)
 		}()
 
-		ts.iterateFullInvokeCallback(skPattern.Tokens, &ts.dbNode, true, func(km *KeyMatch, patternEnd bool) bool {
+		ts.iterateFullInvokeCallback(skPattern.Tokens, &ts.dbNode, true, tickNs, func(km *KeyMatch, patternEnd bool) bool {
 			if n >= startAt {
 				if km.HasValue {
 					kvm := &KeyValueMatch{
@@ -374,7 +645,7 @@ func (ts *TreeStore) GetMatchingKeyValues(skPattern StoreKey, startAt, limit int
 		return
 	}
 
-	ts.iterateFull(skPattern, func(km *KeyMatch, patternEnd bool) bool {
+	ts.iterateFull(skPattern, tickNs, nil, nil, func(km *KeyMatch, patternEnd bool) bool {
 		if km.HasValue {
 			if n >= startAt {
 				kvm := &KeyValueMatch{
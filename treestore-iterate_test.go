@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jimsnab/go-lane"
 )
@@ -325,6 +326,62 @@ func TestIterateLevelPages(t *testing.T) {
 	}
 }
 
+func TestIterateLevelCounts(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for i := 0; i < 10; i++ {
+		ts.SetKey(MakeStoreKey("parent", fmt.Sprintf("%d", i)))
+		for j := 0; j < i; j++ {
+			ts.SetKey(MakeStoreKey("parent", fmt.Sprintf("%d", i), fmt.Sprintf("%d", j)))
+		}
+	}
+
+	if count, exists := ts.CountLevelKeys(MakeStoreKey("parent")); count != 10 || !exists {
+		t.Error("level count")
+	}
+	if count, exists := ts.CountLevelKeys(MakeStoreKey("parent", "0")); count != 0 || !exists {
+		t.Error("leaf count")
+	}
+	if _, exists := ts.CountLevelKeys(MakeStoreKey("missing")); exists {
+		t.Error("missing count")
+	}
+
+	keys := ts.GetLevelKeys(MakeStoreKey("parent"), "*", 3, 2)
+	if len(keys) != 2 || string(keys[0].Segment) != "3" || keys[0].ChildCount != 3 || keys[1].ChildCount != 4 {
+		t.Error("child counts")
+	}
+
+	// expired keys are excluded from offsets and counts
+	expiration := time.Now().Add(time.Millisecond * 10).UnixNano()
+	ts.SetKeyValueEx(MakeStoreKey("parent", "1"), nil, SetExNoValueUpdate, expiration, nil)
+	ts.SetKeyValueEx(MakeStoreKey("parent", "5", "0"), nil, SetExNoValueUpdate, expiration, nil)
+	time.Sleep(time.Millisecond * 20)
+
+	if count, _ := ts.CountLevelKeys(MakeStoreKey("parent")); count != 9 {
+		t.Error("level count with expired key")
+	}
+
+	// child counts include expired keys until they are deleted
+	keys = ts.GetLevelKeys(MakeStoreKey("parent"), "*", 3, 2)
+	if len(keys) != 2 || string(keys[0].Segment) != "4" || keys[1].ChildCount != 5 {
+		t.Error("offset with expired key")
+	}
+
+	// the swept parent key remains because it has a child
+	ts.SweepExpiredKeys(10)
+	keys = ts.GetLevelKeys(MakeStoreKey("parent"), "*", 5, 1)
+	if len(keys) != 1 || string(keys[0].Segment) != "5" || keys[0].ChildCount != 4 {
+		t.Error("child count after sweep")
+	}
+	if count, _ := ts.CountLevelKeys(MakeStoreKey("parent")); count != 10 {
+		t.Error("level count after sweep")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
 func TestIterateLevelPattern(t *testing.T) {
 	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
 
@@ -1034,3 +1091,54 @@ func TestValueIterate(t *testing.T) {
 		t.Error("final diag dump")
 	}
 }
+
+func TestCountMatchingKeys(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for i := 0; i < 30; i++ {
+		for j := 0; j < i%4; j++ {
+			ts.SetKeyValue(MakeStoreKey("a", fmt.Sprintf("%d", i), fmt.Sprintf("%d", j)), j)
+		}
+		ts.SetKey(MakeStoreKey("a", fmt.Sprintf("%d", i)))
+	}
+
+	patterns := []StoreKey{
+		MakeStoreKey(),
+		MakeStoreKey("**"),
+		MakeStoreKey("a", "*"),
+		MakeStoreKey("a", "1*", "*"),
+		MakeStoreKey("a", "**", "2"),
+		MakeStoreKey("b", "*"),
+	}
+
+	for _, pattern := range patterns {
+		for _, leaves := range []bool{false, true} {
+			expected := len(ts.GetMatchingKeys(pattern, 0, 10000, leaves))
+			if count := ts.CountMatchingKeys(pattern, leaves); count != expected {
+				t.Errorf("%s leaves=%v: count %d expected %d", pattern.Path, leaves, count, expected)
+			}
+		}
+
+		if ts.AnyMatchingKey(pattern) != (len(ts.GetMatchingKeys(pattern, 0, 1, false)) > 0) {
+			t.Errorf("%s: any", pattern.Path)
+		}
+	}
+
+	if ts.CountMatchingKeys(MakeStoreKey("a", "*", "*"), false) != 43 {
+		t.Error("child count")
+	}
+
+	// expired keys are not counted
+	ts.SetKeyValueEx(MakeStoreKey("b"), 1, 0, time.Now().Add(time.Millisecond*10).UnixNano(), nil)
+	if !ts.AnyMatchingKey(MakeStoreKey("b")) {
+		t.Error("unexpired key")
+	}
+	time.Sleep(time.Millisecond * 20)
+	if ts.AnyMatchingKey(MakeStoreKey("b")) || ts.CountMatchingKeys(MakeStoreKey("*"), false) != 1 {
+		t.Error("expired key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
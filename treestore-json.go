@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -20,6 +21,24 @@ const (
 // metadata "array" is "true" then the child key nodes are treated as
 // array autoLinks. (They must be big endian uint32.)
 func (ts *TreeStore) GetKeyAsJson(sk StoreKey, opts JsonOptions) (jsonData []byte, err error) {
+	return ts.getKeyAsJson(sk, opts, 0)
+}
+
+// Retrieves the child key tree and leaf values in the form of json, like
+// GetKeyAsJson, with each value as it was at `tickNs`. Specify a negative
+// `tickNs` for a time relative to now, as with GetKeyValueAtTime.
+//
+// Key structure is not versioned. Keys that are deleted after `tickNs` are not
+// included, and keys that had no value at `tickNs` (and no children that had a
+// value) are omitted. With JsonStringValuesAsKeys, a string value stored as a
+// key is included as it is now.
+func (ts *TreeStore) GetKeyAsJsonAtTime(sk StoreKey, tickNs int64, opts JsonOptions) (jsonData []byte, err error) {
+	return ts.getKeyAsJson(sk, opts, ts.resolveTick(tickNs))
+}
+
+// worker - builds the json of a key at `tickNs`, or of the current values if
+// `tickNs` is 0
+func (ts *TreeStore) getKeyAsJson(sk StoreKey, opts JsonOptions, tickNs int64) (jsonData []byte, err error) {
 	ts.keyNodeMu.RLock()
 	defer ts.keyNodeMu.RUnlock()
 
@@ -29,18 +48,20 @@ func (ts *TreeStore) GetKeyAsJson(sk StoreKey, opts JsonOptions) (jsonData []byt
 	defer ts.completeKeyNodeRead(level)
 
 	if tokenIndex >= len(sk.Tokens) && !expired {
-		jd = ts.buildJsonLevel(kn, opts)
+		jd, _ = ts.buildJsonLevel(kn, opts, tickNs)
 	}
 
 	jsonData, err = json.Marshal(jd)
 	return
 }
 
-func (ts *TreeStore) buildJsonLevel(kn *keyNode, opts JsonOptions) any {
+// worker - returns the json form of a key node; `present` is false if
+// `tickNs` is nonzero and the key had no value or child then
+func (ts *TreeStore) buildJsonLevel(kn *keyNode, opts JsonOptions, tickNs int64) (jd any, present bool) {
 	if kn.metadata != nil {
 		isArray := kn.metadata["array"]
 		if isArray == "true" {
-			return ts.buildJsonLevelArray(kn, opts)
+			return ts.buildJsonLevelArray(kn, opts, tickNs)
 		}
 	}
 
@@ -52,54 +73,61 @@ func (ts *TreeStore) buildJsonLevel(kn *keyNode, opts JsonOptions) any {
 
 		if (opts & JsonStringValuesAsKeys) != 0 {
 			if level.tree.nodes == 1 {
+				// at an earlier time, the key must never have had a value
 				if level.tree.root.value.current == nil &&
-					level.tree.root.value.nextLevel == nil {
-					return string(level.tree.root.key)
+					level.tree.root.value.nextLevel == nil &&
+					(tickNs == 0 || level.tree.root.value.history == nil) {
+					return string(level.tree.root.key), true
 				}
 			}
 		}
 
 		m := map[string]any{}
 		level.tree.Iterate(func(node *avlNode[*keyNode]) bool {
-			m[string(node.key)] = ts.buildJsonLevel(node.value, opts)
+			if childJd, childPresent := ts.buildJsonLevel(node.value, opts, tickNs); childPresent {
+				m[string(node.key)] = childJd
+			}
 			return true
 		})
-		return m
-	} else if kn.current != nil {
-		switch t := kn.current.value.(type) {
+		return m, tickNs == 0 || len(m) > 0 || kn.valueAt(tickNs) != nil
+	}
+
+	vi := kn.valueAt(tickNs)
+	if vi != nil {
+		switch t := vi.value.(type) {
 		case int:
-			return float64(t)
+			return float64(t), true
 		case uint:
-			return float64(t)
+			return float64(t), true
 		case int8:
-			return float64(t)
+			return float64(t), true
 		case uint8:
-			return float64(t)
+			return float64(t), true
 		case int16:
-			return float64(t)
+			return float64(t), true
 		case uint16:
-			return float64(t)
+			return float64(t), true
 		case int32:
-			return float64(t)
+			return float64(t), true
 		case uint32:
-			return float64(t)
+			return float64(t), true
 		case int64:
-			return float64(t)
+			return float64(t), true
 		case uint64:
-			return float64(t)
+			return float64(t), true
 		case float32:
-			return float64(t)
+			return float64(t), true
 		case nil, float64, string, bool:
-			return t
+			return t, true
 		}
 	}
 
-	return nil
+	return nil, tickNs == 0 || vi != nil
 }
 
-func (ts *TreeStore) buildJsonLevelArray(kn *keyNode, opts JsonOptions) []any {
+func (ts *TreeStore) buildJsonLevelArray(kn *keyNode, opts JsonOptions, tickNs int64) (jd []any, present bool) {
 	if kn.nextLevel == nil {
-		return []any{}
+		return []any{}, tickNs == 0 || kn.valueAt(tickNs) != nil
 	}
 
 	level := kn.nextLevel
@@ -119,11 +147,14 @@ func (ts *TreeStore) buildJsonLevelArray(kn *keyNode, opts JsonOptions) []any {
 			return true // ignore invalid
 		}
 
-		a[n] = ts.buildJsonLevel(node.value, opts)
+		// an element without a value at tickNs remains in its position
+		var elementPresent bool
+		a[n], elementPresent = ts.buildJsonLevel(node.value, opts, tickNs)
+		present = present || elementPresent
 		return true
 	})
 
-	return a
+	return a, present || tickNs == 0
 }
 
 // Takes the generalized json data and stores it at the specified key path.
@@ -141,6 +172,8 @@ func (ts *TreeStore) SetKeyJson(sk StoreKey, jsonData []byte, opts JsonOptions)
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
+	ts.assignJsonKeyAddresses(newKn)
+
 	kn, level, created := ts.ensureKey(sk)
 	defer ts.completeKeyNodeWrite(level)
 
@@ -151,6 +184,9 @@ func (ts *TreeStore) SetKeyJson(sk StoreKey, jsonData []byte, opts JsonOptions)
 
 	ts.assignJsonKey(sk, kn, newKn)
 	address = kn.address
+
+	ts.logMutation(&walRecord{Op: walSetKeyJson, Sk: sk.Tokens, JsonData: jsonData, Flags: int(opts)})
+	ts.watchTreeLocked(sk)
 	return
 }
 
@@ -173,9 +209,9 @@ func (ts *TreeStore) StageKeyJson(stagingSk StoreKey, jsonData []byte, opts Json
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
+	ts.assignJsonKeyAddresses(newKn)
+
 	// Temp key segment name gets an address to use as a convenient unique value.
-	// Addresses are atomic-locked only, and it is possible for an address to be
-	// allocated by code that is preparing its operation outside of node locking.
 
 	// ensure the base key exists
 	_, baseLevel, _ := ts.ensureKey(stagingSk)
@@ -192,10 +228,13 @@ func (ts *TreeStore) StageKeyJson(stagingSk StoreKey, jsonData []byte, opts Json
 		return
 	}
 
-	kn.expiration = time.Now().Add(time.Minute).UnixNano()
+	ts.setKeyExpiration(kn, ts.currentTick()+int64(time.Minute))
 
 	ts.assignJsonKey(tempSk, kn, newKn)
 	address = kn.address
+
+	ts.logMutation(&walRecord{Op: walStageKeyJson, Sk: stagingSk.Tokens, JsonData: jsonData, Flags: int(opts)})
+	ts.watchTreeLocked(tempSk)
 	return
 }
 
@@ -222,9 +261,13 @@ func (ts *TreeStore) ReplaceKeyJson(sk StoreKey, jsonData []byte, opts JsonOptio
 	}
 
 	replaced = true
+	ts.assignJsonKeyAddresses(newKn)
 	ts.resetNode(sk, kn)
 	ts.assignJsonKey(sk, kn, newKn)
 	address = kn.address
+
+	ts.logMutation(&walRecord{Op: walReplaceKeyJson, Sk: sk.Tokens, JsonData: jsonData, Flags: int(opts)})
+	ts.watchTreeLocked(sk)
 	return
 }
 
@@ -244,10 +287,13 @@ func (ts *TreeStore) CreateKeyJson(sk StoreKey, jsonData []byte, opts JsonOption
 	defer ts.keyNodeMu.Unlock()
 
 	level, tokenIndex, kn, expired := ts.locateKeyNodeForLock(sk)
+	if tokenIndex >= len(sk.Tokens) && (!expired || kn.hasChild(ts.expirationTick())) {
+		return
+	}
+
+	ts.assignJsonKeyAddresses(newKn)
+
 	if tokenIndex >= len(sk.Tokens) {
-		if !expired || kn.hasChild() {
-			return
-		}
 		level.lock.Lock()
 		ts.activeLocks.Add(1)
 		ts.resetNode(sk, kn)
@@ -261,6 +307,9 @@ func (ts *TreeStore) CreateKeyJson(sk StoreKey, jsonData []byte, opts JsonOption
 
 	ts.assignJsonKey(sk, kn, newKn)
 	address = kn.address
+
+	ts.logMutation(&walRecord{Op: walCreateKeyJson, Sk: sk.Tokens, JsonData: jsonData, Flags: int(opts)})
+	ts.watchTreeLocked(sk)
 	return
 }
 
@@ -284,6 +333,9 @@ func (ts *TreeStore) MergeKeyJson(sk StoreKey, jsonData []byte, opts JsonOptions
 	ts.mergeJsonKey(sk, kn, data, opts)
 	ts.addAutoLinks(sk.Tokens, kn, true)
 	address = kn.address
+
+	ts.logMutation(&walRecord{Op: walMergeKeyJson, Sk: sk.Tokens, JsonData: jsonData, Flags: int(opts)})
+	ts.watchTreeLocked(sk)
 	return
 }
 
@@ -304,6 +356,7 @@ func (ts *TreeStore) mergeJsonKey(sk StoreKey, kn *keyNode, data any, opts JsonO
 }
 
 func (ts *TreeStore) mergeJsonKeyValue(sk StoreKey, kn *keyNode, data any, opts JsonOptions) {
+	ts.preserveKeyNode(kn)
 	switch t := data.(type) {
 	case nil, float64, string, bool:
 		newLeaf := valueInstance{
@@ -311,9 +364,10 @@ func (ts *TreeStore) mergeJsonKeyValue(sk StoreKey, kn *keyNode, data any, opts
 		}
 		kn.history = newAvlTree[*valueInstance]()
 		kn.current = &newLeaf
-		now := currentUnixTimestampBytes()
+		now := ts.currentTimestampBytes()
 		kn.history.Set(now, &newLeaf)
 		ts.keys[sk.Path] = kn.address
+		ts.bumpKeyVersion(kn)
 
 	case []any:
 		if kn.metadata == nil {
@@ -338,7 +392,15 @@ func (ts *TreeStore) mergeJsonKeyValue(sk StoreKey, kn *keyNode, data any, opts
 		}
 
 	case map[string]any:
-		for k, v := range t {
+		// merge in sorted order so that new children are assigned addresses consistently
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			v := t[k]
 			key := []byte(k)
 			childSk := AppendStoreKeySegments(sk, key)
 			childKn, lockedLevel := ts.ensureMergeChild(kn, key)
@@ -351,6 +413,7 @@ func (ts *TreeStore) mergeJsonKeyValue(sk StoreKey, kn *keyNode, data any, opts
 // Worker for merge that ensures a child key exists
 func (ts *TreeStore) ensureMergeChild(parentKn *keyNode, key []byte) (kn *keyNode, lockedLevel *keyTree) {
 	if parentKn.nextLevel == nil {
+		ts.preserveKeyNode(parentKn)
 		parentKn.nextLevel = newKeyTree(parentKn)
 	}
 
@@ -400,7 +463,6 @@ func (ts *TreeStore) nextJsonKeyLevel(kn *keyNode, data any, opts JsonOptions) {
 			key := []byte(s)
 			childKn := &keyNode{
 				key:       key,
-				address:   StoreAddress(ts.nextAddress.Add(1)),
 				ownerTree: level,
 			}
 
@@ -419,7 +481,7 @@ func (ts *TreeStore) nextJsonKeyValueLevel(kn *keyNode, data any, opts JsonOptio
 		}
 		kn.history = newAvlTree[*valueInstance]()
 		kn.current = &newLeaf
-		now := currentUnixTimestampBytes()
+		now := ts.currentTimestampBytes()
 		kn.history.Set(now, &newLeaf)
 
 	case []any:
@@ -438,7 +500,6 @@ func (ts *TreeStore) nextJsonKeyValueLevel(kn *keyNode, data any, opts JsonOptio
 
 			childKn := &keyNode{
 				key:       key,
-				address:   StoreAddress(ts.nextAddress.Add(1)),
 				ownerTree: level,
 			}
 
@@ -453,7 +514,6 @@ func (ts *TreeStore) nextJsonKeyValueLevel(kn *keyNode, data any, opts JsonOptio
 			key := TokenStringToSegment(k)
 			childKn := &keyNode{
 				key:       key,
-				address:   StoreAddress(ts.nextAddress.Add(1)),
 				ownerTree: level,
 			}
 
@@ -467,8 +527,10 @@ func (ts *TreeStore) nextJsonKeyValueLevel(kn *keyNode, data any, opts JsonOptio
 func (ts *TreeStore) assignJsonKey(sk StoreKey, baseKn *keyNode, jsonKn *keyNode) {
 	// auto-links must be removed first by the caller (possibly via resetNode)
 
+	ts.preserveKeyNode(baseKn)
 	baseKn.current = jsonKn.current
 	baseKn.history = jsonKn.history
+	baseKn.historyPruned = jsonKn.historyPruned
 	baseKn.metadata = jsonKn.metadata
 	baseKn.nextLevel = jsonKn.nextLevel
 	if baseKn.nextLevel != nil {
@@ -479,10 +541,24 @@ func (ts *TreeStore) assignJsonKey(sk StoreKey, baseKn *keyNode, jsonKn *keyNode
 	ts.addAutoLinks(sk.Tokens, baseKn, true)
 }
 
+// Worker that gives each node of a new json key node tree an address, parent
+// first and in key order. The caller must hold the write lock on ts.keyNodeMu,
+// so that the addresses are the same when the write-ahead log is replayed.
+func (ts *TreeStore) assignJsonKeyAddresses(jsonKn *keyNode) {
+	if jsonKn.nextLevel != nil {
+		jsonKn.nextLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+			node.value.address = StoreAddress(ts.nextAddress.Add(1))
+			ts.assignJsonKeyAddresses(node.value)
+			return true
+		})
+	}
+}
+
 // Worker that iterates the newly assigned nodes and ensures they are indexed
 func (ts *TreeStore) assignJsonKeyIndex(sk StoreKey, kn *keyNode) {
 	if kn.current != nil {
 		ts.keys[sk.Path] = kn.address
+		ts.bumpKeyVersion(kn)
 	}
 	ts.addresses[kn.address] = kn
 
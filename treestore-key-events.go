@@ -0,0 +1,196 @@
+package treestore
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	KeyEventKind int
+
+	// Describes a key that expired or was deleted.
+	KeyEvent struct {
+		Kind     KeyEventKind
+		Sk       StoreKey
+		Address  StoreAddress
+		Value    any  // the final value of the key
+		HasValue bool // false if the key did not have a value
+	}
+
+	KeyEventFn func(ev *KeyEvent)
+
+	keyEventSubscriber struct {
+		id int
+		fn KeyEventFn
+	}
+
+	keyEventSubscribers struct {
+		mu     sync.Mutex
+		nextId int
+		subs   atomic.Pointer[[]keyEventSubscriber]
+	}
+)
+
+const (
+	KeyEventExpired KeyEventKind = iota
+	KeyEventDeleted
+)
+
+// Registers a callback that is invoked when a key expires or is deleted, and
+// returns an id for UnsubscribeKeyEvents.
+//
+// A key expires when the expiration sweeper removes it, or when an operation
+// finds the expired key and discards it - such as setting a new value, or
+// deleting the key. Reads skip expired keys without raising an event.
+//
+// A key is deleted by DeleteKey, DeleteKeyWithValue or DeleteKeyTree.
+// DeleteKeyTree raises an event for the key and each of its children.
+//
+// The callback is invoked on the goroutine making the change, while the tree
+// store is locked, so it must not call the tree store. Events are not raised
+// during write-ahead log replay.
+func (ts *TreeStore) SubscribeKeyEvents(fn KeyEventFn) (id int) {
+	ts.keyEvents.mu.Lock()
+	defer ts.keyEvents.mu.Unlock()
+
+	ts.keyEvents.nextId++
+	id = ts.keyEvents.nextId
+
+	var subs []keyEventSubscriber
+	if cur := ts.keyEvents.subs.Load(); cur != nil {
+		subs = slices.Clone(*cur)
+	}
+	subs = append(subs, keyEventSubscriber{id: id, fn: fn})
+	ts.keyEvents.subs.Store(&subs)
+	return
+}
+
+// Removes a callback registered by SubscribeKeyEvents. Returns false if the
+// id is not subscribed.
+func (ts *TreeStore) UnsubscribeKeyEvents(id int) (removed bool) {
+	ts.keyEvents.mu.Lock()
+	defer ts.keyEvents.mu.Unlock()
+
+	cur := ts.keyEvents.subs.Load()
+	if cur == nil {
+		return
+	}
+
+	subs := slices.DeleteFunc(slices.Clone(*cur), func(sub keyEventSubscriber) bool {
+		return sub.id == id
+	})
+	removed = len(subs) < len(*cur)
+	if len(subs) == 0 {
+		ts.keyEvents.subs.Store(nil)
+	} else {
+		ts.keyEvents.subs.Store(&subs)
+	}
+	return
+}
+
+// worker - tests if a key event is needed for the subscribers or watchers
+func (ts *TreeStore) keyEventsWanted() bool {
+	return ts.keyEventSubscribers() != nil || ts.activeWatchers() != nil
+}
+
+// worker - returns the callbacks to invoke, or nil if there are none
+func (ts *TreeStore) keyEventSubscribers() []keyEventSubscriber {
+	if ts.walReplaying.Load() {
+		return nil
+	}
+
+	subs := ts.keyEvents.subs.Load()
+	if subs == nil {
+		return nil
+	}
+	return *subs
+}
+
+// worker - describes a key node before it is removed
+func (ts *TreeStore) newKeyEvent(sk StoreKey, kn *keyNode) *KeyEvent {
+	ev := &KeyEvent{
+		Kind:    KeyEventDeleted,
+		Sk:      sk,
+		Address: kn.address,
+	}
+
+	if kn.isExpired(ts.expirationTick()) {
+		ev.Kind = KeyEventExpired
+	}
+
+	if kn.current != nil {
+		ev.Value = kn.current.value
+		ev.HasValue = true
+	}
+	return ev
+}
+
+// worker - describes the key before it is deleted; returns nil if the key
+// does not exist or if there are no subscribers or watchers. The caller must
+// hold a write lock on ts.keyNodeMu.
+func (ts *TreeStore) keyEventLocked(sk StoreKey) *KeyEvent {
+	if !ts.keyEventsWanted() {
+		return nil
+	}
+
+	if len(sk.Tokens) == 0 {
+		return ts.newKeyEvent(sk, &ts.dbNode)
+	}
+
+	_, index, kn, _ := ts.locateKeyNodeForLock(sk)
+	if index < len(sk.Tokens) {
+		return nil
+	}
+	return ts.newKeyEvent(sk, kn)
+}
+
+// worker - describes the key and all of its children before they are
+// deleted, parent first. The caller must hold a write lock on ts.keyNodeMu.
+func (ts *TreeStore) keyTreeEventsLocked(sk StoreKey) (events []*KeyEvent) {
+	ev := ts.keyEventLocked(sk)
+	if ev == nil {
+		return
+	}
+	events = append(events, ev)
+
+	var collect func(sk StoreKey, kn *keyNode)
+	collect = func(sk StoreKey, kn *keyNode) {
+		if kn.nextLevel == nil {
+			return
+		}
+		kn.nextLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+			childSk := AppendStoreKeySegments(sk, node.key)
+			events = append(events, ts.newKeyEvent(childSk, node.value))
+			collect(childSk, node.value)
+			return true
+		})
+	}
+
+	collect(sk, ts.addresses[ev.Address])
+	return
+}
+
+// worker - invokes the subscribed callbacks and notifies the watchers
+func (ts *TreeStore) raiseKeyEvent(ev *KeyEvent) {
+	if ev == nil {
+		return
+	}
+
+	for _, sub := range ts.keyEventSubscribers() {
+		sub.fn(ev)
+	}
+
+	if ts.activeWatchers() != nil {
+		wev := &WatchEvent{
+			Op:       WatchDelete,
+			Sk:       ev.Sk,
+			Address:  ev.Address,
+			OldValue: ev.Value,
+		}
+		if ev.Kind == KeyEventExpired {
+			wev.Op = WatchExpire
+		}
+		ts.notifyWatchers(wev)
+	}
+}
@@ -0,0 +1,218 @@
+package treestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func recordKeyEvents(ts *TreeStore) (events *[]KeyEvent, id int) {
+	events = &[]KeyEvent{}
+	id = ts.SubscribeKeyEvents(func(ev *KeyEvent) {
+		*events = append(*events, *ev)
+	})
+	return
+}
+
+func TestKeyEventDeleteKey(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	events, _ := recordKeyEvents(ts)
+
+	sk := MakeStoreKey("test")
+	addr, _ := ts.SetKeyValue(sk, "value")
+
+	ts.DeleteKey(MakeStoreKey("missing"))
+	if len(*events) != 0 {
+		t.Fatal("missing key event")
+	}
+
+	ts.DeleteKey(sk)
+	if len(*events) != 1 {
+		t.Fatal("delete event count")
+	}
+
+	ev := (*events)[0]
+	if ev.Kind != KeyEventDeleted || ev.Sk.Path != "/test" || ev.Address != addr || ev.Value != "value" || !ev.HasValue {
+		t.Error("delete event")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestKeyEventDeleteKeyWithValue(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	events, _ := recordKeyEvents(ts)
+
+	ts.SetKey(MakeStoreKey("novalue"))
+	ts.DeleteKeyWithValue(MakeStoreKey("novalue"), true)
+	if len(*events) != 0 {
+		t.Fatal("key without value")
+	}
+
+	addr, _ := ts.SetKeyValue(MakeStoreKey("a", "b"), 10)
+	ts.DeleteKeyWithValue(MakeStoreKey("a", "b"), true)
+	if len(*events) != 1 {
+		t.Fatal("delete event count")
+	}
+
+	ev := (*events)[0]
+	if ev.Kind != KeyEventDeleted || ev.Sk.Path != "/a/b" || ev.Address != addr || ev.Value != 10 {
+		t.Error("delete event")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestKeyEventDeleteKeyTree(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("root"), "r")
+	ts.SetKeyValue(MakeStoreKey("root", "a"), "a")
+	ts.SetKeyValue(MakeStoreKey("root", "b", "c"), "c")
+	ts.SetKeyValueEx(MakeStoreKey("root", "d"), "d", 0, 1, nil)
+
+	events, _ := recordKeyEvents(ts)
+	ts.DeleteKeyTree(MakeStoreKey("root"))
+
+	expected := []struct {
+		kind KeyEventKind
+		path TokenPath
+		val  any
+	}{
+		{KeyEventDeleted, "/root", "r"},
+		{KeyEventDeleted, "/root/a", "a"},
+		{KeyEventDeleted, "/root/b", nil},
+		{KeyEventDeleted, "/root/b/c", "c"},
+		{KeyEventExpired, "/root/d", "d"},
+	}
+
+	if len(*events) != len(expected) {
+		t.Fatalf("event count %d", len(*events))
+	}
+
+	for i, ex := range expected {
+		ev := (*events)[i]
+		if ev.Kind != ex.kind || ev.Sk.Path != ex.path || ev.Value != ex.val || ev.HasValue != (ex.val != nil) {
+			t.Errorf("event %d: %+v", i, ev)
+		}
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestKeyEventExpired(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	events, _ := recordKeyEvents(ts)
+
+	// found by a write
+	sk := MakeStoreKey("lazy")
+	addr, _, _ := ts.SetKeyValueEx(sk, "old", 0, 1, nil)
+	ts.GetKeyValue(sk)
+	if len(*events) != 0 {
+		t.Fatal("read raised an event")
+	}
+
+	ts.SetKeyValue(sk, "new")
+	if len(*events) != 1 {
+		t.Fatal("lazy event count")
+	}
+
+	ev := (*events)[0]
+	if ev.Kind != KeyEventExpired || ev.Sk.Path != "/lazy" || ev.Address != addr || ev.Value != "old" {
+		t.Error("lazy event")
+	}
+
+	// found by the sweeper
+	addr, _, _ = ts.SetKeyValueEx(MakeStoreKey("swept"), "gone", 0, 1, nil)
+	ts.SweepExpiredKeys(100)
+	if len(*events) != 2 {
+		t.Fatal("sweep event count")
+	}
+
+	ev = (*events)[1]
+	if ev.Kind != KeyEventExpired || ev.Sk.Path != "/swept" || ev.Address != addr || ev.Value != "gone" {
+		t.Error("sweep event")
+	}
+
+	// found by a delete
+	ts.SetKeyValueEx(MakeStoreKey("deleted"), "x", 0, 1, nil)
+	ts.DeleteKey(MakeStoreKey("deleted"))
+	if len(*events) != 3 || (*events)[2].Kind != KeyEventExpired {
+		t.Error("delete of expired key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestKeyEventUnsubscribe(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	events1, id1 := recordKeyEvents(ts)
+	events2, _ := recordKeyEvents(ts)
+
+	ts.SetKey(MakeStoreKey("a"))
+	ts.DeleteKey(MakeStoreKey("a"))
+
+	if !ts.UnsubscribeKeyEvents(id1) {
+		t.Error("unsubscribe")
+	}
+	if ts.UnsubscribeKeyEvents(id1) {
+		t.Error("second unsubscribe")
+	}
+
+	ts.SetKey(MakeStoreKey("b"))
+	ts.DeleteKey(MakeStoreKey("b"))
+
+	if len(*events1) != 1 || len(*events2) != 2 {
+		t.Error("event counts")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestKeyEventNotReplayed(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	ts.DeleteKey(MakeStoreKey("a"))
+	ts.SetKeyValueEx(MakeStoreKey("b"), 2, 0, time.Now().Add(-time.Second).UnixNano(), nil)
+	ts.SetKeyValue(MakeStoreKey("b"), 3)
+	ts.Close()
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	events, _ := recordKeyEvents(ts2)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	defer ts2.Close()
+
+	if len(*events) != 0 {
+		t.Error("replay raised events")
+	}
+
+	if val, _, _ := ts2.GetKeyValue(MakeStoreKey("b")); val != 3 {
+		t.Error("replay")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
@@ -1,19 +1,19 @@
 package treestore
 
-import "time"
+import "bytes"
 
-func (kn *keyNode) isExpired() bool {
+func (kn *keyNode) isExpired(now int64) bool {
 	if kn.expiration > 0 {
-		return kn.expiration < time.Now().UTC().UnixNano()
+		return kn.expiration < now
 	} else {
 		return false
 	}
 }
 
-func (kn *keyNode) hasChild() (found bool) {
+func (kn *keyNode) hasChild(now int64) (found bool) {
 	if kn.nextLevel != nil {
 		kn.nextLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
-			if !node.value.isExpired() || node.value.hasChild() {
+			if !node.value.isExpired(now) || node.value.hasChild(now) {
 				found = true
 				return false
 			}
@@ -24,6 +24,40 @@ func (kn *keyNode) hasChild() (found bool) {
 	return
 }
 
+// returns the value of the key node at `tickNs`, or the current value if
+// `tickNs` is 0
+func (kn *keyNode) valueAt(tickNs int64) *valueInstance {
+	if tickNs == 0 {
+		return kn.current
+	}
+
+	if kn.history != nil && tickNs > 0 {
+		if node := kn.history.FindLeft(unixTimestampBytes(tickNs)); node != nil {
+			return node.value
+		}
+	}
+	return nil
+}
+
+// determines if the value history reaches back to `tickNs`, so that a key
+// without a value at that time is known to have had none
+func (kn *keyNode) historyCovers(tickNs int64) bool {
+	if kn.history == nil || kn.history.nodes == 0 {
+		// a key with a value but no history has an unknown past
+		return kn.current == nil
+	}
+	if !kn.historyPruned {
+		return true
+	}
+
+	var oldest []byte
+	kn.history.Iterate(func(node *avlNode[*valueInstance]) bool {
+		oldest = node.key
+		return false
+	})
+	return bytes.Compare(oldest, unixTimestampBytes(tickNs)) <= 0
+}
+
 func (kn *keyNode) getParent() (pkn *keyNode) {
 	if kn.ownerTree != nil {
 		pkn = kn.ownerTree.parent
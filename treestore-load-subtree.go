@@ -0,0 +1,206 @@
+package treestore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+type subtreeAncestor struct {
+	address StoreAddress
+	tokens  TokenSet
+	dropped bool
+}
+
+// Reads the key `srcSk` and its children from the snapshot `fileName`, and
+// grafts them into the tree store at `destSk`. If `destSk` exists, it and its
+// children are replaced, as with Import.
+//
+// The snapshot is streamed and only the selected subtree is held in memory,
+// though the entire snapshot is read so that its checksum can be verified.
+// The keys are assigned new addresses. Relationships among the keys of the
+// subtree are remapped, relationships to the sentinel are kept, and
+// relationships to keys outside of the subtree are cleared.
+//
+// A subtree that carries auto-link definitions is rejected, since the links
+// of the definitions would refer to the keys of the snapshot.
+func (ts *TreeStore) LoadSubtree(l lane.Lane, fileName string, srcSk, destSk StoreKey) (err error) {
+	var fh afero.File
+	if fh, err = ts.fileSystem().Open(fileName); err != nil {
+		l.Errorf("failed to open %s: %s", fileName, err.Error())
+		return
+	}
+	defer fh.Close()
+
+	sr, err := ts.openSnapshot(l, fh, fileName)
+	if err != nil {
+		return
+	}
+
+	if sr.migrations != nil {
+		if err = migrateSentinel(l, sr.migrations, &sr.hdr); err != nil {
+			l.Errorf("failed to migrate sentinel of %s: %s", fileName, err.Error())
+			return
+		}
+	}
+
+	var nodes []diskKeyNode
+	if len(srcSk.Tokens) == 0 {
+		nodes = append(nodes, diskKeyNode{
+			Address:       1,
+			Values:        sr.hdr.SentinelValues,
+			Expiration:    sr.hdr.SentinelExpiration,
+			Metadata:      sr.hdr.SentinelMetadata,
+			HistoryPruned: sr.sentinelHistoryPruned,
+		})
+	}
+
+	// the key nodes arrive parent first, so the path of the current key node
+	// is tracked with a stack of its ancestors
+	stack := []subtreeAncestor{}
+	for {
+		dkn := diskKeyNode{}
+		var more bool
+		if more, err = sr.nextKeyNode(l, &dkn); err != nil {
+			return
+		}
+		if !more {
+			break
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].address != StoreAddress(dkn.ParentAddress) {
+			stack = stack[:len(stack)-1]
+		}
+
+		var parent subtreeAncestor
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		} else if dkn.ParentAddress != 1 {
+			err = fmt.Errorf("key node has bad parent address %X", dkn.ParentAddress)
+			l.Errorf("failed to load key node from %s: %s", fileName, err.Error())
+			return
+		}
+
+		node := subtreeAncestor{
+			address: StoreAddress(dkn.Address),
+			tokens:  append(append(TokenSet{}, parent.tokens...), dkn.Key),
+			dropped: parent.dropped,
+		}
+
+		if !node.dropped && sr.migrations != nil {
+			sk := MakeStoreKeyFromTokenSegments(node.tokens...)
+			if node.dropped, err = migrateDiskKey(l, sr.migrations, sr.hdr.AppVersion, sk, &dkn.Key, &dkn.Values, &dkn.Metadata, &dkn.Expiration); err != nil {
+				l.Errorf("failed to migrate key %s of %s: %s", sk.Path, fileName, err.Error())
+				return
+			}
+			node.tokens[len(node.tokens)-1] = dkn.Key
+		}
+
+		stack = append(stack, node)
+
+		if !node.dropped && isTokenSetPrefix(srcSk.Tokens, node.tokens) {
+			nodes = append(nodes, dkn)
+		}
+	}
+
+	if err = sr.verify(l); err != nil {
+		return
+	}
+
+	if len(nodes) == 0 {
+		err = fmt.Errorf("key %s not found", srcSk.Path)
+		l.Errorf("failed to load subtree from %s: %s", fileName, err.Error())
+		return
+	}
+
+	for _, dkn := range nodes {
+		if len(dkn.Indicies) > 0 {
+			err = fmt.Errorf("key %s has auto-link definitions", srcSk.Path)
+			l.Errorf("failed to load subtree from %s: %s", fileName, err.Error())
+			return
+		}
+	}
+
+	ts.graftSubtree(destSk, nodes)
+
+	l.Tracef("treestore: load subtree: %s keys:%d to:%s", srcSk.Path, len(nodes), destSk.Path)
+	return
+}
+
+// worker - tests if `tokens` is `prefix` or one of its children
+func isTokenSetPrefix(prefix, tokens TokenSet) bool {
+	if len(tokens) < len(prefix) {
+		return false
+	}
+
+	for i, segment := range prefix {
+		if !bytes.Equal(segment, tokens[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// worker - replaces `destSk` with the key nodes, which are in parent-first
+// order and start with the subtree root
+func (ts *TreeStore) graftSubtree(destSk StoreKey, nodes []diskKeyNode) {
+	ts.acquireExclusiveLock()
+	defer ts.releaseExclusiveLock()
+
+	kn, ll, _ := ts.ensureKey(destSk)
+	defer ts.completeKeyNodeWrite(ll)
+
+	ts.resetNode(destSk, kn)
+
+	remap := map[StoreAddress]StoreAddress{1: 1}
+	grafted := make(map[StoreAddress]*keyNode, len(nodes))
+	values := []*valueInstance{}
+
+	for i, dkn := range nodes {
+		gkn := kn
+		if i > 0 {
+			parent := grafted[StoreAddress(dkn.ParentAddress)]
+			if parent.nextLevel == nil {
+				ts.preserveKeyNode(parent)
+				parent.nextLevel = newKeyTree(parent)
+			}
+			gkn = ts.appendKeyNode(parent.nextLevel, dkn.Key)
+		}
+
+		remap[StoreAddress(dkn.Address)] = gkn.address
+		grafted[StoreAddress(dkn.Address)] = gkn
+
+		ts.preserveKeyNode(gkn)
+		ts.setKeyExpiration(gkn, dkn.Expiration)
+		gkn.metadata = dkn.Metadata
+		gkn.autoLinks = diskKiToKi(dkn.Indicies)
+		gkn.current, gkn.history = loadValues(dkn.Values)
+		gkn.historyPruned = dkn.HistoryPruned
+
+		if gkn.history != nil {
+			gkn.history.Iterate(func(node *avlNode[*valueInstance]) bool {
+				values = append(values, node.value)
+				return true
+			})
+		} else if gkn.current != nil {
+			values = append(values, gkn.current)
+		}
+
+		if gkn.current != nil {
+			ts.addKeyToValueIndex(gkn, ts.keys)
+			ts.bumpKeyVersion(gkn)
+		}
+	}
+
+	for _, vi := range values {
+		for i, addr := range vi.relationships {
+			// addresses outside of the subtree become 0 (no relationship)
+			vi.relationships[i] = remap[addr]
+		}
+	}
+
+	ts.logMutation(&walRecord{Op: walLoadSubtree, Sk: destSk.Tokens, Nodes: nodes})
+	ts.watchTreeLocked(destSk)
+}
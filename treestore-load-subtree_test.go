@@ -0,0 +1,193 @@
+package treestore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func subtreeTestSave(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey(), "root")
+	ts.SetKeyValue(MakeStoreKey("tenants", "acme"), "acme corp")
+	ts.SetMetadataAttribute(MakeStoreKey("tenants", "acme"), "plan", "gold")
+	wilma, _ := ts.SetKeyValue(MakeStoreKey("tenants", "acme", "users", "wilma"), "admin")
+	ts.SetKeyValueEx(MakeStoreKey("tenants", "acme", "users", "fred"), "user", 0, 0, []StoreAddress{wilma})
+	other, _ := ts.SetKeyValue(MakeStoreKey("tenants", "other", "users", "barney"), "user")
+	ts.SetKeyValueEx(MakeStoreKey("tenants", "acme", "orders", "1"), 42, 0, 0, []StoreAddress{other, 1})
+	ts.SetKeyValue(MakeStoreKey("tenants", "acme-west"), "not a child")
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func subtreeTestVerify(t *testing.T, ts *TreeStore) {
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("dest"))
+	if val != "acme corp" {
+		t.Errorf("root value %v", val)
+	}
+
+	_, plan := ts.GetMetadataAttribute(MakeStoreKey("dest"), "plan")
+	if plan != "gold" {
+		t.Error("root metadata")
+	}
+
+	hasLink, rv := ts.GetRelationshipValue(MakeStoreKey("dest", "users", "fred"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/dest/users/wilma" || rv.CurrentValue != "admin" {
+		t.Error("remapped relationship")
+	}
+
+	hasLink, _ = ts.GetRelationshipValue(MakeStoreKey("dest", "orders", "1"), 0)
+	if hasLink {
+		t.Error("outside relationship")
+	}
+
+	hasLink, rv = ts.GetRelationshipValue(MakeStoreKey("dest", "orders", "1"), 1)
+	if !hasLink || rv == nil || rv.Sk.Path != "" {
+		t.Error("sentinel relationship")
+	}
+
+	_, exists := ts.LocateKey(MakeStoreKey("dest", "old"))
+	if exists {
+		t.Error("destination not replaced")
+	}
+
+	_, exists = ts.LocateKey(MakeStoreKey("tenants"))
+	if exists {
+		t.Error("unexpected key")
+	}
+
+	keys := ts.GetMatchingKeys(MakeStoreKey("dest", "**"), 0, 100, false)
+	if len(keys) != 5 {
+		t.Errorf("key count %d", len(keys))
+	}
+
+	val, _, _ = ts.GetKeyValue(MakeStoreKey("live"))
+	if val != "unchanged" {
+		t.Error("live key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestLoadSubtree(t *testing.T) {
+	subtreeTestSave(t)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetKeyValue(MakeStoreKey("live"), "unchanged")
+	ts.SetKeyValue(MakeStoreKey("dest", "old"), "replaced")
+
+	if err := ts.LoadSubtree(ts.l, "/test.db", MakeStoreKey("tenants", "acme"), MakeStoreKey("dest")); err != nil {
+		t.Fatal(err)
+	}
+
+	subtreeTestVerify(t, ts)
+}
+
+func TestLoadSubtreeRoot(t *testing.T) {
+	subtreeTestSave(t)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.LoadSubtree(ts.l, "/test.db", MakeStoreKey(), MakeStoreKey("copy")); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("copy"))
+	if val != "root" {
+		t.Error("sentinel value")
+	}
+
+	hasLink, rv := ts.GetRelationshipValue(MakeStoreKey("copy", "tenants", "acme", "orders", "1"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/copy/tenants/other/users/barney" {
+		t.Error("remapped relationship")
+	}
+
+	hasLink, rv = ts.GetRelationshipValue(MakeStoreKey("copy", "tenants", "acme", "orders", "1"), 1)
+	if !hasLink || rv == nil || rv.Sk.Path != "/copy" {
+		t.Error("sentinel relationship")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestLoadSubtreeNotFound(t *testing.T) {
+	subtreeTestSave(t)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.LoadSubtree(ts.l, "/test.db", MakeStoreKey("tenants", "missing"), MakeStoreKey("dest")); err == nil {
+		t.Error("expected not found error")
+	}
+
+	_, exists := ts.LocateKey(MakeStoreKey("dest"))
+	if exists {
+		t.Error("destination created")
+	}
+}
+
+func TestLoadSubtreeAutoLinks(t *testing.T) {
+	subtreeTestSave(t)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.Load(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+	ts.DefineAutoLinkKey(MakeStoreKey("tenants", "acme", "users"), MakeStoreKey("tenants", "acme", "names"), []SubPath{{}})
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.LoadSubtree(ts2.l, "/test.db", MakeStoreKey("tenants", "acme"), MakeStoreKey("dest")); err == nil {
+		t.Error("expected auto-link error")
+	}
+
+	_, exists := ts2.LocateKey(MakeStoreKey("dest"))
+	if exists {
+		t.Error("destination created")
+	}
+
+	// a subtree without the definition loads
+	if err := ts2.LoadSubtree(ts2.l, "/test.db", MakeStoreKey("tenants", "other"), MakeStoreKey("dest")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadSubtreeWal(t *testing.T) {
+	subtreeTestSave(t)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	ts.SetKeyValue(MakeStoreKey("live"), "unchanged")
+	ts.SetKeyValue(MakeStoreKey("dest", "old"), "replaced")
+
+	if err := ts.LoadSubtree(ts.l, "/test.db", MakeStoreKey("tenants", "acme"), MakeStoreKey("dest")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot is not needed to recover
+	fs.Remove("/test.db")
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	subtreeTestVerify(t, ts2)
+
+	addr1, _ := ts.LocateKey(MakeStoreKey("dest", "users", "fred"))
+	addr2, _ := ts2.LocateKey(MakeStoreKey("dest", "users", "fred"))
+	if addr1 != addr2 {
+		t.Error("replayed address")
+	}
+}
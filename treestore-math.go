@@ -78,49 +78,10 @@ var floatConverter typeConverter = func(x any) any {
 
 var defaultConverter typeConverter = func(x any) any { return x }
 
-// Evaluate a math expression and store the result.
-//
-// The expression operators include + - / * & | ^ ** % >> <<,
-// comparators >, <=, etc., and logical || &&.
-//
-// Constants are 64-bit floating point, string constants, dates or true/false.
-//
-// Parenthesis specify order of evaluation.
-//
-// Unary operators ! - ~ are supported.
-//
-// Ternary conditionals are supported with <expr> ? <on-true> : <on-false>
-//
-// Null coalescence is supported with ??
-//
-// Basic type conversion is supported - int(value), uint(value) and float(value)
-//
-// The target's store key original value is accessed with variable 'self'.
-//
-// The 'self' can also be referred to as 'i' for int, 'u' for uint or 'f' for float,
-// for which if there are no other types specified, the result will be stored as
-// the type specified. This is useful for compact, simple expressions such as:
-//
-//	"i+1"        increments existing int (or zero), stores result as int
-//
-// The operation is computed in 64-bit floating point before it is stored in its
-// final type.
-//
-// String values can be converted in casts, e.g., int("-35")
-//
-// Other input keys can be accessed using the lookup(sk) function, where sk is the
-// key path containing a value.
-//
-//	`lookup("/my/store/key")+25`
-//
-// If the initial slash is not specified, the store key path is a child of the
-// target sk.
-//
-// For ternary conditionals, an operation can be skipped by using fail().
-//
-//	"i>100?i+1:fail()"        no modifications if the sk value is < 100
-func (ts *TreeStore) CalculateKeyValue(sk StoreKey, expression string) (address StoreAddress, newValue any) {
-	var mathExtensions = map[string]govaluate.ExpressionFunction{
+// worker - makes the functions available to expressions; `lookup` returns
+// the value at a key path from the lookup() function
+func expressionFunctions(lookup func(subpath string) (any, error)) map[string]govaluate.ExpressionFunction {
+	return map[string]govaluate.ExpressionFunction{
 		"lookup": func(args ...any) (any, error) {
 			if len(args) != 1 {
 				return nil, errors.New("invalid expression")
@@ -130,24 +91,7 @@ func (ts *TreeStore) CalculateKeyValue(sk StoreKey, expression string) (address
 				return nil, errors.New("invalid expression")
 			}
 
-			var subSk StoreKey
-			if subpath != "" {
-				if subpath[0] != '/' {
-					subSk = MakeStoreKeyFromPath(sk.Path + "/" + TokenPath(subpath))
-				} else {
-					subSk = MakeStoreKeyFromPath(TokenPath(subpath))
-				}
-			} else {
-				subSk = MakeStoreKey()
-			}
-
-			ll, tokenIndex, kn, expired := ts.locateKeyNodeForReadLocked(subSk)
-			defer ts.completeKeyNodeRead(ll)
-			if tokenIndex < len(subSk.Tokens) || expired || kn.current == nil {
-				return nil, fmt.Errorf("value doesn't exist: %s", string(subSk.Path))
-			}
-
-			return floatConverter(kn.current.value), nil
+			return lookup(subpath)
 		},
 		"utcns": func(args ...any) (any, error) {
 			if len(args) != 0 {
@@ -212,12 +156,79 @@ func (ts *TreeStore) CalculateKeyValue(sk StoreKey, expression string) (address
 			return floatConverter(args[0]), nil
 		},
 	}
+}
+
+// worker - makes the store key of a lookup() key path; a path without an
+// initial slash is a child of `sk`
+func resolveExpressionPath(sk StoreKey, subpath string) StoreKey {
+	if subpath == "" {
+		return MakeStoreKey()
+	}
+	if subpath[0] != '/' {
+		return MakeStoreKeyFromPath(sk.Path + "/" + TokenPath(subpath))
+	}
+	return MakeStoreKeyFromPath(TokenPath(subpath))
+}
+
+// Evaluate a math expression and store the result.
+//
+// The expression operators include + - / * & | ^ ** % >> <<,
+// comparators >, <=, etc., and logical || &&.
+//
+// Constants are 64-bit floating point, string constants, dates or true/false.
+//
+// Parenthesis specify order of evaluation.
+//
+// Unary operators ! - ~ are supported.
+//
+// Ternary conditionals are supported with <expr> ? <on-true> : <on-false>
+//
+// Null coalescence is supported with ??
+//
+// Basic type conversion is supported - int(value), uint(value) and float(value)
+//
+// The target's store key original value is accessed with variable 'self'.
+//
+// The 'self' can also be referred to as 'i' for int, 'u' for uint or 'f' for float,
+// for which if there are no other types specified, the result will be stored as
+// the type specified. This is useful for compact, simple expressions such as:
+//
+//	"i+1"        increments existing int (or zero), stores result as int
+//
+// The operation is computed in 64-bit floating point before it is stored in its
+// final type.
+//
+// String values can be converted in casts, e.g., int("-35")
+//
+// Other input keys can be accessed using the lookup(sk) function, where sk is the
+// key path containing a value.
+//
+//	`lookup("/my/store/key")+25`
+//
+// If the initial slash is not specified, the store key path is a child of the
+// target sk.
+//
+// For ternary conditionals, an operation can be skipped by using fail().
+//
+//	"i>100?i+1:fail()"        no modifications if the sk value is < 100
+func (ts *TreeStore) CalculateKeyValue(sk StoreKey, expression string) (address StoreAddress, newValue any) {
+	mathExtensions := expressionFunctions(func(subpath string) (any, error) {
+		subSk := resolveExpressionPath(sk, subpath)
+
+		ll, tokenIndex, kn, expired := ts.locateKeyNodeForReadLocked(subSk)
+		defer ts.completeKeyNodeRead(ll)
+		if tokenIndex < len(subSk.Tokens) || expired || kn.current == nil {
+			return nil, fmt.Errorf("value doesn't exist: %s", string(subSk.Path))
+		}
+
+		return floatConverter(kn.current.value), nil
+	})
 
 	expr, err := govaluate.NewEvaluableExpressionWithFunctions(expression, mathExtensions)
 	if err != nil {
 		return
 	}
-	now := currentUnixTimestampBytes()
+	now := ts.currentTimestampBytes()
 
 	// the key node linkage may change
 	ts.keyNodeMu.Lock()
@@ -285,6 +296,7 @@ func (ts *TreeStore) CalculateKeyValue(sk StoreKey, expression string) (address
 	kn, ll, _ := ts.ensureKeyWithValue(sk)
 	defer ts.completeKeyNodeWrite(ll)
 
+	ts.preserveKeyNode(kn)
 	if kn.history == nil {
 		kn.history = newAvlTree[*valueInstance]()
 	}
@@ -295,8 +307,14 @@ func (ts *TreeStore) CalculateKeyValue(sk StoreKey, expression string) (address
 
 	kn.current = newLeaf
 	kn.history.Set(now, newLeaf)
+	ts.pruneHistoryLocked(kn)
+	ts.bumpKeyVersion(kn)
 
 	address = kn.address
 	newValue = result
+
+	// the computed result is logged, since the expression can depend on the time
+	ts.logMutation(&walRecord{Op: walSetKeyValue, Sk: sk.Tokens, Value: result})
+	ts.watchSetLocked(sk, kn, params["self"])
 	return
 }
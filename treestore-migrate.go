@@ -0,0 +1,145 @@
+package treestore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	// A key being loaded from a snapshot that was saved by another app
+	// version. A migration callback can change any of the fields.
+	MigrationKey struct {
+		Sk         StoreKey     // the key path, reflecting any renamed parent keys
+		Segment    TokenSegment // change to rename the key within its parent
+		Values     []MigrationValue
+		Metadata   map[string]string
+		Expiration int64
+		Drop       bool // set to omit the key and its children
+	}
+
+	// A key value; the values are in history order, and a single value with
+	// a zero Timestamp indicates the key does not keep history.
+	MigrationValue struct {
+		Value         any
+		Relationships []StoreAddress
+		Timestamp     int64
+	}
+
+	MigrationFn func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error
+
+	migration struct {
+		minAppVersion int
+		maxAppVersion int
+		fn            MigrationFn
+	}
+)
+
+// Registers a callback that Load invokes for each key of a snapshot saved
+// with an app version from `minAppVersion` through `maxAppVersion`, inclusive.
+// A snapshot with an app version that differs from the tree store's can only
+// be loaded when at least one migration covers it.
+//
+// When more than one migration covers the snapshot, they are invoked in order
+// of `minAppVersion`, then in order of registration. The sentinel key (with an
+// empty Sk) is offered first, followed by the keys in parent-first order.
+//
+// Migrations apply to the snapshot content only; write-ahead log records are
+// replayed as they were recorded. Auto-link definitions and relationships that
+// refer to renamed or dropped keys are not rewritten.
+func (ts *TreeStore) RegisterMigration(minAppVersion, maxAppVersion int, fn MigrationFn) {
+	ts.migrations = append(ts.migrations, migration{
+		minAppVersion: minAppVersion,
+		maxAppVersion: maxAppVersion,
+		fn:            fn,
+	})
+
+	sort.SliceStable(ts.migrations, func(i, j int) bool {
+		return ts.migrations[i].minAppVersion < ts.migrations[j].minAppVersion
+	})
+}
+
+// worker - finds the migrations to apply to a snapshot; none are needed when
+// the app version is unchanged
+func (ts *TreeStore) migrationsFor(snapshotAppVersion int) (migrations []migration, err error) {
+	if snapshotAppVersion == ts.appVersion {
+		return
+	}
+
+	for _, m := range ts.migrations {
+		if snapshotAppVersion >= m.minAppVersion && snapshotAppVersion <= m.maxAppVersion {
+			migrations = append(migrations, m)
+		}
+	}
+
+	if len(migrations) == 0 {
+		err = fmt.Errorf("unsupported app version %d (expected %d)", snapshotAppVersion, ts.appVersion)
+	}
+	return
+}
+
+// worker - offers a loaded key to each migration, then updates the disk
+// record with the result
+func migrateDiskKey(l lane.Lane, migrations []migration, snapshotAppVersion int, sk StoreKey, key *[]byte, values *[]diskValue, metadata *map[string]string, expiration *int64) (drop bool, err error) {
+	mk := MigrationKey{
+		Sk:         sk,
+		Segment:    *key,
+		Metadata:   *metadata,
+		Expiration: *expiration,
+	}
+
+	if *values != nil {
+		mk.Values = make([]MigrationValue, 0, len(*values))
+		for _, dv := range *values {
+			mk.Values = append(mk.Values, MigrationValue{
+				Value:         dv.Value,
+				Relationships: deserializeRelationshipArray(dv.Relationships),
+				Timestamp:     dv.Timestamp,
+			})
+		}
+	}
+
+	for _, m := range migrations {
+		if err = m.fn(l, snapshotAppVersion, &mk); err != nil {
+			return
+		}
+		if mk.Drop {
+			drop = true
+			return
+		}
+	}
+
+	if len(mk.Values) > 0 {
+		*values = make([]diskValue, 0, len(mk.Values))
+		for _, mv := range mk.Values {
+			*values = append(*values, diskValue{
+				Value:         mv.Value,
+				Relationships: serializeRelationshipArray(mv.Relationships),
+				Timestamp:     mv.Timestamp,
+			})
+		}
+	} else {
+		*values = nil
+	}
+
+	*key = mk.Segment
+	*metadata = mk.Metadata
+	*expiration = mk.Expiration
+	return
+}
+
+// worker - applies migrations to the sentinel, which can't be renamed or dropped
+func migrateSentinel(l lane.Lane, migrations []migration, hdr *diskHeader) (err error) {
+	key := []byte{}
+	drop, err := migrateDiskKey(l, migrations, hdr.AppVersion, MakeStoreKey(), &key, &hdr.SentinelValues, &hdr.SentinelMetadata, &hdr.SentinelExpiration)
+	if err != nil {
+		return
+	}
+
+	if drop || len(key) > 0 {
+		err = errors.New("migration can't rename or drop the sentinel key")
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package treestore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func migrateTestSave(t *testing.T, appVersion int) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), appVersion)
+
+	ts.SetKeyValue(MakeStoreKey(), "root")
+	ts.SetKeyValue(MakeStoreKey("users", "fred", "age"), 30)
+	ts.SetKeyValue(MakeStoreKey("users", "fred", "age"), 31)
+	ts.SetMetadataAttribute(MakeStoreKey("users", "fred"), "schema", "old")
+	ts.SetKeyValue(MakeStoreKey("users", "temp", "age"), 1)
+	ts.SetKeyValue(MakeStoreKey("settings"), "x")
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateOnLoad(t *testing.T) {
+	migrateTestSave(t, 1)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 3)
+
+	calls := 0
+	ts.RegisterMigration(1, 2, func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+		calls++
+		if snapshotAppVersion != 1 {
+			t.Errorf("snapshot app version %d", snapshotAppVersion)
+		}
+
+		switch mk.Sk.Path {
+		case "":
+			mk.Metadata = map[string]string{"migrated": "yes"}
+		case "/users/temp":
+			mk.Drop = true
+		case "/users/fred":
+			mk.Metadata["schema"] = "new"
+		case "/users/fred/age":
+			mk.Segment = TokenSegment("years")
+			for i := range mk.Values {
+				mk.Values[i].Value = mk.Values[i].Value.(int) * 12
+			}
+		}
+		return nil
+	})
+
+	// a second migration sees the work of the first
+	ts.RegisterMigration(1, 5, func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+		if mk.Sk.Path == "/users/fred/age" && string(mk.Segment) != "years" {
+			t.Error("migration order")
+		}
+		return nil
+	})
+
+	// not applicable to the snapshot
+	ts.RegisterMigration(2, 2, func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+		t.Error("unexpected migration")
+		return nil
+	})
+
+	if err := ts.Load(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	// sentinel, users, fred, age, temp, settings; temp's children are skipped
+	if calls != 6 {
+		t.Errorf("migration calls %d", calls)
+	}
+
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("users", "fred", "years"))
+	if val != 372 {
+		t.Errorf("migrated value %v", val)
+	}
+
+	_, exists := ts.LocateKey(MakeStoreKey("users", "fred", "age"))
+	if exists {
+		t.Error("old key")
+	}
+
+	_, exists = ts.LocateKey(MakeStoreKey("users", "temp"))
+	if exists {
+		t.Error("dropped key")
+	}
+	_, exists = ts.LocateKey(MakeStoreKey("users", "temp", "age"))
+	if exists {
+		t.Error("dropped child")
+	}
+
+	_, schema := ts.GetMetadataAttribute(MakeStoreKey("users", "fred"), "schema")
+	if schema != "new" {
+		t.Error("migrated metadata")
+	}
+
+	_, migrated := ts.GetMetadataAttribute(MakeStoreKey(), "migrated")
+	if migrated != "yes" {
+		t.Error("migrated sentinel")
+	}
+
+	val, _, _ = ts.GetKeyValue(MakeStoreKey("settings"))
+	if val != "x" {
+		t.Error("unchanged key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestMigrateNotCovered(t *testing.T) {
+	migrateTestSave(t, 1)
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 3)
+	ts.RegisterMigration(2, 2, func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+		return nil
+	})
+
+	if err := ts.Load(ts.l, "/test.db"); err == nil {
+		t.Error("expected app version error")
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	migrateTestSave(t, 1)
+
+	failure := errors.New("failed")
+	cases := []MigrationFn{
+		func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+			if mk.Sk.Path == "/settings" {
+				return failure
+			}
+			return nil
+		},
+		func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+			if mk.Sk.Path == "/users/temp" {
+				mk.Segment = TokenSegment("fred")
+			}
+			return nil
+		},
+		func(l lane.Lane, snapshotAppVersion int, mk *MigrationKey) error {
+			if mk.Sk.Path == "" {
+				mk.Drop = true
+			}
+			return nil
+		},
+	}
+
+	for i, fn := range cases {
+		ts := NewTreeStore(lane.NewTestingLane(context.Background()), 2)
+		ts.RegisterMigration(1, 1, fn)
+
+		if err := ts.Load(ts.l, "/test.db"); err == nil {
+			t.Errorf("case %d: expected load error", i)
+		}
+
+		_, exists := ts.LocateKey(MakeStoreKey("settings"))
+		if exists {
+			t.Errorf("case %d: partial load", i)
+		}
+	}
+}
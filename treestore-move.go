@@ -28,6 +28,7 @@ func (ts *TreeStore) indexMovedNodesLocked(sk StoreKey, kn *keyNode, oldSrcAddre
 		if kn.current.relationships != nil {
 			for i, v := range kn.current.relationships {
 				if v != 0 && (v == oldSrcAddress || v == oldDestAddress) {
+					ts.preserveKeyNode(kn)
 					kn.current.relationships[i] = newAddress
 				}
 			}
@@ -76,6 +77,11 @@ func (ts *TreeStore) MoveReferencedKey(srcSk, destSk StoreKey, overwrite bool, t
 	ts.acquireExclusiveLock()
 	defer ts.releaseExclusiveLock()
 
+	return ts.moveReferencedKeyLogged(srcSk, destSk, overwrite, ttl, refs, unrefs)
+}
+
+// worker - performs MoveReferencedKey; the caller must hold the exclusive lock
+func (ts *TreeStore) moveReferencedKeyLogged(srcSk, destSk StoreKey, overwrite bool, ttl int64, refs []StoreKey, unrefs []StoreKey) (exists, moved bool) {
 	slevel, tokenIndex, skn, expired := ts.locateKeyNodeForLock(srcSk)
 	if tokenIndex < len(srcSk.Tokens) || expired {
 		return
@@ -129,6 +135,8 @@ func (ts *TreeStore) MoveReferencedKey(srcSk, destSk StoreKey, overwrite bool, t
 	dkn, ll, _ := ts.ensureKey(destSk)
 	defer ts.completeKeyNodeWrite(ll)
 
+	ts.preserveKeyNode(dkn)
+	ts.preserveKeyNode(skn)
 	if len(srcSk.Tokens) > 0 {
 		// src not the sentinel - move child keys
 		dkn.nextLevel = skn.nextLevel
@@ -136,20 +144,30 @@ func (ts *TreeStore) MoveReferencedKey(srcSk, destSk StoreKey, overwrite bool, t
 	if ttl < 0 {
 		if len(destSk.Tokens) > 0 {
 			// dest not the sentinel - move expiration
-			dkn.expiration = skn.expiration
+			ts.setKeyExpiration(dkn, skn.expiration)
 		}
 	} else {
-		dkn.expiration = ttl
+		ts.setKeyExpiration(dkn, ttl)
 	}
 	dkn.current = skn.current
 	dkn.history = skn.history
+	dkn.historyPruned = skn.historyPruned
 	dkn.metadata = skn.metadata
 
 	skn.current = nil
-	skn.expiration = 0
+	ts.setKeyExpiration(skn, 0)
 	skn.history = nil
+	skn.historyPruned = false
 	skn.metadata = nil
 
+	if dkn.current != nil {
+		ts.bumpKeyVersion(dkn)
+	}
+	if ts.addresses[skn.address] == skn {
+		// the sentinel source remains
+		ts.bumpKeyVersion(skn)
+	}
+
 	if dkn.nextLevel != nil {
 		dkn.nextLevel.parent = dkn
 	}
@@ -164,8 +182,9 @@ func (ts *TreeStore) MoveReferencedKey(srcSk, destSk StoreKey, overwrite bool, t
 			for i, addr := range kn.current.relationships {
 				if addr == skn.address {
 					if len(kn.current.relationships) == 1 {
-						kn.expiration = 1
+						ts.setKeyExpiration(kn, 1)
 					} else {
+						ts.preserveKeyNode(kn)
 						kn.current.relationships[i] = 0
 					}
 				}
@@ -176,26 +195,47 @@ func (ts *TreeStore) MoveReferencedKey(srcSk, destSk StoreKey, overwrite bool, t
 	for _, refSk := range refs {
 		kn, created := ts.ensureKeyExclusive(refSk, true)
 		if created {
-			now := currentUnixTimestampBytes()
+			now := ts.currentTimestampBytes()
 			kn.history = newAvlTree[*valueInstance]()
 			kn.current = &valueInstance{
 				relationships: []StoreAddress{dkn.address},
 			}
 			kn.history.Set(now, kn.current)
 			ts.keys[refSk.Path] = kn.address
+			ts.bumpKeyVersion(kn)
 		} else if kn.current != nil {
 			for i, addr := range kn.current.relationships {
 				if addr == skn.address || (addr != 0 && addr == oldDestAddress) {
+					ts.preserveKeyNode(kn)
 					kn.current.relationships[i] = dkn.address
 				}
 			}
 		}
 
 		if ttl >= 0 {
-			kn.expiration = ttl
+			ts.setKeyExpiration(kn, ttl)
 		}
 	}
 
 	moved = true
+
+	ts.logMutation(&walRecord{
+		Op:         walMoveReferencedKey,
+		Sk:         srcSk.Tokens,
+		Sk2:        destSk.Tokens,
+		Option:     overwrite,
+		Expiration: ttl,
+		Refs:       walTokenSets(refs),
+		Unrefs:     walTokenSets(unrefs),
+	})
+
+	ts.watchTreeLocked(srcSk)
+	ts.watchTreeLocked(destSk)
+	for _, unref := range unrefs {
+		ts.watchTreeLocked(unref)
+	}
+	for _, ref := range refs {
+		ts.watchTreeLocked(ref)
+	}
 	return
 }
@@ -0,0 +1,306 @@
+package treestore
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// a segment with this prefix is a regular expression; see GetMatchingKeys
+const regexSegmentPrefix = "re:"
+
+// the compiled regular expression segments, which are reset when full
+var regexSegments = struct {
+	mu       sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: map[string]*regexp.Regexp{}}
+
+const maxRegexSegments = 1000
+
+// Escapes the pattern characters of a segment, so that the pattern matches
+// the segment literally. The escapes require SetExtendedPatterns.
+func EscapePatternSegment(segment string) string {
+	var sb strings.Builder
+
+	if strings.HasPrefix(segment, regexSegmentPrefix) {
+		sb.WriteRune('\\')
+	}
+	for _, ch := range segment {
+		switch ch {
+		case '\\', '*', '[', ']', '{', '}', ',':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(ch)
+	}
+
+	return sb.String()
+}
+
+// worker - determines if a segment must be matched as a pattern; a literal
+// segment can be located directly
+func isPatternSegment(segment []byte) bool {
+	return bytes.ContainsAny(segment, `*[{\`) || bytes.HasPrefix(segment, []byte(regexSegmentPrefix))
+}
+
+// a segment of a key pattern, compiled once per query
+type patternSegment struct {
+	segment TokenSegment
+	multi   bool                        // "**", which matches any number of levels
+	match   func(candidate []byte) bool // nil when the segment is literal
+}
+
+// Enables the character classes, alternation, escapes and regex segments
+// described in GetMatchingKeys. Without them, only "*" is special in a key
+// pattern, so that a pattern segment such as "a[1]" matches only the key
+// segment "a[1]". Call before the tree store is put to use.
+func (ts *TreeStore) SetExtendedPatterns(enabled bool) {
+	ts.extPatterns = enabled
+}
+
+// worker - compiles the segments of a key pattern for matching
+func (ts *TreeStore) compileKeyPattern(segments []TokenSegment) []patternSegment {
+	pattern := make([]patternSegment, 0, len(segments))
+	for _, seg := range segments {
+		ps := patternSegment{segment: seg}
+		if string(seg) == "**" {
+			ps.multi = true
+		} else if ts.isPatternSegment(seg) {
+			ps.match = ts.segmentMatcher(string(seg))
+		}
+		pattern = append(pattern, ps)
+	}
+	return pattern
+}
+
+// worker - tests a key segment against a compiled pattern segment
+func (ps *patternSegment) matches(candidate []byte) bool {
+	if ps.match == nil {
+		return bytes.Equal(ps.segment, candidate)
+	}
+	return ps.match(candidate)
+}
+
+// worker - determines if a segment must be matched as a pattern, according
+// to the pattern syntax of the store
+func (ts *TreeStore) isPatternSegment(segment []byte) bool {
+	if ts.extPatterns {
+		return isPatternSegment(segment)
+	}
+	return bytes.IndexByte(segment, '*') >= 0
+}
+
+// worker - makes a matching function for a segment pattern, according to
+// the pattern syntax of the store
+func (ts *TreeStore) segmentMatcher(pattern string) func(candidate []byte) bool {
+	if ts.extPatterns {
+		return segmentMatcher(pattern)
+	}
+	return segmentMatcher(wildcardPattern(pattern))
+}
+
+// worker - escapes the pattern characters of a segment other than "*"
+func wildcardPattern(pattern string) string {
+	var sb strings.Builder
+
+	if strings.HasPrefix(pattern, regexSegmentPrefix) {
+		sb.WriteRune('\\')
+	}
+	for _, ch := range pattern {
+		switch ch {
+		case '\\', '[', ']', '{', '}', ',':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(ch)
+	}
+
+	return sb.String()
+}
+
+// worker - returns the compiled regular expression of a regex segment, or nil
+// if the expression is invalid
+func compileRegexSegment(expr string) *regexp.Regexp {
+	regexSegments.mu.Lock()
+	defer regexSegments.mu.Unlock()
+
+	re, found := regexSegments.compiled[expr]
+	if !found {
+		re, _ = regexp.Compile(`^(?:` + expr + `)$`)
+		if len(regexSegments.compiled) >= maxRegexSegments {
+			regexSegments.compiled = map[string]*regexp.Regexp{}
+		}
+		regexSegments.compiled[expr] = re
+	}
+	return re
+}
+
+// worker - makes a matching function for a segment pattern
+func segmentMatcher(pattern string) func(candidate []byte) bool {
+	if strings.HasPrefix(pattern, regexSegmentPrefix) {
+		re := compileRegexSegment(pattern[len(regexSegmentPrefix):])
+		return func(candidate []byte) bool {
+			return re != nil && re.Match(candidate)
+		}
+	}
+
+	patternRunes := []rune(pattern)
+	return func(candidate []byte) bool {
+		return isPatternRunes(patternRunes, bytes.Runes(candidate))
+	}
+}
+
+func isPatternRunes(pattern, candidate []rune) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for cpos := 0; cpos <= len(candidate); cpos++ {
+				if isPatternRunes(pattern, candidate[cpos:]) {
+					return true
+				}
+			}
+			return false
+
+		case '[':
+			if end := patternClassEnd(pattern); end > 0 {
+				if len(candidate) == 0 || !isPatternClassMatch(pattern[1:end], candidate[0]) {
+					return false
+				}
+				pattern = pattern[end+1:]
+				candidate = candidate[1:]
+				continue
+			}
+
+		case '{':
+			if end := patternAlternationEnd(pattern); end > 0 {
+				rest := pattern[end+1:]
+				for _, alternative := range splitPatternAlternatives(pattern[1:end]) {
+					combined := make([]rune, 0, len(alternative)+len(rest))
+					combined = append(combined, alternative...)
+					combined = append(combined, rest...)
+					if isPatternRunes(combined, candidate) {
+						return true
+					}
+				}
+				return false
+			}
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+
+		// an unterminated class or alternation is literal
+		if len(candidate) == 0 || pattern[0] != candidate[0] {
+			return false
+		}
+		pattern = pattern[1:]
+		candidate = candidate[1:]
+	}
+
+	return len(candidate) == 0
+}
+
+// worker - returns the index of the bracket that closes the character class
+// at the start of the pattern, or -1 if the class is unterminated
+func patternClassEnd(pattern []rune) int {
+	pos := 1
+	if pos < len(pattern) && (pattern[pos] == '!' || pattern[pos] == '^') {
+		pos++
+	}
+	if pos < len(pattern) && pattern[pos] == ']' {
+		// a leading bracket is a member of the class
+		pos++
+	}
+
+	for ; pos < len(pattern); pos++ {
+		switch pattern[pos] {
+		case '\\':
+			pos++
+		case ']':
+			return pos
+		}
+	}
+	return -1
+}
+
+// worker - tests a character against the body of a character class
+func isPatternClassMatch(class []rune, ch rune) bool {
+	negate := len(class) > 0 && (class[0] == '!' || class[0] == '^')
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for pos := 0; pos < len(class); pos++ {
+		low := class[pos]
+		if low == '\\' && pos+1 < len(class) {
+			pos++
+			low = class[pos]
+		}
+
+		high := low
+		if pos+2 < len(class) && class[pos+1] == '-' {
+			pos += 2
+			high = class[pos]
+			if high == '\\' && pos+1 < len(class) {
+				pos++
+				high = class[pos]
+			}
+		}
+
+		if ch >= low && ch <= high {
+			matched = true
+		}
+	}
+
+	return matched != negate
+}
+
+// worker - returns the index of the brace that closes the alternation at the
+// start of the pattern, or -1 if the alternation is unterminated
+func patternAlternationEnd(pattern []rune) int {
+	depth := 0
+	for pos := 0; pos < len(pattern); pos++ {
+		switch pattern[pos] {
+		case '\\':
+			pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return pos
+			}
+		}
+	}
+	return -1
+}
+
+// worker - splits the body of an alternation at its top level commas
+func splitPatternAlternatives(body []rune) (alternatives [][]rune) {
+	depth := 0
+	start := 0
+	for pos := 0; pos < len(body); pos++ {
+		switch body[pos] {
+		case '\\':
+			pos++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, body[start:pos])
+				start = pos + 1
+			}
+		}
+	}
+	return append(alternatives, body[start:])
+}
@@ -0,0 +1,165 @@
+package treestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func TestIsPatternSyntax(t *testing.T) {
+	cases := []struct {
+		pattern   string
+		candidate string
+		match     bool
+	}{
+		{"[abc]at", "bat", true},
+		{"[abc]at", "rat", false},
+		{"[a-c]*", "cow", true},
+		{"[!a-c]*", "cow", false},
+		{"[^a-c]*", "dog", true},
+		{"[]x]", "]", true},
+		{"[a-]", "-", true},
+		{"v[0-9][0-9]", "v42", true},
+		{"v[0-9][0-9]", "v4", false},
+		{"[abc", "[abc", true},
+		{"{cat,dog}", "dog", true},
+		{"{cat,dog}", "cow", false},
+		{"{cat,dog}s", "cats", true},
+		{"{c*,d[o]g}", "cow", true},
+		{"{a,{b,c}}x", "cx", true},
+		{"{}x", "x", true},
+		{"{a,b", "{a,b", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{`\[x]`, "[x]", true},
+		{`\{a,b}`, "{a,b}", true},
+		{"re:v[0-9]+", "v123", true},
+		{"re:v[0-9]+", "v123x", false},
+		{"re:a|b", "b", true},
+		{"re:(", "(", false},
+		{`\re:x`, "re:x", true},
+		{"a**", "a", true},
+	}
+
+	for _, c := range cases {
+		if segmentMatcher(c.pattern)([]byte(c.candidate)) != c.match {
+			t.Errorf("%q vs %q", c.pattern, c.candidate)
+		}
+	}
+
+	for _, literal := range []string{"a*b", "[x]", "{a,b}", `back\slash`, "re:x", "plain"} {
+		escaped := EscapePatternSegment(literal)
+		if !segmentMatcher(escaped)([]byte(literal)) || segmentMatcher(escaped)([]byte(literal+"x")) {
+			t.Errorf("escaped %q as %q", literal, escaped)
+		}
+	}
+
+	if isPatternSegment([]byte("plain")) || !isPatternSegment([]byte(`a\b`)) || !isPatternSegment([]byte("re:x")) {
+		t.Error("pattern segment detection")
+	}
+}
+
+func TestPatternQueries(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetExtendedPatterns(true)
+
+	for _, name := range []string{"a1", "a2", "b1", "b22", "c*"} {
+		ts.SetKeyValue(MakeStoreKey("items", name, "v1"), name)
+		ts.SetKeyValue(MakeStoreKey("items", name, "v10"), name)
+	}
+
+	segs := levelSegments(ts.GetLevelKeys(MakeStoreKey("items"), "{a,b}[0-1]", 0, 100))
+	if fmt.Sprint(segs) != "[a1 b1]" {
+		t.Errorf("level keys %v", segs)
+	}
+
+	segs = levelSegments(ts.GetLevelKeys(MakeStoreKey("items"), "re:b[0-9]+", 0, 100))
+	if fmt.Sprint(segs) != "[b1 b22]" {
+		t.Errorf("level regex %v", segs)
+	}
+
+	paths := matchPaths(ts.GetMatchingKeys(MakeStoreKey("items", "[ab]2*", "re:v1[0-9]"), 0, 100, false))
+	if fmt.Sprint(paths) != "[/items/a2/v10 /items/b22/v10]" {
+		t.Errorf("matching keys %v", paths)
+	}
+
+	// a literal segment after a pattern applies only below matching keys
+	if keys := ts.GetMatchingKeys(MakeStoreKey("items", "x*", "v1"), 0, 100, false); len(keys) != 0 {
+		t.Error("literal after pattern")
+	}
+
+	// an escaped segment matches literally, and is not the wildcard
+	paths = matchPaths(ts.GetMatchingKeys(MakeStoreKey("items", EscapePatternSegment("c*"), "v1"), 0, 100, false))
+	if fmt.Sprint(paths) != "[/items/c*/v1]" {
+		t.Errorf("escaped %v", paths)
+	}
+	paths = matchPaths(ts.GetMatchingKeys(MakeStoreKeyFromPath(`/items/c\S*/v1`), 0, 100, false))
+	if fmt.Sprint(paths) != "[/items/c*/v1]" {
+		t.Errorf("escaped path %v", paths)
+	}
+
+	w := ts.Watch(MakeStoreKey("items", "{a,c}*", "re:v[0-9]"), WatchOptions{})
+	defer w.Close()
+
+	ts.SetKeyValue(MakeStoreKey("items", "a1", "v1"), 1)
+	ts.SetKeyValue(MakeStoreKey("items", "a1", "v10"), 2)
+	ts.SetKeyValue(MakeStoreKey("items", "b1", "v1"), 3)
+	ts.SetKeyValue(MakeStoreKey("items", "c*", "v2"), 4)
+
+	events := receiveWatchEvents(t, w, 2)
+	if events[0].Sk.Path != "/items/a1/v1" || events[1].Sk.Path != "/items/c*/v2" {
+		t.Error("watch events")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestPatternLiteralSyntax(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for _, name := range []string{"a1", "a[1]", "b,c", "re:x", "x"} {
+		ts.SetKeyValue(MakeStoreKey("items", name), name)
+	}
+
+	// without the extended syntax, only the wildcard is special
+	paths := matchPaths(ts.GetMatchingKeys(MakeStoreKey("items", "a[1]"), 0, 100, false))
+	if fmt.Sprint(paths) != "[/items/a[1]]" {
+		t.Errorf("literal class %v", paths)
+	}
+
+	segs := levelSegments(ts.GetLevelKeys(MakeStoreKey("items"), "a[1*", 0, 100))
+	if fmt.Sprint(segs) != "[a[1]]" {
+		t.Errorf("literal level keys %v", segs)
+	}
+
+	paths = matchPaths(ts.GetMatchingKeys(MakeStoreKey("items", "re:*"), 0, 100, false))
+	if fmt.Sprint(paths) != "[/items/re:x]" {
+		t.Errorf("literal regex %v", paths)
+	}
+
+	paths = matchPaths(ts.GetMatchingKeys(MakeStoreKey("items", "{b,c}*"), 0, 100, false))
+	if len(paths) != 0 {
+		t.Errorf("literal alternation %v", paths)
+	}
+
+	w := ts.Watch(MakeStoreKey("items", "a[1]"), WatchOptions{})
+	defer w.Close()
+
+	ts.SetKeyValue(MakeStoreKey("items", "a1"), 1)
+	ts.SetKeyValue(MakeStoreKey("items", "a[1]"), 2)
+
+	events := receiveWatchEvents(t, w, 1)
+	if events[0].Sk.Path != "/items/a[1]" {
+		t.Error("literal watch")
+	}
+
+	ts.SetExtendedPatterns(true)
+	paths = matchPaths(ts.GetMatchingKeys(MakeStoreKey("items", "a[1]"), 0, 100, false))
+	if fmt.Sprint(paths) != "[/items/a1]" {
+		t.Errorf("extended class %v", paths)
+	}
+}
@@ -0,0 +1,296 @@
+package treestore
+
+import (
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+type (
+	SnapshotCompression int
+
+	// Controls how Save, SaveOnline and SaveTo encode the snapshot content.
+	// Load detects the format from the snapshot header, but requires the
+	// encryption key if the snapshot is encrypted.
+	SnapshotOptions struct {
+		Compression SnapshotCompression
+		// An AES key of 16, 24 or 32 bytes enables AES-GCM encryption; nil
+		// disables encryption.
+		EncryptionKey []byte
+	}
+
+	// Seals the stream in chunks, so that the snapshot does not have to be
+	// held in memory. Each chunk nonce is derived from the header nonce and
+	// the chunk index, and the final chunk is flagged, so reordered or
+	// truncated chunks fail authentication. The header fields in the clear
+	// are authenticated with each chunk.
+	encryptingWriter struct {
+		w       io.Writer
+		aead    cipher.AEAD
+		nonce   []byte
+		aad     []byte
+		counter uint64
+		buf     []byte
+	}
+
+	decryptingReader struct {
+		r       io.Reader
+		aead    cipher.AEAD
+		nonce   []byte
+		aad     []byte
+		counter uint64
+		buf     []byte
+		final   bool
+	}
+)
+
+const (
+	SnapshotUncompressed SnapshotCompression = iota
+	SnapshotGzip
+)
+
+const (
+	encryptedChunkSize   = 64 * 1024
+	encryptedFrameHeader = 5 // final flag and ciphertext length
+)
+
+var errSnapshotKeyRequired = errors.New("snapshot is encrypted and no encryption key is set")
+
+// Sets the compression and encryption used to write snapshots, and the key
+// used to read encrypted snapshots.
+func (ts *TreeStore) SetSnapshotOptions(opts SnapshotOptions) (err error) {
+	if opts.Compression < SnapshotUncompressed || opts.Compression > SnapshotGzip {
+		return fmt.Errorf("unsupported snapshot compression %d", opts.Compression)
+	}
+
+	if opts.EncryptionKey != nil {
+		if _, err = aes.NewCipher(opts.EncryptionKey); err != nil {
+			return
+		}
+	}
+
+	// saves and loads read the options under the key node lock
+	ts.keyNodeMu.Lock()
+	ts.snapshotOpts = opts
+	ts.keyNodeMu.Unlock()
+	return
+}
+
+// worker - returns a copy of the snapshot options, which can be set at any time
+func (ts *TreeStore) snapshotOptions() SnapshotOptions {
+	ts.keyNodeMu.RLock()
+	defer ts.keyNodeMu.RUnlock()
+
+	return ts.snapshotOpts
+}
+
+// worker - the header fields in the clear that the encrypted chunks
+// authenticate, starting with version 9
+func headerAad(hdr *diskHeader) []byte {
+	aad := make([]byte, 0, 24+len(hdr.Nonce))
+	aad = binary.BigEndian.AppendUint64(aad, uint64(hdr.Version))
+	aad = binary.BigEndian.AppendUint64(aad, uint64(hdr.AppVersion))
+	aad = binary.BigEndian.AppendUint64(aad, uint64(hdr.Compression))
+	return append(aad, hdr.Nonce...)
+}
+
+func newSnapshotAead(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+// worker - prepares the header fields and returns the writer that the snapshot
+// body is encoded to, along with a function to close out the body
+func (opts *SnapshotOptions) bodyWriter(hdr *diskHeader, out io.Writer) (w io.Writer, closeBody func() error, err error) {
+	hdr.Compression = opts.Compression
+	w = out
+	closers := []io.Closer{}
+
+	if opts.EncryptionKey != nil {
+		var aead cipher.AEAD
+		if aead, err = newSnapshotAead(opts.EncryptionKey); err != nil {
+			return
+		}
+
+		hdr.Encrypted = true
+		hdr.Nonce = make([]byte, aead.NonceSize())
+		if _, err = rand.Read(hdr.Nonce); err != nil {
+			return
+		}
+
+		ew := &encryptingWriter{
+			w:     w,
+			aead:  aead,
+			nonce: hdr.Nonce,
+			aad:   headerAad(hdr),
+			buf:   make([]byte, 0, encryptedChunkSize),
+		}
+		w = ew
+		closers = append(closers, ew)
+	}
+
+	if opts.Compression == SnapshotGzip {
+		zw := gzip.NewWriter(w)
+		w = zw
+		closers = append(closers, zw)
+	}
+
+	closeBody = func() (err error) {
+		// innermost first, so that each layer flushes into the next
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err = closers[i].Close(); err != nil {
+				return
+			}
+		}
+		return
+	}
+	return
+}
+
+// worker - returns the reader that decodes the snapshot body described by
+// the header
+func (opts *SnapshotOptions) bodyReader(hdr *diskHeader, in io.Reader) (r io.Reader, err error) {
+	r = in
+
+	if hdr.Encrypted {
+		if opts.EncryptionKey == nil {
+			err = errSnapshotKeyRequired
+			return
+		}
+
+		var aead cipher.AEAD
+		if aead, err = newSnapshotAead(opts.EncryptionKey); err != nil {
+			return
+		}
+		if len(hdr.Nonce) != aead.NonceSize() {
+			err = errors.New("invalid snapshot nonce")
+			return
+		}
+
+		dr := &decryptingReader{
+			r:     r,
+			aead:  aead,
+			nonce: hdr.Nonce,
+		}
+		if hdr.Version >= 9 {
+			dr.aad = headerAad(hdr)
+		}
+		r = dr
+	}
+
+	switch hdr.Compression {
+	case SnapshotUncompressed:
+	case SnapshotGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(r); err != nil {
+			return
+		}
+		// the checksum trailer follows the compressed body
+		zr.Multistream(false)
+		r = zr
+	default:
+		err = fmt.Errorf("unsupported snapshot compression %d", hdr.Compression)
+	}
+	return
+}
+
+// worker - computes the nonce of a chunk
+func chunkNonce(base []byte, counter uint64) []byte {
+	nonce := make([]byte, len(base))
+	copy(nonce, base)
+	n := len(nonce)
+	binary.BigEndian.PutUint64(nonce[n-8:], binary.BigEndian.Uint64(nonce[n-8:])^counter)
+	return nonce
+}
+
+func (ew *encryptingWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if len(ew.buf) == encryptedChunkSize {
+			if err = ew.seal(false); err != nil {
+				return
+			}
+		}
+
+		count := min(encryptedChunkSize-len(ew.buf), len(p))
+		ew.buf = append(ew.buf, p[:count]...)
+		p = p[count:]
+		n += count
+	}
+	return
+}
+
+func (ew *encryptingWriter) Close() error {
+	return ew.seal(true)
+}
+
+func (ew *encryptingWriter) seal(final bool) (err error) {
+	header := make([]byte, encryptedFrameHeader)
+	if final {
+		header[0] = 1
+	}
+
+	sealed := ew.aead.Seal(nil, chunkNonce(ew.nonce, ew.counter), ew.buf, append(ew.aad, header[0]))
+	ew.counter++
+	ew.buf = ew.buf[:0]
+
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err = ew.w.Write(header); err != nil {
+		return
+	}
+	_, err = ew.w.Write(sealed)
+	return
+}
+
+func (dr *decryptingReader) Read(p []byte) (n int, err error) {
+	for len(dr.buf) == 0 {
+		if dr.final {
+			return 0, io.EOF
+		}
+		if err = dr.open(); err != nil {
+			return
+		}
+	}
+
+	n = copy(p, dr.buf)
+	dr.buf = dr.buf[n:]
+	return
+}
+
+func (dr *decryptingReader) open() (err error) {
+	header := make([]byte, encryptedFrameHeader)
+	if _, err = io.ReadFull(dr.r, header); err != nil {
+		return dr.truncated(err)
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > encryptedChunkSize+uint32(dr.aead.Overhead()) {
+		return errors.New("invalid encrypted chunk length")
+	}
+
+	sealed := make([]byte, length)
+	if _, err = io.ReadFull(dr.r, sealed); err != nil {
+		return dr.truncated(err)
+	}
+
+	if dr.buf, err = dr.aead.Open(sealed[:0], chunkNonce(dr.nonce, dr.counter), sealed, append(dr.aad, header[0])); err != nil {
+		return errors.New("snapshot decryption failed")
+	}
+	dr.counter++
+	dr.final = header[0] == 1
+	return
+}
+
+func (dr *decryptingReader) truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package treestore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func snapshotTestStore() *TreeStore {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	// enough content to span several encrypted chunks
+	for i := 0; i < 2000; i++ {
+		ts.SetKeyValue(MakeStoreKey("records", fmt.Sprintf("%04d", i)), strings.Repeat("secret value ", 10))
+	}
+	ts.SetMetadataAttribute(MakeStoreKey("records"), "kind", "test")
+
+	// the sentinel is saved apart from the key nodes
+	ts.SetKeyValue(MakeStoreKey(), "secret value of the sentinel")
+	ts.SetMetadataAttribute(MakeStoreKey(), "secret value", "of the sentinel")
+	return ts
+}
+
+func snapshotTestVerify(t *testing.T, ts *TreeStore) {
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("records", "1999"))
+	if val != strings.Repeat("secret value ", 10) {
+		t.Errorf("value verify %v", val)
+	}
+
+	_, kind := ts.GetMetadataAttribute(MakeStoreKey("records"), "kind")
+	if kind != "test" {
+		t.Error("metadata verify")
+	}
+
+	val, _, _ = ts.GetKeyValue(MakeStoreKey())
+	_, attr := ts.GetMetadataAttribute(MakeStoreKey(), "secret value")
+	if val != "secret value of the sentinel" || attr != "of the sentinel" {
+		t.Error("sentinel verify")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSnapshotFormats(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	formats := []SnapshotOptions{
+		{Compression: SnapshotGzip},
+		{EncryptionKey: key},
+		{Compression: SnapshotGzip, EncryptionKey: key},
+	}
+
+	var plain bytes.Buffer
+	if err := snapshotTestStore().SaveTo(lane.NewTestingLane(context.Background()), &plain); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range formats {
+		fs = afero.NewMemMapFs()
+		ts := snapshotTestStore()
+		if err := ts.SetSnapshotOptions(opts); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ts.Save(ts.l, "/test.db"); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := afero.ReadFile(fs, "/test.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.EncryptionKey != nil && bytes.Contains(data, []byte("secret value")) {
+			t.Error("plaintext in encrypted snapshot")
+		}
+		if opts.Compression == SnapshotGzip && len(data) >= plain.Len() {
+			t.Error("snapshot not compressed")
+		}
+
+		// the format is detected, only the key must be supplied
+		ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+		ts2.SetSnapshotOptions(SnapshotOptions{EncryptionKey: opts.EncryptionKey})
+		if err = ts2.Load(ts2.l, "/test.db"); err != nil {
+			t.Fatal(err)
+		}
+		snapshotTestVerify(t, ts2)
+	}
+}
+
+func TestSnapshotEncryptionKey(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := snapshotTestStore()
+	if err := ts.SetSnapshotOptions(SnapshotOptions{EncryptionKey: []byte("0123456789abcdef")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.Load(ts2.l, "/test.db"); err != errSnapshotKeyRequired {
+		t.Errorf("expected key required, got %v", err)
+	}
+
+	ts2.SetSnapshotOptions(SnapshotOptions{EncryptionKey: []byte("fedcba9876543210")})
+	if err := ts2.Load(ts2.l, "/test.db"); err == nil {
+		t.Error("expected wrong key error")
+	}
+
+	_, exists := ts2.LocateKey(MakeStoreKey("records"))
+	if exists {
+		t.Error("partial load")
+	}
+
+	if err := ts2.SetSnapshotOptions(SnapshotOptions{EncryptionKey: []byte("short")}); err == nil {
+		t.Error("expected invalid key error")
+	}
+
+	if err := ts2.SetSnapshotOptions(SnapshotOptions{Compression: 99}); err == nil {
+		t.Error("expected invalid compression error")
+	}
+}
+
+func TestSnapshotEncryptedTruncated(t *testing.T) {
+	ts := snapshotTestStore()
+	key := []byte("0123456789abcdef")
+	ts.SetSnapshotOptions(SnapshotOptions{EncryptionKey: key})
+
+	var buf bytes.Buffer
+	if err := ts.SaveTo(ts.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// cut the stream in the middle of the encrypted chunks
+	data := buf.Bytes()
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetSnapshotOptions(SnapshotOptions{EncryptionKey: key})
+	if err := ts2.LoadFrom(ts2.l, bytes.NewReader(data[:len(data)/2])); err == nil {
+		t.Error("expected truncation error")
+	}
+
+	if err := ts2.LoadFrom(ts2.l, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	snapshotTestVerify(t, ts2)
+}
+
+func TestSnapshotEncryptedHeaderTampered(t *testing.T) {
+	ts := snapshotTestStore()
+	opts := SnapshotOptions{Compression: SnapshotGzip, EncryptionKey: []byte("0123456789abcdef")}
+	ts.SetSnapshotOptions(opts)
+
+	var buf bytes.Buffer
+	if err := ts.SaveTo(ts.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// clear the compression flag in the header, and recompute the checksum
+	r := bytes.NewReader(buf.Bytes())
+	var hdr diskHeader
+	if err := gob.NewDecoder(r).Decode(&hdr); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.Bytes()[buf.Len()-r.Len() : buf.Len()-sha256.Size]
+
+	hdr.Compression = SnapshotUncompressed
+	var tampered bytes.Buffer
+	if err := gob.NewEncoder(&tampered).Encode(hdr); err != nil {
+		t.Fatal(err)
+	}
+	tampered.Write(body)
+	sum := sha256.Sum256(tampered.Bytes())
+	tampered.Write(sum[:])
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetSnapshotOptions(opts)
+	err := ts2.LoadFrom(ts2.l, &tampered)
+	if err == nil || err.Error() != "snapshot decryption failed" {
+		t.Errorf("expected decryption failure, got %v", err)
+	}
+}
+
+func TestSnapshotOptionsConcurrentSave(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := snapshotTestStore()
+	ts.SetFileSystem(afs)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ts.SetSnapshotOptions(SnapshotOptions{Compression: SnapshotCompression(i % 2)})
+			time.Sleep(time.Microsecond * 100)
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		if err := ts.SaveOnline(ts.l, "/test.db"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+}
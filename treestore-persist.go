@@ -2,8 +2,14 @@ package treestore
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
 	"time"
 
 	"github.com/jimsnab/go-lane"
@@ -24,6 +30,8 @@ type (
 		Expiration    int64
 		Metadata      map[string]string
 		Indicies      []diskKid
+		Version       uint64 // starting with version 6
+		HistoryPruned bool   // starting with version 8
 	}
 	diskHeader struct {
 		Version            int
@@ -32,17 +40,86 @@ type (
 		SentinelValues     []diskValue
 		SentinelMetadata   map[string]string
 		SentinelExpiration int64
-		Cas                map[StoreAddress]uint64
+		Cas                map[StoreAddress]uint64 // versions 1 to 3; see Versions
+		WalSequence        uint64                  // last write-ahead log record included in the snapshot
+		Compression        SnapshotCompression
+		Encrypted          bool
+		Nonce              []byte
+		Versions           []diskKeyVersion // key versions in address order, so that the encoding is repeatable
+		// starting with version 5, only the version and format fields are set, and a diskContent follows
 		// variable number of diskKeyNode structs follow, terminated by a diskKeyNode that has Address of 0
 	}
+	// the store content that is not part of a key node, encoded at the start
+	// of the body so that it is compressed and encrypted with the key nodes
+	diskContent struct {
+		NextAddress           uint64
+		SentinelValues        []diskValue
+		SentinelMetadata      map[string]string
+		SentinelExpiration    int64
+		WalSequence           uint64
+		Versions              []diskKeyVersion // starting with version 6, only the version counter and the sentinel
+		Retention             HistoryRetention // starting with version 7
+		SentinelHistoryPruned bool             // starting with version 8
+	}
+	diskKeyVersion struct {
+		Address StoreAddress
+		Version uint64
+	}
 	diskKid struct {
 		IndexKey string
 		Fields   []string
 	}
+
+	hashingReader struct {
+		r *bufio.Reader
+		h hash.Hash
+	}
+
+	snapshotReader struct {
+		name                  string
+		r                     *bufio.Reader
+		hr                    *hashingReader
+		dec                   *gob.Decoder
+		body                  io.Reader
+		hdr                   diskHeader
+		retention             HistoryRetention
+		migrations            []migration
+		sentinelHistoryPruned bool
+	}
 )
 
+// Version 2 adds a sha256 checksum trailer after the terminating diskKeyNode.
+// Version 3 encodes the key nodes as a separate gob stream, which may be
+// compressed and encrypted as described by the header.
+// Version 4 saves the key versions as Versions, in place of the Cas map.
+// Version 5 moves the content fields of the header to a diskContent at the
+// start of the body, so that only the version and format are in the clear.
+// Version 6 saves the version of each key node with the key node, so that the
+// key nodes can be saved while the store changes.
+// Version 7 saves the store history retention in the diskContent.
+// Version 8 saves whether retention has discarded values from the history of
+// each key node.
+// Version 9 authenticates the header fields in the clear with each encrypted
+// chunk.
+const diskVersion = 9
+
+// The default file system, used by tree stores that have not been given one
+// with SetFileSystem.
 var fs = afero.NewOsFs()
 
+// Sets the file system used by Save, SaveOnline, Load and the write-ahead log,
+// in place of the package default. Call before the tree store is put to use.
+func (ts *TreeStore) SetFileSystem(afs afero.Fs) {
+	ts.fs = afs
+}
+
+func (ts *TreeStore) fileSystem() afero.Fs {
+	if ts.fs != nil {
+		return ts.fs
+	}
+	return fs
+}
+
 func serializeRelationshipArray(relationships []StoreAddress) []uint64 {
 	var rel []uint64
 	if relationships != nil {
@@ -146,35 +223,6 @@ func diskKiToKi(dki []diskKid) *keyAutoLinks {
 	return &kal
 }
 
-func saveChildren(parent *keyNode, enc *gob.Encoder) (err error) {
-	level := parent.nextLevel
-	if level != nil {
-		level.tree.Iterate(func(node *avlNode[*keyNode]) bool {
-			kn := node.value
-			dkn := diskKeyNode{
-				Key:           node.key,
-				Address:       uint64(kn.address),
-				ParentAddress: uint64(parent.address),
-				Values:        saveKeyValues(kn),
-				Expiration:    kn.expiration,
-				Metadata:      kn.metadata,
-				Indicies:      kiToDiskKi(kn.autoLinks),
-			}
-
-			if err = enc.Encode(dkn); err != nil {
-				return false
-			}
-
-			if err = saveChildren(kn, enc); err != nil {
-				return false
-			}
-
-			return true
-		})
-	}
-	return
-}
-
 func (ts *TreeStore) acquireExclusiveLock() {
 	ts.keyNodeMu.Lock()
 
@@ -196,50 +244,381 @@ func (ts *TreeStore) releaseExclusiveLock() {
 	ts.activeLocks.Add(-1)
 }
 
+// Writes a snapshot of the tree store to `fileName`.
+//
+// The snapshot is written to a temporary file, which is flushed to disk and then
+// renamed over `fileName`, so a crash during the save leaves the prior snapshot
+// intact. A checksum trailer is appended for Load to verify. The content can be
+// compressed and encrypted; see SetSnapshotOptions.
+//
+// The exclusive lock is held for the entire save. See SaveOnline to save
+// without blocking other operations for the duration of the disk i/o.
 func (ts *TreeStore) Save(l lane.Lane, fileName string) (err error) {
-	var fh afero.File
-	if fh, err = fs.Create(fileName); err != nil {
-		l.Errorf("failed to create %s: %s", fileName, err.Error())
+	ts.saveMu.Lock()
+	defer ts.saveMu.Unlock()
+
+	ts.acquireExclusiveLock()
+	defer ts.releaseExclusiveLock()
+
+	if err = ts.writeSnapshot(l, &storeWalker{ts: ts, locked: true}, fileName, 0); err != nil {
 		return
 	}
-	defer fh.Close()
 
+	// the snapshot now holds everything in the write-ahead log
+	err = ts.trimWriteAheadLog(l, ts.walSequence.Load())
+	return
+}
+
+// Writes a snapshot of the tree store to `fileName`, like Save, while allowing
+// other operations to continue.
+//
+// The exclusive lock is held only long enough to freeze the content of the
+// store. A writer then preserves the content of a key before it first changes
+// it, until the save completes, and the key nodes are read a level at a time.
+// The snapshot reflects the store at the moment SaveOnline was called. Changes
+// made during the save are retained by the write-ahead log, if enabled.
+func (ts *TreeStore) SaveOnline(l lane.Lane, fileName string) (err error) {
+	return ts.saveOnline(l, fileName, 0)
+}
+
+// worker - saves the frozen content of the store, keeping up to `keep` prior
+// snapshots
+func (ts *TreeStore) saveOnline(l lane.Lane, fileName string, keep int) (err error) {
+	ts.saveMu.Lock()
+	defer ts.saveMu.Unlock()
+
+	f := ts.freeze()
+	defer ts.thaw(f)
+
+	if err = ts.writeSnapshot(l, &storeWalker{ts: ts, f: f}, fileName, keep); err != nil {
+		return
+	}
+
+	err = ts.trimWriteAheadLog(l, f.walSequence)
+	return
+}
+
+// Writes a snapshot of the tree store to `w`, in the same format as Save.
+//
+// The exclusive lock is held while the snapshot is written. Unlike Save, the
+// write-ahead log is not trimmed, since the durability of `w` is unknown.
+func (ts *TreeStore) SaveTo(l lane.Lane, w io.Writer) (err error) {
 	ts.acquireExclusiveLock()
 	defer ts.releaseExclusiveLock()
 
+	return ts.encodeSnapshot(l, &storeWalker{ts: ts, locked: true}, w, "stream")
+}
+
+// worker - encodes the store content read by `sw` to a temporary file, then
+// renames it over `fileName`, first keeping copies of up to `keep` prior
+// snapshots
+func (ts *TreeStore) writeSnapshot(l lane.Lane, sw *storeWalker, fileName string, keep int) (err error) {
+	afs := ts.fileSystem()
+	tempFileName := fileName + ".tmp"
+
+	var fh afero.File
+	if fh, err = afs.Create(tempFileName); err != nil {
+		l.Errorf("failed to create %s: %s", tempFileName, err.Error())
+		return
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			fh.Close()
+		}
+		if err != nil {
+			afs.Remove(tempFileName)
+		}
+	}()
+
+	if err = ts.encodeSnapshot(l, sw, fh, fileName); err != nil {
+		return
+	}
+
+	if err = fh.Sync(); err != nil {
+		l.Errorf("failed to sync %s: %s", tempFileName, err.Error())
+		return
+	}
+
+	closed = true
+	if err = fh.Close(); err != nil {
+		l.Errorf("failed to close %s: %s", tempFileName, err.Error())
+		return
+	}
+
+	if keep > 0 {
+		if err = rotateSnapshots(l, afs, fileName, keep); err != nil {
+			return
+		}
+	}
+
+	if err = afs.Rename(tempFileName, fileName); err != nil {
+		l.Errorf("failed to rename %s to %s: %s", tempFileName, fileName, err.Error())
+		return
+	}
+	syncDir(afs, filepath.Dir(fileName))
+	return
+}
+
+// worker - shifts `fileName`.1 to `fileName`.2, and so on, discarding the
+// snapshot beyond `keep`, then copies `fileName` to `fileName`.1; the snapshot
+// at `fileName` stays in place until the new one is renamed over it
+func rotateSnapshots(l lane.Lane, afs afero.Fs, fileName string, keep int) (err error) {
+	for n := keep - 1; n > 0; n-- {
+		from := fmt.Sprintf("%s.%d", fileName, n)
+		to := fmt.Sprintf("%s.%d", fileName, n+1)
+
+		if _, statErr := afs.Stat(from); statErr != nil {
+			continue
+		}
+
+		if err = afs.Rename(from, to); err != nil {
+			l.Errorf("failed to rename %s to %s: %s", from, to, err.Error())
+			return
+		}
+	}
+
+	if _, statErr := afs.Stat(fileName); statErr != nil {
+		return
+	}
+	return copySnapshot(l, afs, fileName, fileName+".1")
+}
+
+// worker - copies the snapshot `from` to a temporary file, then renames it
+// over `to`
+func copySnapshot(l lane.Lane, afs afero.Fs, from, to string) (err error) {
+	var src afero.File
+	if src, err = afs.Open(from); err != nil {
+		l.Errorf("failed to open %s: %s", from, err.Error())
+		return
+	}
+	defer src.Close()
+
+	tempFileName := to + ".tmp"
+	var dest afero.File
+	if dest, err = afs.Create(tempFileName); err != nil {
+		l.Errorf("failed to create %s: %s", tempFileName, err.Error())
+		return
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			dest.Close()
+		}
+		if err != nil {
+			afs.Remove(tempFileName)
+		}
+	}()
+
+	if _, err = io.Copy(dest, src); err != nil {
+		l.Errorf("failed to copy %s to %s: %s", from, tempFileName, err.Error())
+		return
+	}
+
+	if err = dest.Sync(); err != nil {
+		l.Errorf("failed to sync %s: %s", tempFileName, err.Error())
+		return
+	}
+
+	closed = true
+	if err = dest.Close(); err != nil {
+		l.Errorf("failed to close %s: %s", tempFileName, err.Error())
+		return
+	}
+
+	if err = afs.Rename(tempFileName, to); err != nil {
+		l.Errorf("failed to rename %s to %s: %s", tempFileName, to, err.Error())
+	}
+	return
+}
+
+// worker - encodes the header, the store content read by `sw` and the checksum
+// trailer; `name` identifies the destination in log messages
+func (ts *TreeStore) encodeSnapshot(l lane.Lane, sw *storeWalker, out io.Writer, name string) (err error) {
 	hdr := diskHeader{
-		Version:            1,
-		AppVersion:         ts.appVersion,
-		NextAddress:        ts.nextAddress.Load(),
-		SentinelValues:     saveKeyValues(&ts.dbNode),
-		SentinelMetadata:   ts.dbNode.metadata,
-		SentinelExpiration: ts.dbNode.expiration,
+		Version:    diskVersion,
+		AppVersion: ts.appVersion,
 	}
-	hdr.Cas = ts.cas
+	content := sw.content()
 
-	w := bufio.NewWriter(fh)
-	enc := gob.NewEncoder(w)
+	hasher := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(out, hasher))
 
-	if err = enc.Encode(hdr); err != nil {
-		l.Errorf("failed to encode header for %s: %s", fileName, err.Error())
+	opts := sw.snapshotOptions()
+	body, closeBody, err := opts.bodyWriter(&hdr, w)
+	if err != nil {
+		l.Errorf("failed to prepare snapshot format for %s: %s", name, err.Error())
 		return
 	}
 
-	if err = saveChildren(&ts.dbNode, enc); err != nil {
-		l.Errorf("failed to encode key node for %s: %s", fileName, err.Error())
+	if err = gob.NewEncoder(w).Encode(hdr); err != nil {
+		l.Errorf("failed to encode header for %s: %s", name, err.Error())
+		return
+	}
+
+	enc := gob.NewEncoder(body)
+	if err = enc.Encode(content); err != nil {
+		l.Errorf("failed to encode content for %s: %s", name, err.Error())
+		return
+	}
+
+	err = sw.walk(func(dkn *diskKeyNode) error {
+		return enc.Encode(dkn)
+	})
+	if err != nil {
+		l.Errorf("failed to encode key node for %s: %s", name, err.Error())
 		return
 	}
 
 	if err = enc.Encode(diskKeyNode{}); err != nil {
-		l.Errorf("failed to encode termination key node for %s: %s", fileName, err.Error())
+		l.Errorf("failed to encode termination key node for %s: %s", name, err.Error())
+		return
+	}
+
+	if err = closeBody(); err != nil {
+		l.Errorf("failed to complete %s: %s", name, err.Error())
 		return
 	}
 
 	if err = w.Flush(); err != nil {
-		l.Errorf("failed to write %s: %s", fileName, err.Error())
+		l.Errorf("failed to write %s: %s", name, err.Error())
+		return
+	}
+
+	if _, err = out.Write(hasher.Sum(nil)); err != nil {
+		l.Errorf("failed to write checksum for %s: %s", name, err.Error())
+	}
+	return
+}
+
+// worker - makes a rename durable; not every file system supports this, so
+// errors are ignored
+func syncDir(afs afero.Fs, dirName string) {
+	dir, err := afs.Open(dirName)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// Reads from the buffered file while hashing exactly the bytes that the gob
+// decoder consumes, so the checksum trailer can be read separately.
+func (hr *hashingReader) Read(p []byte) (n int, err error) {
+	n, err = hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return
+}
+
+func (hr *hashingReader) ReadByte() (b byte, err error) {
+	if b, err = hr.r.ReadByte(); err == nil {
+		hr.h.Write([]byte{b})
+	}
+	return
+}
+
+// worker - decodes and validates the snapshot header, and prepares to read
+// the key nodes; `name` identifies the source in log messages
+func (ts *TreeStore) openSnapshot(l lane.Lane, in io.Reader, name string) (sr *snapshotReader, err error) {
+	r := bufio.NewReader(in)
+	hr := &hashingReader{r: r, h: sha256.New()}
+	sr = &snapshotReader{
+		name: name,
+		r:    r,
+		hr:   hr,
+		dec:  gob.NewDecoder(hr),
+	}
+
+	if err = sr.dec.Decode(&sr.hdr); err != nil {
+		l.Errorf("failed to decode header for %s: %s", name, err.Error())
 		return
 	}
 
+	if sr.hdr.Version < 1 || sr.hdr.Version > diskVersion {
+		err = fmt.Errorf("unsupported version %d", sr.hdr.Version)
+		l.Errorf("failed to load %s: %s", name, err.Error())
+		return
+	}
+
+	if sr.migrations, err = ts.migrationsFor(sr.hdr.AppVersion); err != nil {
+		l.Errorf("failed to load %s: %s", name, err.Error())
+		return
+	}
+
+	// starting with version 3, the key nodes are a separate stream
+	if sr.hdr.Version >= 3 {
+		opts := ts.snapshotOptions()
+		if sr.body, err = opts.bodyReader(&sr.hdr, hr); err != nil {
+			l.Errorf("failed to load %s: %s", name, err.Error())
+			return
+		}
+		sr.dec = gob.NewDecoder(sr.body)
+	}
+
+	// starting with version 5, the content fields are part of the body
+	if sr.hdr.Version >= 5 {
+		var content diskContent
+		if err = sr.dec.Decode(&content); err != nil {
+			l.Errorf("failed to decode content for %s: %s", name, err.Error())
+			return
+		}
+		sr.hdr.NextAddress = content.NextAddress
+		sr.hdr.SentinelValues = content.SentinelValues
+		sr.hdr.SentinelMetadata = content.SentinelMetadata
+		sr.hdr.SentinelExpiration = content.SentinelExpiration
+		sr.hdr.WalSequence = content.WalSequence
+		sr.hdr.Versions = content.Versions
+		sr.retention = content.Retention
+		sr.sentinelHistoryPruned = content.SentinelHistoryPruned
+	}
+
+	// an earlier snapshot does not say whether retention discarded values, so
+	// the history is assumed to be incomplete
+	if sr.hdr.Version < 8 {
+		sr.sentinelHistoryPruned = true
+	}
+	return
+}
+
+// worker - decodes the next key node, returning false after the last one
+func (sr *snapshotReader) nextKeyNode(l lane.Lane, dkn *diskKeyNode) (more bool, err error) {
+	if err = sr.dec.Decode(dkn); err != nil {
+		l.Errorf("failed to decode key node for %s: %s", sr.name, err.Error())
+		return
+	}
+
+	more = dkn.Address != 0
+	if sr.hdr.Version < 8 {
+		dkn.HistoryPruned = true
+	}
+	return
+}
+
+// worker - verifies the snapshot after the last key node has been read
+func (sr *snapshotReader) verify(l lane.Lane) (err error) {
+	if sr.hdr.Encrypted || sr.hdr.Compression != SnapshotUncompressed {
+		// consume the remainder of the body, such as the compression footer,
+		// which also authenticates the final encrypted chunk
+		if _, err = io.Copy(io.Discard, sr.body); err != nil {
+			l.Errorf("failed to load %s: %s", sr.name, err.Error())
+			return
+		}
+	}
+
+	if sr.hdr.Version >= 2 {
+		// verify the checksum trailer before accepting the content
+		expected := make([]byte, sha256.Size)
+		if _, err = io.ReadFull(sr.r, expected); err != nil {
+			err = fmt.Errorf("missing checksum: %w", err)
+			l.Errorf("failed to load %s: %s", sr.name, err.Error())
+			return
+		}
+		if !bytes.Equal(expected, sr.hr.h.Sum(nil)) {
+			err = errors.New("checksum mismatch")
+			l.Errorf("failed to load %s: %s", sr.name, err.Error())
+			return
+		}
+	}
 	return
 }
 
@@ -275,72 +654,118 @@ func (ts *TreeStore) addKeyToValueIndex(kn *keyNode, keys map[TokenPath]StoreAdd
 	keys[keyPath] = kn.address
 }
 
+// Reads a snapshot written by Save from `fileName`, replacing the content of the
+// tree store. The store is unchanged if the snapshot can't be loaded.
+//
+// If the write-ahead log is enabled, changes made after the snapshot was taken
+// are replayed.
 func (ts *TreeStore) Load(l lane.Lane, fileName string) (err error) {
 	var fh afero.File
-	if fh, err = fs.Open(fileName); err != nil {
+	if fh, err = ts.fileSystem().Open(fileName); err != nil {
 		l.Errorf("failed to open %s: %s", fileName, err.Error())
 		return
 	}
 	defer fh.Close()
 
-	r := bufio.NewReader(fh)
-	dec := gob.NewDecoder(r)
+	return ts.decodeSnapshot(l, fh, fileName)
+}
 
-	hdr := diskHeader{}
-	if err = dec.Decode(&hdr); err != nil {
-		l.Errorf("failed to decode header for %s: %s", fileName, err.Error())
-		return
-	}
+// Reads a snapshot written by Save or SaveTo from `r`, like Load.
+func (ts *TreeStore) LoadFrom(l lane.Lane, r io.Reader) (err error) {
+	return ts.decodeSnapshot(l, r, "stream")
+}
 
-	if hdr.Version != 1 {
-		err = fmt.Errorf("unsupported version %d", hdr.Version)
-		l.Errorf("failed to load %s: %s", fileName, err.Error())
+// worker - decodes and verifies a snapshot, then swaps it into the store;
+// `name` identifies the source in log messages
+func (ts *TreeStore) decodeSnapshot(l lane.Lane, in io.Reader, name string) (err error) {
+	sr, err := ts.openSnapshot(l, in, name)
+	if err != nil {
 		return
 	}
+	hdr := sr.hdr
+	migrations := sr.migrations
 
-	if hdr.AppVersion != ts.appVersion {
-		err = fmt.Errorf("unsupported app version %d (expected %d)", hdr.AppVersion, ts.appVersion)
-		l.Errorf("failed to load %s: %s", fileName, err.Error())
-		return
+	if migrations != nil {
+		if err = migrateSentinel(l, migrations, &hdr); err != nil {
+			l.Errorf("failed to migrate sentinel of %s: %s", name, err.Error())
+			return
+		}
 	}
 
+	// the loaded key nodes are linked to the sentinel when the load completes
 	dbNode := &ts.dbNode
+	dbNodeLevel := newKeyTree(dbNode)
 	sentinelCurrentValue, sentinelHistory := loadValues(hdr.SentinelValues)
-	dbNode.current = sentinelCurrentValue
-	dbNode.history = sentinelHistory
-	dbNode.metadata = hdr.SentinelMetadata
-	dbNode.expiration = hdr.SentinelExpiration
 
 	addresses := map[StoreAddress]*keyNode{1: dbNode}
 	keys := map[TokenPath]StoreAddress{}
-	if dbNode.current != nil {
-		ts.addKeyToValueIndex(dbNode, keys)
+	if sentinelCurrentValue != nil {
+		keys[""] = dbNode.address
+	}
+
+	cas := hdr.Cas
+	if cas == nil {
+		// gob omits an empty map
+		cas = make(map[StoreAddress]uint64, len(hdr.Versions))
+	}
+	for _, dv := range hdr.Versions {
+		cas[dv.Address] = dv.Version
 	}
 
+	dropped := map[StoreAddress]struct{}{}
 	keyCount := 0
 	valueCount := 0
 	for {
 		dkn := diskKeyNode{}
-		if err = dec.Decode(&dkn); err != nil {
-			l.Errorf("failed to decode key node for %s: %s", fileName, err.Error())
+		var more bool
+		if more, err = sr.nextKeyNode(l, &dkn); err != nil {
 			return
 		}
-
-		if dkn.Address == 0 {
+		if !more {
 			break
 		}
 
+		if _, parentDropped := dropped[StoreAddress(dkn.ParentAddress)]; parentDropped {
+			dropped[StoreAddress(dkn.Address)] = struct{}{}
+			continue
+		}
+
 		parent, exists := addresses[StoreAddress(dkn.ParentAddress)]
 		if !exists {
 			err = fmt.Errorf("key node has bad parent address %X", dkn.ParentAddress)
-			l.Errorf("failed to load key node from %s: %s", fileName, err.Error())
+			l.Errorf("failed to load key node from %s: %s", name, err.Error())
 			return
 		}
 
-		level := parent.nextLevel
-		if level == nil {
-			level = newKeyTree(parent)
-			parent.nextLevel = level
+		if migrations != nil {
+			sk := MakeStoreKeyFromTokenSegments(append(ts.getTokenSet(parent), dkn.Key)...)
+			var drop bool
+			if drop, err = migrateDiskKey(l, migrations, hdr.AppVersion, sk, &dkn.Key, &dkn.Values, &dkn.Metadata, &dkn.Expiration); err != nil {
+				l.Errorf("failed to migrate key %s of %s: %s", sk.Path, name, err.Error())
+				return
+			}
+			if drop {
+				dropped[StoreAddress(dkn.Address)] = struct{}{}
+				continue
+			}
+		}
+
+		var level *keyTree
+		if parent == dbNode {
+			level = dbNodeLevel
+		} else {
+			level = parent.nextLevel
+			if level == nil {
+				level = newKeyTree(parent)
+				parent.nextLevel = level
+			}
+		}
+
+		if migrations != nil && level.tree.Find(dkn.Key) != nil {
+			// a migration renamed a key to the name of a sibling
+			err = fmt.Errorf("duplicate key %s", MakeStoreKeyFromTokenSegments(append(ts.getTokenSet(parent), dkn.Key)...).Path)
+			l.Errorf("failed to migrate %s: %s", name, err.Error())
+			return
 		}
 
 		kn := keyNode{
@@ -355,9 +780,13 @@ func (ts *TreeStore) Load(l lane.Lane, fileName string) (err error) {
 		current, history := loadValues(dkn.Values)
 		kn.current = current
 		kn.history = history
+		kn.historyPruned = dkn.HistoryPruned
 
 		addresses[kn.address] = &kn
 		level.tree.Set(kn.key, &kn)
+		if dkn.Version != 0 {
+			cas[kn.address] = dkn.Version
+		}
 		keyCount++
 
 		if kn.current != nil {
@@ -366,14 +795,37 @@ func (ts *TreeStore) Load(l lane.Lane, fileName string) (err error) {
 		}
 	}
 
-	ts.acquireExclusiveLock()
-	defer ts.releaseExclusiveLock()
+	if err = sr.verify(l); err != nil {
+		return
+	}
 
+	ts.acquireExclusiveLock()
+	ts.preserveStoreContent()
+	dbNode.current = sentinelCurrentValue
+	dbNode.history = sentinelHistory
+	dbNode.historyPruned = sr.sentinelHistoryPruned
+	dbNode.metadata = hdr.SentinelMetadata
+	dbNode.expiration = hdr.SentinelExpiration
+	if dbNodeLevel.tree.nodes > 0 {
+		dbNode.nextLevel = dbNodeLevel
+	} else {
+		dbNode.nextLevel = nil
+	}
 	ts.addresses = addresses
 	ts.keys = keys
-	ts.cas = hdr.Cas
+	ts.cas = cas
 	ts.nextAddress.Store(hdr.NextAddress)
+	ts.walSequence.Store(hdr.WalSequence)
+	if hdr.Version >= 7 {
+		// an earlier snapshot leaves the store retention as it is
+		ts.retention = sr.retention
+	}
+	ts.reindexExpirations()
+	ts.releaseExclusiveLock()
+
+	l.Tracef("treestore: load: keys:%d values:%d appversion:%d migrated:%v", keyCount, valueCount, ts.appVersion, migrations != nil)
 
-	l.Tracef("treestore: load: keys:%d values:%d appversion:%d", keyCount, valueCount, ts.appVersion)
+	// apply the changes made after the snapshot was taken
+	err = ts.replayWriteAheadLog(l)
 	return
 }
@@ -1,7 +1,10 @@
 package treestore
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/gob"
 	"testing"
 	"time"
 
@@ -689,3 +692,398 @@ func TestSaveLoadIndexedValue2(t *testing.T) {
 		t.Error("final diag dump")
 	}
 }
+
+func TestSaveLoadChecksumMismatch(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	sk := MakeStoreKey("cat")
+	ts.SetKeyValue(sk, "meow")
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := afero.ReadFile(fs, "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := bytes.Index(data, []byte("meow"))
+	if pos < 0 {
+		t.Fatal("value not found in snapshot")
+	}
+	data[pos] = 'b'
+	if err = afero.WriteFile(fs, "/test.db", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetKeyValue(sk, "original")
+
+	if err = ts2.Load(ts2.l, "/test.db"); err == nil {
+		t.Error("corruption not detected")
+	}
+
+	val, _, _ := ts2.GetKeyValue(sk)
+	if val != "original" {
+		t.Error("store altered by failed load")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSaveLoadVersion1(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	// a version 1 snapshot has no checksum trailer
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	hdr := diskHeader{
+		Version:     1,
+		NextAddress: 2,
+	}
+	if err := enc.Encode(hdr); err != nil {
+		t.Fatal(err)
+	}
+	dkn := diskKeyNode{
+		Key:           []byte("cat"),
+		Address:       2,
+		ParentAddress: 1,
+		Values:        []diskValue{{Value: "meow"}},
+	}
+	if err := enc.Encode(dkn); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(diskKeyNode{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(fs, "/test.db", buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.Load(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("cat"))
+	if val != "meow" {
+		t.Error("value verify")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSaveFailureKeepsSnapshot(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	sk := MakeStoreKey("cat")
+	ts.SetKeyValue(sk, "meow")
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unregistered type can't be encoded
+	type unregistered struct{ N int }
+	ts.SetKeyValue(MakeStoreKey("dog"), unregistered{N: 1})
+
+	if err := ts.Save(ts.l, "/test.db"); err == nil {
+		t.Error("expected save error")
+	}
+
+	exists, _ := afero.Exists(fs, "/test.db.tmp")
+	if exists {
+		t.Error("temporary file left behind")
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts2.GetKeyValue(sk)
+	if val != "meow" {
+		t.Error("value verify")
+	}
+
+	_, exists = ts2.LocateKey(MakeStoreKey("dog"))
+	if exists {
+		t.Error("unexpected key")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSaveOnline(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("cat"), "meow")
+	ts.SetMetadataAttribute(MakeStoreKey("cat"), "color", "orange")
+	ts.SetKeyValue(MakeStoreKey("animals", "dog"), "bark")
+	target, _ := ts.LocateKey(MakeStoreKey("animals", "dog"))
+	ts.SetKeyValueEx(MakeStoreKey("pointer"), "to dog", 0, 0, []StoreAddress{target})
+
+	if err := ts.SaveOnline(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts2.GetKeyValue(MakeStoreKey("cat"))
+	if val != "meow" {
+		t.Error("value verify")
+	}
+
+	_, color := ts2.GetMetadataAttribute(MakeStoreKey("cat"), "color")
+	if color != "orange" {
+		t.Error("metadata verify")
+	}
+
+	hasLink, rv := ts2.GetRelationshipValue(MakeStoreKey("pointer"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/animals/dog" {
+		t.Error("relationship verify")
+	}
+
+	if ts2.nextAddress.Load() != ts.nextAddress.Load() {
+		t.Error("next address verify")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSaveOnlineFrozen(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("cat"), "meow")
+	ts.SetMetadataAttribute(MakeStoreKey("cat"), "color", "orange")
+	ts.SetKeyValue(MakeStoreKey("animals", "dog"), "bark")
+	ts.SetKeyValue(MakeStoreKey("animals", "dog", "puppy"), "yip")
+	target, _ := ts.LocateKey(MakeStoreKey("animals", "dog"))
+	ts.SetKeyValueEx(MakeStoreKey("pointer"), "to dog", 0, 0, []StoreAddress{target})
+	nextAddress := ts.nextAddress.Load()
+	_, _, _, catVersion := ts.GetKeyValueWithVersion(MakeStoreKey("cat"))
+
+	// changes made after the freeze are not part of the snapshot
+	f := ts.freeze()
+
+	ts.SetKeyValue(MakeStoreKey("cat"), "hiss")
+	ts.SetMetadataAttribute(MakeStoreKey("cat"), "color", "black")
+	ts.SetKeyValueEx(MakeStoreKey("pointer"), "to nothing", SetExNoValueUpdate, 0, []StoreAddress{0})
+	ts.DeleteKeyTree(MakeStoreKey("animals"))
+	ts.SetKeyValue(MakeStoreKey("bird"), "tweet")
+	ts.SetKeyValue(MakeStoreKey(), "root")
+
+	if err := ts.writeSnapshot(ts.l, &storeWalker{ts: ts, f: f}, "/test.db", 0); err != nil {
+		t.Fatal(err)
+	}
+	ts.thaw(f)
+
+	if ts.freezes != nil {
+		t.Error("freeze not released")
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _, version := ts2.GetKeyValueWithVersion(MakeStoreKey("cat"))
+	if val != "meow" || version != catVersion {
+		t.Error("value verify")
+	}
+
+	_, color := ts2.GetMetadataAttribute(MakeStoreKey("cat"), "color")
+	if color != "orange" {
+		t.Error("metadata verify")
+	}
+
+	val, _, _ = ts2.GetKeyValue(MakeStoreKey("animals", "dog", "puppy"))
+	if val != "yip" {
+		t.Error("removed key verify")
+	}
+
+	hasLink, rv := ts2.GetRelationshipValue(MakeStoreKey("pointer"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/animals/dog" {
+		t.Error("relationship verify")
+	}
+
+	_, exists := ts2.LocateKey(MakeStoreKey("bird"))
+	if exists {
+		t.Error("snapshot has later key")
+	}
+
+	val, _, _ = ts2.GetKeyValue(MakeStoreKey())
+	if val != nil {
+		t.Error("sentinel verify")
+	}
+
+	if ts2.nextAddress.Load() != nextAddress {
+		t.Error("next address verify")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("loaded diag dump")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSaveToLoadFrom(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("cat"), "meow")
+	ts.SetKeyValue(MakeStoreKey("animals", "dog"), "bark")
+
+	var buf bytes.Buffer
+	if err := ts.SaveTo(ts.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.LoadFrom(ts2.l, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts2.GetKeyValue(MakeStoreKey("animals", "dog"))
+	if val != "bark" {
+		t.Error("value verify")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+
+	// a truncated stream is rejected
+	ts3 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts3.LoadFrom(ts3.l, bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Error("expected load error")
+	}
+
+	_, exists := ts3.LocateKey(MakeStoreKey("cat"))
+	if exists {
+		t.Error("partial load")
+	}
+}
+
+func TestSaveLoadFileSystem(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	afs := afero.NewMemMapFs()
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	ts.SetKeyValue(MakeStoreKey("cat"), "meow")
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, _ := afero.Exists(fs, "/test.db")
+	if exists {
+		t.Error("saved to default file system")
+	}
+
+	var buf bytes.Buffer
+	if err := ts.SaveTo(ts.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := afero.ReadFile(afs, "/test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Error("file and stream differ")
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err = ts2.Load(ts2.l, "/test.db"); err == nil {
+		t.Error("loaded from default file system")
+	}
+
+	ts2.SetFileSystem(afs)
+	if err = ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts2.GetKeyValue(MakeStoreKey("cat"))
+	if val != "meow" {
+		t.Error("value verify")
+	}
+}
+
+func TestSaveLoadVersion3Cas(t *testing.T) {
+	fs = afero.NewMemMapFs()
+
+	// a version 3 snapshot saves the key versions as the Cas map
+	var buf bytes.Buffer
+	hdr := diskHeader{
+		Version:     3,
+		NextAddress: 2,
+		Cas:         map[StoreAddress]uint64{2: 7},
+	}
+	if err := gob.NewEncoder(&buf).Encode(hdr); err != nil {
+		t.Fatal(err)
+	}
+	enc := gob.NewEncoder(&buf)
+	dkn := diskKeyNode{
+		Key:           []byte("cat"),
+		Address:       2,
+		ParentAddress: 1,
+		Values:        []diskValue{{Value: "meow"}},
+	}
+	if err := enc.Encode(dkn); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode(diskKeyNode{}); err != nil {
+		t.Fatal(err)
+	}
+	checksum := sha256.Sum256(buf.Bytes())
+	buf.Write(checksum[:])
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.LoadFrom(ts.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, _, version := ts.GetKeyValueWithVersion(MakeStoreKey("cat"))
+	if version != 7 {
+		t.Errorf("version %d", version)
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestLoadNewerVersion(t *testing.T) {
+	var buf bytes.Buffer
+	hdr := diskHeader{
+		Version:     diskVersion + 1,
+		NextAddress: 2,
+	}
+	if err := gob.NewEncoder(&buf).Encode(hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts.LoadFrom(ts.l, &buf); err == nil {
+		t.Error("newer version loaded")
+	}
+}
@@ -6,16 +6,19 @@ func (ts *TreeStore) Purge() {
 
 	ts.acquireExclusiveLock()
 
+	ts.preserveStoreContent()
 	ts.dbNode = ts2.dbNode
 	ts.dbNodeLevel = ts2.dbNodeLevel
 	ts.nextAddress.Store(ts2.nextAddress.Load())
 	ts.addresses = ts2.addresses
 	ts.keys = ts2.keys
-	ts.cas = ts2.cas
+	ts.cas = map[StoreAddress]uint64{0: ts.cas[0]} // versions are never reissued
 	ts.deferredRefs = ts2.deferredRefs
 
 	ts.dbNodeLevel.parent = &ts.dbNode
+	ts.reindexExpirations()
 
 	ts.l.Warn("database content purged!")
+	ts.logMutation(&walRecord{Op: walPurge})
 	defer ts.releaseExclusiveLock()
 }
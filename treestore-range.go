@@ -0,0 +1,105 @@
+package treestore
+
+import (
+	"bytes"
+)
+
+type (
+	// Bounds the key segments of an iteration. `From` is the low bound and
+	// `To` is the high bound regardless of the order; a nil bound is
+	// unbounded, and a bound is inclusive unless it is marked exclusive.
+	// Segments compare as bytes, so fixed-width or big-endian segments (such
+	// as time-ordered keys) are ordered by their value.
+	KeyRange struct {
+		From          TokenSegment
+		To            TokenSegment
+		FromExclusive bool
+		ToExclusive   bool
+		Descending    bool
+	}
+)
+
+// worker - determines if a segment is within the range; a nil range contains
+// every segment
+func (kr *KeyRange) contains(seg TokenSegment) bool {
+	if kr == nil {
+		return true
+	}
+
+	if kr.From != nil {
+		cmp := bytes.Compare(seg, kr.From)
+		if cmp < 0 || (cmp == 0 && kr.FromExclusive) {
+			return false
+		}
+	}
+	if kr.To != nil {
+		cmp := bytes.Compare(seg, kr.To)
+		if cmp > 0 || (cmp == 0 && kr.ToExclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+func (kr *KeyRange) isDescending() bool {
+	return kr != nil && kr.Descending
+}
+
+// worker - visits the key nodes of a level that are within the range, in the
+// order of the range; the caller must hold a lock on the level
+func (kr *KeyRange) iterateLevel(level *keyTree, iter avlIterator[*keyNode]) bool {
+	return level.tree.IterateRange(kr.From, kr.To, kr.Descending, func(node *avlNode[*keyNode]) bool {
+		if !kr.contains(node.key) {
+			return true
+		}
+		return iter(node)
+	})
+}
+
+// Like GetLevelKeys, but returns up to `limit` keys with a segment in the
+// range `kr`, in ascending or descending order. The keys outside of the range
+// are not visited. If the store key does not exist, the return `keys` will be
+// nil.
+func (ts *TreeStore) GetLevelKeysInRange(sk StoreKey, pattern string, kr KeyRange, limit int) (keys []LevelKey) {
+	keys = []LevelKey{}
+
+	exists := ts.iterateLevelKeys(sk, pattern, &kr, 0, limit > 0, func(lk LevelKey) bool {
+		keys = append(keys, lk)
+		return len(keys) < limit
+	})
+
+	if !exists {
+		keys = nil
+	}
+	return
+}
+
+// Like GetMatchingKeys, but returns up to `limit` keys with a leaf segment in
+// the range `kr`. If the range is descending, keys are returned in reverse key
+// order, with children before their parent.
+//
+// The range bounds the iteration at the last level of the pattern. A pattern
+// that ends in "**" visits each key below its fixed prefix, and skips the keys
+// outside of the range.
+func (ts *TreeStore) GetMatchingKeysInRange(skPattern StoreKey, kr KeyRange, limit int, leaves bool) (keys []*KeyMatch) {
+	keys = []*KeyMatch{}
+
+	if limit <= 0 {
+		return
+	}
+
+	if len(skPattern.Tokens) == 0 {
+		// the sentinel has no leaf segment to bound
+		return ts.GetMatchingKeys(skPattern, 0, limit, leaves)
+	}
+
+	ts.iterateFull(skPattern, 0, nil, &kr, func(km *KeyMatch, patternEnd bool) bool {
+		if leaves && km.HasChildren && !patternEnd {
+			return true
+		}
+		keys = append(keys, km)
+		return len(keys) < limit
+	})
+
+	return
+}
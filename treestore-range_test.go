@@ -0,0 +1,113 @@
+package treestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func levelSegments(keys []LevelKey) (segs []string) {
+	for _, lk := range keys {
+		segs = append(segs, string(lk.Segment))
+	}
+	return
+}
+
+func matchPaths(keys []*KeyMatch) (paths []string) {
+	for _, km := range keys {
+		paths = append(paths, string(km.Key))
+	}
+	return
+}
+
+func TestLevelKeysInRange(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	events := MakeStoreKey("events")
+	for i := 1; i <= 20; i++ {
+		ts.SetKeyValue(AppendStoreKeySegmentStrings(events, fmt.Sprintf("e%02d", i)), i)
+	}
+
+	// latest N
+	segs := levelSegments(ts.GetLevelKeysInRange(events, "*", KeyRange{Descending: true}, 3))
+	if fmt.Sprint(segs) != "[e20 e19 e18]" {
+		t.Errorf("latest %v", segs)
+	}
+
+	segs = levelSegments(ts.GetLevelKeysInRange(events, "*", KeyRange{From: TokenSegment("e05"), To: TokenSegment("e08")}, 100))
+	if fmt.Sprint(segs) != "[e05 e06 e07 e08]" {
+		t.Errorf("inclusive %v", segs)
+	}
+
+	kr := KeyRange{From: TokenSegment("e05"), To: TokenSegment("e08"), FromExclusive: true, ToExclusive: true, Descending: true}
+	segs = levelSegments(ts.GetLevelKeysInRange(events, "*", kr, 100))
+	if fmt.Sprint(segs) != "[e07 e06]" {
+		t.Errorf("exclusive descending %v", segs)
+	}
+
+	// bounds need not be keys
+	segs = levelSegments(ts.GetLevelKeysInRange(events, "*1", KeyRange{From: TokenSegment("e"), To: TokenSegment("e15x")}, 100))
+	if fmt.Sprint(segs) != "[e01 e11]" {
+		t.Errorf("pattern %v", segs)
+	}
+
+	if keys := ts.GetLevelKeysInRange(events, "*", KeyRange{From: TokenSegment("f")}, 100); keys == nil || len(keys) != 0 {
+		t.Error("empty range")
+	}
+	if keys := ts.GetLevelKeysInRange(MakeStoreKey("missing"), "*", KeyRange{}, 100); keys != nil {
+		t.Error("missing key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestMatchingKeysInRange(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for _, device := range []string{"d1", "d2"} {
+		for i := 1; i <= 9; i++ {
+			ts.SetKeyValue(MakeStoreKey("events", device, fmt.Sprintf("e%d", i)), i)
+		}
+	}
+
+	pattern := MakeStoreKey("events", "*", "*")
+	kr := KeyRange{From: TokenSegment("e3"), To: TokenSegment("e5"), ToExclusive: true}
+	paths := matchPaths(ts.GetMatchingKeysInRange(pattern, kr, 100, false))
+	if fmt.Sprint(paths) != "[/events/d1/e3 /events/d1/e4 /events/d2/e3 /events/d2/e4]" {
+		t.Errorf("ascending %v", paths)
+	}
+
+	kr.Descending = true
+	paths = matchPaths(ts.GetMatchingKeysInRange(pattern, kr, 3, false))
+	if fmt.Sprint(paths) != "[/events/d2/e4 /events/d2/e3 /events/d1/e4]" {
+		t.Errorf("descending %v", paths)
+	}
+
+	// a descending walk is the reverse of the ascending walk
+	for _, p := range []StoreKey{MakeStoreKey("**"), MakeStoreKey("events", "**"), MakeStoreKey("events", "*")} {
+		all := matchPaths(ts.GetMatchingKeys(p, 0, 1000, false))
+		reversed := matchPaths(ts.GetMatchingKeysInRange(p, KeyRange{Descending: true}, 1000, false))
+		if len(all) != len(reversed) {
+			t.Fatalf("%s: %d reversed of %d", p.Path, len(reversed), len(all))
+		}
+		for i := range all {
+			if all[i] != reversed[len(reversed)-1-i] {
+				t.Errorf("%s: %s at %d", p.Path, reversed[len(reversed)-1-i], i)
+			}
+		}
+	}
+
+	// the range bounds the leaf segment at any depth
+	paths = matchPaths(ts.GetMatchingKeysInRange(MakeStoreKey("**"), KeyRange{From: TokenSegment("d"), To: TokenSegment("e1")}, 100, false))
+	if fmt.Sprint(paths) != "[/events/d1 /events/d1/e1 /events/d2 /events/d2/e1]" {
+		t.Errorf("multi-level %v", paths)
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
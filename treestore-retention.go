@@ -0,0 +1,264 @@
+package treestore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+type (
+	RetentionKind int
+
+	// Limits the value history kept for a key. The current value is always
+	// kept.
+	HistoryRetention struct {
+		Kind     RetentionKind
+		Versions int           // RetainLast: the number of values to keep, including the current value
+		Age      time.Duration // RetainNewerThan: how long a replaced value is kept
+	}
+
+	historyCompactor struct {
+		stop chan struct{}
+		done chan struct{}
+	}
+)
+
+const (
+	RetainAll       RetentionKind = iota // keep every value (the default)
+	RetainLast                           // keep the most recent Versions values
+	RetainNewerThan                      // keep values set within Age
+	RetainNone                           // keep only the current value
+)
+
+// The metadata attribute that sets the history retention of a key and the
+// keys below it. The value is the text form of a HistoryRetention, such as
+// "last:10", "newer:24h", "none" or "all". The setting nearest to a key takes
+// precedence, and the store retention applies when no key in the path sets
+// one.
+const HistoryRetentionAttribute = "history-retention"
+
+// Converts the text form of a history retention policy, as stored in the
+// HistoryRetentionAttribute metadata attribute.
+func ParseHistoryRetention(text string) (hr HistoryRetention, err error) {
+	kind, arg, _ := strings.Cut(text, ":")
+
+	switch kind {
+	case "all":
+		hr.Kind = RetainAll
+	case "none":
+		hr.Kind = RetainNone
+	case "last":
+		hr.Kind = RetainLast
+		hr.Versions, err = strconv.Atoi(arg)
+	case "newer":
+		hr.Kind = RetainNewerThan
+		hr.Age, err = time.ParseDuration(arg)
+	default:
+		err = fmt.Errorf("unknown history retention %q", text)
+		return
+	}
+
+	if err == nil {
+		err = hr.validate()
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid history retention %q: %w", text, err)
+	}
+	return
+}
+
+// Returns the text form of the policy, suitable for the
+// HistoryRetentionAttribute metadata attribute.
+func (hr HistoryRetention) String() string {
+	switch hr.Kind {
+	case RetainLast:
+		return fmt.Sprintf("last:%d", hr.Versions)
+	case RetainNewerThan:
+		return "newer:" + hr.Age.String()
+	case RetainNone:
+		return "none"
+	default:
+		return "all"
+	}
+}
+
+func (hr HistoryRetention) validate() error {
+	switch hr.Kind {
+	case RetainAll, RetainNone:
+	case RetainLast:
+		if hr.Versions < 1 {
+			return errors.New("at least one version must be retained")
+		}
+	case RetainNewerThan:
+		if hr.Age <= 0 {
+			return errors.New("retention age must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown retention kind %d", hr.Kind)
+	}
+	return nil
+}
+
+// Sets the history retention for keys that don't have a
+// HistoryRetentionAttribute setting in their path. History is pruned as
+// values are written; see also CompactHistory.
+//
+// The setting is saved in snapshots and recorded in the write-ahead log.
+func (ts *TreeStore) SetHistoryRetention(hr HistoryRetention) (err error) {
+	if err = hr.validate(); err != nil {
+		return
+	}
+
+	ts.keyNodeMu.Lock()
+	defer ts.keyNodeMu.Unlock()
+
+	ts.retention = hr
+	ts.logMutation(&walRecord{Op: walSetHistoryRetention, Retention: hr})
+	return
+}
+
+// worker - finds the retention policy that applies to a key node; the caller
+// must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) historyRetentionLocked(kn *keyNode) HistoryRetention {
+	for kn != nil {
+		if text, exists := kn.metadata[HistoryRetentionAttribute]; exists {
+			if hr, err := ParseHistoryRetention(text); err == nil {
+				return hr
+			}
+		}
+
+		pkn := kn.getParent()
+		if pkn == kn {
+			break
+		}
+		kn = pkn
+	}
+
+	return ts.retention
+}
+
+// worker - discards the value history of a key node that its retention
+// policy doesn't keep, and returns the number of values removed; the caller
+// must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) pruneHistoryLocked(kn *keyNode) (pruned int) {
+	if kn.history == nil || kn.history.nodes < 2 {
+		return
+	}
+
+	hr := ts.historyRetentionLocked(kn)
+
+	var keep int
+	var cutoff []byte
+	switch hr.Kind {
+	case RetainLast:
+		keep = hr.Versions
+	case RetainNewerThan:
+		cutoff = unixTimestampBytes(ts.currentTick() - int64(hr.Age))
+	case RetainNone:
+		keep = 1
+	default:
+		return
+	}
+
+	// history is in time order; the newest value is always kept
+	excess := kn.history.nodes - 1
+	if keep > 0 {
+		excess = kn.history.nodes - keep
+	}
+
+	var discard [][]byte
+	kn.history.Iterate(func(node *avlNode[*valueInstance]) bool {
+		if len(discard) >= excess {
+			return false
+		}
+		if cutoff != nil && bytes.Compare(node.key, cutoff) >= 0 {
+			return false
+		}
+		discard = append(discard, node.key)
+		return true
+	})
+
+	if len(discard) > 0 {
+		ts.preserveKeyNode(kn)
+		kn.historyPruned = true
+	}
+	for _, key := range discard {
+		kn.history.Delete(key)
+	}
+	return len(discard)
+}
+
+// Applies the history retention policies to every key, and returns the
+// number of values discarded. Write-time pruning only affects the keys that
+// are written, so compaction is needed after a policy is made stricter, and
+// for keys whose values age out under RetainNewerThan.
+//
+// N.B., the exclusive lock is held for the duration of the call. See also
+// EnableHistoryCompaction.
+func (ts *TreeStore) CompactHistory() (pruned int) {
+	// readers of value history hold only a level lock
+	ts.acquireExclusiveLock()
+	defer ts.releaseExclusiveLock()
+
+	for _, kn := range ts.addresses {
+		pruned += ts.pruneHistoryLocked(kn)
+	}
+	return
+}
+
+// Starts compacting value history in the background every `interval`.
+//
+// Call Close to stop the compaction.
+func (ts *TreeStore) EnableHistoryCompaction(l lane.Lane, interval time.Duration) (err error) {
+	if interval <= 0 {
+		err = errors.New("compaction interval must be positive")
+		l.Errorf("failed to enable history compaction: %s", err.Error())
+		return
+	}
+
+	hc := &historyCompactor{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if !ts.compactor.CompareAndSwap(nil, hc) {
+		err = errors.New("history compaction is already enabled")
+		l.Errorf("failed to enable history compaction: %s", err.Error())
+		return
+	}
+
+	go ts.compactorRoutine(l, hc, interval)
+	return
+}
+
+func (ts *TreeStore) compactorRoutine(l lane.Lane, hc *historyCompactor, interval time.Duration) {
+	defer close(hc.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if pruned := ts.CompactHistory(); pruned > 0 {
+			l.Tracef("treestore: history compaction: pruned:%d", pruned)
+		}
+	}
+}
+
+// worker - stops the background history compaction, if enabled
+func (ts *TreeStore) stopHistoryCompaction() {
+	if hc := ts.compactor.Swap(nil); hc != nil {
+		close(hc.stop)
+		<-hc.done
+	}
+}
@@ -0,0 +1,223 @@
+package treestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func historyCount(ts *TreeStore, sk StoreKey) int {
+	addr, exists := ts.LocateKey(sk)
+
+	ts.keyNodeMu.RLock()
+	defer ts.keyNodeMu.RUnlock()
+
+	if !exists || ts.addresses[addr].history == nil {
+		return 0
+	}
+	return ts.addresses[addr].history.nodes
+}
+
+func TestParseHistoryRetention(t *testing.T) {
+	valid := []HistoryRetention{
+		{Kind: RetainAll},
+		{Kind: RetainNone},
+		{Kind: RetainLast, Versions: 3},
+		{Kind: RetainNewerThan, Age: time.Hour},
+	}
+
+	for _, hr := range valid {
+		parsed, err := ParseHistoryRetention(hr.String())
+		if err != nil || parsed != hr {
+			t.Errorf("round trip %s: %v", hr, err)
+		}
+	}
+
+	invalid := []string{"", "last", "last:0", "last:x", "newer:-1s", "newer:1", "some"}
+	for _, text := range invalid {
+		if _, err := ParseHistoryRetention(text); err == nil {
+			t.Errorf("expected error for %q", text)
+		}
+	}
+
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if ts.SetHistoryRetention(HistoryRetention{Kind: RetainLast}) == nil {
+		t.Error("expected store retention error")
+	}
+}
+
+func TestRetentionStoreLevel(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("counter")
+
+	if err := ts.SetHistoryRetention(HistoryRetention{Kind: RetainLast, Versions: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		ts.SetKeyValue(sk, i)
+	}
+	ts.CalculateKeyValue(sk, "i+1")
+	ts.SetKeyValueEx(sk, 20, 0, -1, nil)
+
+	if historyCount(ts, sk) != 3 {
+		t.Errorf("history count %d", historyCount(ts, sk))
+	}
+
+	val, exists := ts.GetKeyValueAtTime(sk, -1)
+	if val != 20 || !exists {
+		t.Error("current value")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestRetentionPersisted(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	hr := HistoryRetention{Kind: RetainNewerThan, Age: time.Hour}
+
+	if err := ts.SetHistoryRetention(hr); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+	if ts2.retention != hr {
+		t.Errorf("loaded retention %s", ts2.retention)
+	}
+
+	snap := ts.Snapshot()
+	defer snap.Release()
+	if snap.store().retention != hr {
+		t.Errorf("snapshot retention %s", snap.store().retention)
+	}
+
+	// the write-ahead log records a change
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	hr = HistoryRetention{Kind: RetainLast, Versions: 2}
+	if err := ts.SetHistoryRetention(hr); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	ts3 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts3.SetFileSystem(afs)
+	if err := ts3.EnableWriteAheadLog(ts3.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	defer ts3.Close()
+	if ts3.retention != hr {
+		t.Errorf("replayed retention %s", ts3.retention)
+	}
+}
+
+func TestRetentionMetadata(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKey(MakeStoreKey("hot"))
+	ts.SetMetadataAttribute(MakeStoreKey("hot"), HistoryRetentionAttribute, "none")
+	ts.SetKey(MakeStoreKey("hot", "kept"))
+	ts.SetMetadataAttribute(MakeStoreKey("hot", "kept"), HistoryRetentionAttribute, "last:2")
+
+	for i := 0; i < 5; i++ {
+		ts.SetKeyValue(MakeStoreKey("hot", "a", "b"), i)
+		ts.SetKeyValue(MakeStoreKey("hot", "kept", "c"), i)
+		ts.SetKeyValue(MakeStoreKey("cold"), i)
+	}
+
+	if historyCount(ts, MakeStoreKey("hot", "a", "b")) != 1 {
+		t.Error("subtree policy")
+	}
+	if historyCount(ts, MakeStoreKey("hot", "kept", "c")) != 2 {
+		t.Error("nearest policy")
+	}
+	if historyCount(ts, MakeStoreKey("cold")) != 5 {
+		t.Error("default policy")
+	}
+
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("hot", "a", "b")); val != 4 {
+		t.Error("current value")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestRetentionCompaction(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("key")
+
+	for i := 0; i < 5; i++ {
+		ts.SetKeyValue(sk, i)
+	}
+	ts.SetKeyValue(MakeStoreKey("other"), 1)
+
+	// a stricter policy doesn't apply until the key is written or compacted
+	ts.SetHistoryRetention(HistoryRetention{Kind: RetainNewerThan, Age: time.Millisecond * 10})
+	if historyCount(ts, sk) != 5 {
+		t.Error("premature prune")
+	}
+
+	time.Sleep(time.Millisecond * 20)
+
+	if pruned := ts.CompactHistory(); pruned != 4 {
+		t.Errorf("pruned %d", pruned)
+	}
+	if historyCount(ts, sk) != 1 || historyCount(ts, MakeStoreKey("other")) != 1 {
+		t.Error("compacted count")
+	}
+	if val, _, _ := ts.GetKeyValue(sk); val != 4 {
+		t.Error("current value")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestRetentionBackgroundCompaction(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("key")
+
+	for i := 0; i < 5; i++ {
+		ts.SetKeyValue(sk, i)
+	}
+	ts.SetMetadataAttribute(sk, HistoryRetentionAttribute, "none")
+
+	if err := ts.EnableHistoryCompaction(ts.l, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if ts.EnableHistoryCompaction(ts.l, time.Millisecond) == nil {
+		t.Error("enabled twice")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for historyCount(ts, sk) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("not compacted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ts.Close()
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
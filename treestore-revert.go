@@ -0,0 +1,103 @@
+package treestore
+
+type (
+	revertEntry struct {
+		sk          StoreKey
+		hadChildren bool
+	}
+)
+
+// Restores the key and the keys below it to the values they had at
+// `tickNs`. Specify a negative `tickNs` for a time relative to now, as with
+// GetKeyValueAtTime.
+//
+// A key that had a different value at `tickNs` gets that value (and its
+// relationships) as a new current value, so the revert is recorded in the
+// value history and can itself be reverted. A key that had no value at
+// `tickNs` is deleted as with DeleteKey, which discards its history; a key
+// without a value that is left without children is deleted also. Key
+// expiration and metadata are not changed, except by deletion.
+//
+// A key is left as it is when its history does not reach back to `tickNs`,
+// such as when the history retention has discarded the older values. Keys
+// deleted since `tickNs` are not restored.
+//
+// The revert is performed under the exclusive lock, and is recorded in the
+// write-ahead log as a single entry. Returns the number of keys given their
+// historical value, and the number of keys deleted or cleared.
+func (ts *TreeStore) RevertKeyTree(sk StoreKey, tickNs int64) (updated, removed int) {
+	ts.acquireExclusiveLock()
+	defer ts.releaseExclusiveLock()
+
+	_, index, kn, expired := ts.locateKeyNodeForLock(sk)
+	if index < len(sk.Tokens) || expired {
+		return
+	}
+
+	tickNs = ts.resolveTick(tickNs)
+
+	// children are visited before their parent, so that a parent is removed
+	// only after its children
+	entries := []revertEntry{}
+	ts.collectRevertEntries(sk, kn, &entries)
+
+	ts.logAsTransaction(func() error {
+		for _, entry := range entries {
+			_, index, kn, expired := ts.locateKeyNodeForLock(entry.sk)
+			if index < len(entry.sk.Tokens) || expired {
+				continue
+			}
+
+			if vi := kn.valueAt(tickNs); vi != nil {
+				if vi != kn.current {
+					ts.revertKeyValueLocked(entry.sk, vi)
+					updated++
+				}
+				continue
+			}
+
+			if !kn.historyCovers(tickNs) {
+				// the value at tickNs is unknown
+				continue
+			}
+
+			if kn.current != nil || (entry.hadChildren && kn.nextLevel == nil) {
+				if keyRemoved, valueRemoved, _ := ts.deleteKeyLogged(entry.sk); keyRemoved || valueRemoved {
+					removed++
+				}
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// worker - lists the key and its children, children first
+func (ts *TreeStore) collectRevertEntries(sk StoreKey, kn *keyNode, entries *[]revertEntry) {
+	if kn.nextLevel != nil {
+		kn.nextLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+			ts.collectRevertEntries(AppendStoreKeySegments(sk, node.key), node.value, entries)
+			return true
+		})
+	}
+
+	*entries = append(*entries, revertEntry{sk: sk, hadChildren: kn.nextLevel != nil})
+}
+
+// worker - sets a historical value as the current value; the caller must hold
+// the exclusive lock
+func (ts *TreeStore) revertKeyValueLocked(sk StoreKey, vi *valueInstance) {
+	relationships := make([]StoreAddress, len(vi.relationships))
+	copy(relationships, vi.relationships)
+
+	address, _, originalValue := ts.setKeyValueExLocked(sk, vi.value, 0, -1, relationships)
+	ts.watchSetLocked(sk, ts.addresses[address], originalValue)
+	ts.logMutation(&walRecord{
+		Op:               walSetKeyValueEx,
+		Sk:               sk.Tokens,
+		Value:            vi.value,
+		Expiration:       -1,
+		HasRelationships: true,
+		Relationships:    relationships,
+	})
+}
@@ -0,0 +1,145 @@
+package treestore
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func TestRevertKeyTree(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	target, _ := ts.SetKey(MakeStoreKey("target"))
+	ts.SetKeyValue(MakeStoreKey("app", "config", "mode"), "safe")
+	ts.SetKeyValueEx(MakeStoreKey("app", "config", "link"), 1, 0, -1, []StoreAddress{target})
+	ts.SetKeyValue(MakeStoreKey("app", "version"), 1)
+	ts.SetKeyValue(MakeStoreKey("other"), "a")
+	tick := time.Now().UTC().UnixNano()
+
+	// the bad deploy
+	ts.SetKeyValue(MakeStoreKey("app", "config", "mode"), "garbage")
+	ts.SetKeyValueEx(MakeStoreKey("app", "config", "link"), 2, 0, -1, []StoreAddress{})
+	ts.SetKeyValue(MakeStoreKey("app", "version"), 2)
+	ts.SetKeyValue(MakeStoreKey("app", "extra", "deep"), true)
+	ts.SetKeyValue(MakeStoreKey("other"), "b")
+	beforeRevert := time.Now().UTC().UnixNano()
+
+	updated, removed := ts.RevertKeyTree(MakeStoreKey("app"), tick)
+	if updated != 3 || removed != 2 {
+		t.Errorf("updated %d removed %d", updated, removed)
+	}
+
+	jsonData, _ := ts.GetKeyAsJson(MakeStoreKey("app"), 0)
+	if string(jsonData) != `{"config":{"link":1,"mode":"safe"},"version":1}` {
+		t.Errorf("reverted json %s", string(jsonData))
+	}
+
+	if hasLink, rv := ts.GetRelationshipValue(MakeStoreKey("app", "config", "link"), 0); !hasLink || rv.Sk.Path != "/target" {
+		t.Error("reverted relationship")
+	}
+
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("other")); val != "b" {
+		t.Error("key outside the tree reverted")
+	}
+
+	// the revert is in the history, so it can be undone
+	values, _ := ts.GetKeyValueHistory(MakeStoreKey("app", "version"), 0, 0, 10, false)
+	if len(values) != 3 || values[2].Value != 1 {
+		t.Error("revert history")
+	}
+
+	updated, removed = ts.RevertKeyTree(MakeStoreKey("app"), beforeRevert)
+	if updated != 3 || removed != 0 {
+		t.Errorf("undo updated %d removed %d", updated, removed)
+	}
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("app", "version")); val != 2 {
+		t.Error("undo")
+	}
+
+	if updated, removed = ts.RevertKeyTree(MakeStoreKey("missing"), tick); updated != 0 || removed != 0 {
+		t.Error("missing key")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestRevertKeyTreeWalReplay(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a", "b"), 1)
+	tick := time.Now().UTC().UnixNano()
+	ts.SetKeyValue(MakeStoreKey("a", "b"), 2)
+	ts.SetKeyValue(MakeStoreKey("a", "c"), 3)
+
+	// compaction isn't logged, so replay must not depend on the history
+	ts.SetHistoryRetention(HistoryRetention{Kind: RetainLast, Versions: 2})
+	ts.RevertKeyTree(MakeStoreKey("a"), tick)
+	ts.Close()
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	defer ts2.Close()
+
+	jsonData, _ := ts2.GetKeyAsJson(MakeStoreKey("a"), 0)
+	if string(jsonData) != `{"b":1}` {
+		t.Errorf("replayed json %s", string(jsonData))
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestRevertKeyTreePrunedHistory(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetHistoryRetention(HistoryRetention{Kind: RetainLast, Versions: 1})
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	tick := time.Now().UTC().UnixNano()
+	ts.SetKeyValue(MakeStoreKey("a"), 2)
+	ts.SetKeyValue(MakeStoreKey("b"), 3)
+
+	// the value of a at the tick was discarded, so a is left alone; b is
+	// newer than the tick, and its history is complete
+	updated, removed := ts.RevertKeyTree(MakeStoreKey(), tick)
+	if updated != 0 || removed != 1 {
+		t.Errorf("updated %d removed %d", updated, removed)
+	}
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("a")); val != 2 {
+		t.Errorf("pruned key value %v", val)
+	}
+	if _, exists := ts.LocateKey(MakeStoreKey("b")); exists {
+		t.Error("new key not deleted")
+	}
+
+	// the pruning is saved with the snapshot
+	var buf bytes.Buffer
+	if err := ts.SaveTo(ts.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.LoadFrom(ts2.l, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, removed = ts2.RevertKeyTree(MakeStoreKey(), tick); removed != 0 {
+		t.Errorf("loaded removed %d", removed)
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
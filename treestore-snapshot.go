@@ -0,0 +1,274 @@
+package treestore
+
+import (
+	"iter"
+	"sync/atomic"
+)
+
+type (
+	// A read-only view of the tree store at the moment Snapshot was called.
+	// Changes made to the tree store afterward are not visible, and keys that
+	// were not expired when the snapshot was taken do not expire in the view.
+	//
+	// The read functions have the same semantics as their TreeStore
+	// counterparts. A snapshot is safe for concurrent use.
+	Snapshot struct {
+		ts   atomic.Pointer[TreeStore]
+		tick int64
+	}
+)
+
+// Takes a consistent read-only view of the tree store.
+//
+// The exclusive lock is held only long enough to freeze the content of the
+// store, as for SaveOnline. The view is then built a level at a time while
+// other operations continue, so it does not block writers.
+//
+// Every key node of the store is copied into the view, so each call takes
+// time and memory proportional to the size of the store, however little of
+// the view is read. Take one snapshot and page through it, rather than one
+// per page, and use SaveOnline rather than a snapshot to write the store out.
+// Values are shared rather than copied, and must not be modified by the
+// caller. Call Release when finished with the view.
+func (ts *TreeStore) Snapshot() (s *Snapshot) {
+	f := ts.freeze()
+	defer ts.thaw(f)
+
+	cts := NewTreeStore(ts.l, ts.appVersion)
+	cts.fs = ts.fs
+	cts.snapshotOpts = f.snapshotOpts
+	cts.frozenTick = f.tick
+	cts.materialize(&storeWalker{ts: ts, f: f})
+
+	s = &Snapshot{tick: cts.frozenTick}
+	s.ts.Store(cts)
+	return
+}
+
+// worker - fills a new tree store with the key nodes read by `sw`; the new
+// tree store is not yet shared, so no locks are needed
+func (cts *TreeStore) materialize(sw *storeWalker) {
+	content := sw.content()
+
+	dbNode := &cts.dbNode
+	dbNode.current, dbNode.history = loadValues(content.SentinelValues)
+	dbNode.historyPruned = content.SentinelHistoryPruned
+	dbNode.metadata = content.SentinelMetadata
+	dbNode.expiration = content.SentinelExpiration
+	if dbNode.current != nil {
+		cts.keys[""] = dbNode.address
+	}
+
+	for _, dv := range content.Versions {
+		cts.cas[dv.Address] = dv.Version
+	}
+	cts.nextAddress.Store(content.NextAddress)
+	cts.walSequence.Store(content.WalSequence)
+	cts.retention = content.Retention
+
+	// the walk visits parents before their children
+	sw.walk(func(dkn *diskKeyNode) error {
+		parent := cts.addresses[StoreAddress(dkn.ParentAddress)]
+		level := parent.nextLevel
+		if level == nil {
+			level = newKeyTree(parent)
+			parent.nextLevel = level
+		}
+
+		kn := &keyNode{
+			key:        dkn.Key,
+			address:    StoreAddress(dkn.Address),
+			ownerTree:  level,
+			expiration: dkn.Expiration,
+			metadata:   dkn.Metadata,
+			autoLinks:  diskKiToKi(dkn.Indicies),
+		}
+		kn.current, kn.history = loadValues(dkn.Values)
+		kn.historyPruned = dkn.HistoryPruned
+
+		cts.addresses[kn.address] = kn
+		level.tree.Set(kn.key, kn)
+		if dkn.Version != 0 {
+			cts.cas[kn.address] = dkn.Version
+		}
+		if kn.current != nil {
+			cts.addKeyToValueIndex(kn, cts.keys)
+		}
+		return nil
+	})
+
+	cts.reindexExpirations()
+}
+
+// Releases the memory held by the snapshot. The view is empty afterward.
+func (s *Snapshot) Release() {
+	cts := s.store()
+	empty := NewTreeStore(cts.l, cts.appVersion)
+	empty.frozenTick = s.tick
+	s.ts.Store(empty)
+}
+
+// Returns the Unix nanosecond tick at which the snapshot was taken.
+func (s *Snapshot) Tick() int64 {
+	return s.tick
+}
+
+func (s *Snapshot) store() *TreeStore {
+	return s.ts.Load()
+}
+
+// See TreeStore.IsKeyIndexed.
+func (s *Snapshot) IsKeyIndexed(sk StoreKey) (address StoreAddress, exists bool) {
+	return s.store().IsKeyIndexed(sk)
+}
+
+// See TreeStore.LocateKey.
+func (s *Snapshot) LocateKey(sk StoreKey) (address StoreAddress, exists bool) {
+	return s.store().LocateKey(sk)
+}
+
+// See TreeStore.GetKeyTtl.
+func (s *Snapshot) GetKeyTtl(sk StoreKey) (ttl int64) {
+	return s.store().GetKeyTtl(sk)
+}
+
+// See TreeStore.GetKeyValue.
+func (s *Snapshot) GetKeyValue(sk StoreKey) (value any, keyExists, valueExists bool) {
+	return s.store().GetKeyValue(sk)
+}
+
+// See TreeStore.GetKeyValueTtl.
+func (s *Snapshot) GetKeyValueTtl(sk StoreKey) (ttl int64) {
+	return s.store().GetKeyValueTtl(sk)
+}
+
+// See TreeStore.GetKeyValueAtTime. A relative `tickNs` is relative to the
+// snapshot tick.
+func (s *Snapshot) GetKeyValueAtTime(sk StoreKey, tickNs int64) (value any, exists bool) {
+	return s.store().GetKeyValueAtTime(sk, tickNs)
+}
+
+// See TreeStore.GetKeyValueHistory.
+func (s *Snapshot) GetKeyValueHistory(sk StoreKey, fromNs, toNs int64, limit int, descending bool) (values []*HistoryValue, nextNs int64) {
+	return s.store().GetKeyValueHistory(sk, fromNs, toNs, limit, descending)
+}
+
+// See TreeStore.GetKeyValueWithVersion.
+func (s *Snapshot) GetKeyValueWithVersion(sk StoreKey) (value any, keyExists, valueExists bool, version uint64) {
+	return s.store().GetKeyValueWithVersion(sk)
+}
+
+// See TreeStore.GetMetadataAttribute.
+func (s *Snapshot) GetMetadataAttribute(sk StoreKey, attribute string) (attributeExists bool, value string) {
+	return s.store().GetMetadataAttribute(sk, attribute)
+}
+
+// See TreeStore.GetMetadataAttributes.
+func (s *Snapshot) GetMetadataAttributes(sk StoreKey) (attributes []string) {
+	return s.store().GetMetadataAttributes(sk)
+}
+
+// See TreeStore.KeyFromAddress.
+func (s *Snapshot) KeyFromAddress(addr StoreAddress) (sk StoreKey, exists bool) {
+	return s.store().KeyFromAddress(addr)
+}
+
+// See TreeStore.KeyValueFromAddress.
+func (s *Snapshot) KeyValueFromAddress(addr StoreAddress) (keyExists, valueExists bool, sk StoreKey, value any) {
+	return s.store().KeyValueFromAddress(addr)
+}
+
+// See TreeStore.GetRelationshipValue.
+func (s *Snapshot) GetRelationshipValue(sk StoreKey, relationshipIndex int) (hasLink bool, rv *RelationshipValue) {
+	return s.store().GetRelationshipValue(sk, relationshipIndex)
+}
+
+// See TreeStore.GetLevelKeys.
+func (s *Snapshot) GetLevelKeys(sk StoreKey, pattern string, startAt, limit int) (keys []LevelKey) {
+	return s.store().GetLevelKeys(sk, pattern, startAt, limit)
+}
+
+// See TreeStore.GetMatchingKeys.
+func (s *Snapshot) GetMatchingKeys(skPattern StoreKey, startAt, limit int, leaves bool) (keys []*KeyMatch) {
+	return s.store().GetMatchingKeys(skPattern, startAt, limit, leaves)
+}
+
+// See TreeStore.CountLevelKeys.
+func (s *Snapshot) CountLevelKeys(sk StoreKey) (count int, exists bool) {
+	return s.store().CountLevelKeys(sk)
+}
+
+// See TreeStore.GetLevelKeysInRange.
+func (s *Snapshot) GetLevelKeysInRange(sk StoreKey, pattern string, kr KeyRange, limit int) (keys []LevelKey) {
+	return s.store().GetLevelKeysInRange(sk, pattern, kr, limit)
+}
+
+// See TreeStore.GetMatchingKeysInRange.
+func (s *Snapshot) GetMatchingKeysInRange(skPattern StoreKey, kr KeyRange, limit int, leaves bool) (keys []*KeyMatch) {
+	return s.store().GetMatchingKeysInRange(skPattern, kr, limit, leaves)
+}
+
+// See TreeStore.IterateMatchingKeys.
+func (s *Snapshot) IterateMatchingKeys(skPattern StoreKey, leaves bool) iter.Seq2[*KeyMatch, error] {
+	return s.store().IterateMatchingKeys(skPattern, leaves)
+}
+
+// See TreeStore.IterateMatchingKeyValues.
+func (s *Snapshot) IterateMatchingKeyValues(skPattern StoreKey) iter.Seq2[*KeyValueMatch, error] {
+	return s.store().IterateMatchingKeyValues(skPattern)
+}
+
+// See TreeStore.GetMatchingKeyValuesFiltered.
+func (s *Snapshot) GetMatchingKeyValuesFiltered(skPattern StoreKey, filter string, startAt, limit int) (values []*KeyValueMatch, err error) {
+	return s.store().GetMatchingKeyValuesFiltered(skPattern, filter, startAt, limit)
+}
+
+// See TreeStore.CountMatchingKeys.
+func (s *Snapshot) CountMatchingKeys(skPattern StoreKey, leaves bool) (count int) {
+	return s.store().CountMatchingKeys(skPattern, leaves)
+}
+
+// See TreeStore.AnyMatchingKey.
+func (s *Snapshot) AnyMatchingKey(skPattern StoreKey) (found bool) {
+	return s.store().AnyMatchingKey(skPattern)
+}
+
+// See TreeStore.GetLevelKeysPage.
+func (s *Snapshot) GetLevelKeysPage(sk StoreKey, pattern string, cursor PageCursor, limit int) (keys []LevelKey, next PageCursor, err error) {
+	return s.store().GetLevelKeysPage(sk, pattern, cursor, limit)
+}
+
+// See TreeStore.GetMatchingKeysPage.
+func (s *Snapshot) GetMatchingKeysPage(skPattern StoreKey, cursor PageCursor, limit int, leaves bool) (keys []*KeyMatch, next PageCursor, err error) {
+	return s.store().GetMatchingKeysPage(skPattern, cursor, limit, leaves)
+}
+
+// See TreeStore.GetMatchingKeyValues.
+func (s *Snapshot) GetMatchingKeyValues(skPattern StoreKey, startAt, limit int) (values []*KeyValueMatch) {
+	return s.store().GetMatchingKeyValues(skPattern, startAt, limit)
+}
+
+// See TreeStore.GetKeyAsJson.
+func (s *Snapshot) GetKeyAsJson(sk StoreKey, opts JsonOptions) (jsonData []byte, err error) {
+	return s.store().GetKeyAsJson(sk, opts)
+}
+
+// See TreeStore.GetMatchingKeyValuesAtTime.
+func (s *Snapshot) GetMatchingKeyValuesAtTime(skPattern StoreKey, tickNs int64, startAt, limit int) (values []*KeyValueMatch) {
+	return s.store().GetMatchingKeyValuesAtTime(skPattern, tickNs, startAt, limit)
+}
+
+// See TreeStore.GetKeyAsJsonAtTime.
+func (s *Snapshot) GetKeyAsJsonAtTime(sk StoreKey, tickNs int64, opts JsonOptions) (jsonData []byte, err error) {
+	return s.store().GetKeyAsJsonAtTime(sk, tickNs, opts)
+}
+
+// See TreeStore.Export.
+func (s *Snapshot) Export(sk StoreKey) (jsonData []byte, err error) {
+	return s.store().Export(sk)
+}
+
+// See TreeStore.GetAutoLinkDefinition.
+func (s *Snapshot) GetAutoLinkDefinition(dataParentSk StoreKey) (alds []AutoLinkDefinition) {
+	return s.store().GetAutoLinkDefinition(dataParentSk)
+}
@@ -0,0 +1,171 @@
+package treestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func TestSnapshotFrozen(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("users", "fred"), 1)
+	ts.SetKeyValue(MakeStoreKey("users", "wilma"), 2)
+	ts.SetMetadataAttribute(MakeStoreKey("users"), "kind", "people")
+
+	snap := ts.Snapshot()
+	defer snap.Release()
+
+	ts.SetKeyValue(MakeStoreKey("users", "fred"), 10)
+	ts.DeleteKey(MakeStoreKey("users", "wilma"))
+	ts.SetKeyValue(MakeStoreKey("users", "barney"), 3)
+	ts.SetMetadataAttribute(MakeStoreKey("users"), "kind", "changed")
+
+	if val, _, _ := snap.GetKeyValue(MakeStoreKey("users", "fred")); val != 1 {
+		t.Error("snapshot value")
+	}
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("users", "fred")); val != 10 {
+		t.Error("store value")
+	}
+
+	keys := snap.GetLevelKeys(MakeStoreKey("users"), "*", 0, 100)
+	if len(keys) != 2 || string(keys[0].Segment) != "fred" || string(keys[1].Segment) != "wilma" {
+		t.Error("snapshot level keys")
+	}
+
+	matches := snap.GetMatchingKeyValues(MakeStoreKey("users", "*"), 0, 100)
+	if len(matches) != 2 || matches[1].CurrentValue != 2 {
+		t.Error("snapshot matching values")
+	}
+
+	if _, val := snap.GetMetadataAttribute(MakeStoreKey("users"), "kind"); val != "people" {
+		t.Error("snapshot metadata")
+	}
+
+	jsonData, err := snap.GetKeyAsJson(MakeStoreKey("users"), 0)
+	if err != nil || string(jsonData) != `{"fred":1,"wilma":2}` {
+		t.Errorf("snapshot json %s", string(jsonData))
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSnapshotIndependent(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("cat"), "meow")
+	time.Sleep(time.Millisecond)
+	tick := time.Now().UTC().UnixNano()
+	time.Sleep(time.Millisecond)
+	ts.SetKeyValue(MakeStoreKey("cat"), "purr")
+	ts.SetMetadataAttribute(MakeStoreKey("cat"), "color", "orange")
+	ts.SetKeyValue(MakeStoreKey("animals", "dog"), "bark")
+	target, _ := ts.LocateKey(MakeStoreKey("animals", "dog"))
+	ts.SetKeyValueEx(MakeStoreKey("pointer"), "to dog", 0, 0, []StoreAddress{target})
+	_, _, _, version := ts.GetKeyValueWithVersion(MakeStoreKey("cat"))
+
+	snap := ts.Snapshot()
+	defer snap.Release()
+
+	if ts.freezes != nil {
+		t.Error("freeze not released")
+	}
+
+	ts.SetKeyValue(MakeStoreKey("cat"), "hiss")
+	ts.SetMetadataAttribute(MakeStoreKey("cat"), "color", "black")
+	ts.SetKeyValueEx(MakeStoreKey("pointer"), "to nothing", SetExNoValueUpdate, 0, []StoreAddress{0})
+	ts.DeleteKeyTree(MakeStoreKey("animals"))
+	ts.SetKeyValue(MakeStoreKey("bird"), "tweet")
+
+	val, _, _, snapVersion := snap.GetKeyValueWithVersion(MakeStoreKey("cat"))
+	if val != "purr" || snapVersion != version {
+		t.Errorf("snapshot value %v", val)
+	}
+
+	val, _ = snap.GetKeyValueAtTime(MakeStoreKey("cat"), tick)
+	if val != "meow" {
+		t.Errorf("snapshot history %v", val)
+	}
+
+	_, color := snap.GetMetadataAttribute(MakeStoreKey("cat"), "color")
+	if color != "orange" {
+		t.Error("snapshot metadata")
+	}
+
+	hasLink, rv := snap.GetRelationshipValue(MakeStoreKey("pointer"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/animals/dog" {
+		t.Error("snapshot relationship")
+	}
+
+	_, exists := snap.LocateKey(MakeStoreKey("bird"))
+	if exists {
+		t.Error("snapshot has new key")
+	}
+
+	if snap.store().nextAddress.Load() == ts.nextAddress.Load() {
+		t.Error("snapshot next address")
+	}
+
+	if !snap.store().DiagDump() {
+		t.Error("snapshot diag dump")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSnapshotExpiration(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("temp")
+
+	expiration := time.Now().Add(time.Millisecond * 20).UnixNano()
+	ts.SetKeyValueEx(sk, "t", 0, expiration, nil)
+
+	snap := ts.Snapshot()
+	defer snap.Release()
+
+	time.Sleep(time.Millisecond * 30)
+
+	if _, keyExists, _ := ts.GetKeyValue(sk); keyExists {
+		t.Error("store key not expired")
+	}
+	if val, _, _ := snap.GetKeyValue(sk); val != "t" {
+		t.Error("snapshot key expired")
+	}
+	if snap.GetKeyTtl(sk) != expiration {
+		t.Error("snapshot ttl")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestSnapshotRelease(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("key")
+
+	ts.SetKeyValue(sk, 1)
+	snap := ts.Snapshot()
+	snap.Release()
+
+	if _, keyExists, _ := snap.GetKeyValue(sk); keyExists {
+		t.Error("released snapshot has content")
+	}
+	if snap.Tick() == 0 {
+		t.Error("tick")
+	}
+
+	if val, _, _ := ts.GetKeyValue(sk); val != 1 {
+		t.Error("store changed")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
@@ -0,0 +1,79 @@
+package treestore
+
+import (
+	"iter"
+)
+
+// the number of matches collected under the level locks before they are yielded
+const streamBatchSize = 256
+
+// Returns a sequence of the keys matching `skPattern`, with the same matches
+// as GetMatchingKeys, for use with range:
+//
+//	for km, err := range ts.IterateMatchingKeys(skPattern, false) {
+//		...
+//	}
+//
+// Matches are collected in small batches, and no lock is held while the caller
+// processes a match, so the caller may modify the tree store within the loop.
+// Each batch resumes after the last key of the prior one, so the sequence is
+// not a consistent view of the store; iterate a Snapshot for that.
+//
+// The error is always nil for now. It is part of the sequence so that a
+// failure can be reported without changing the signature.
+func (ts *TreeStore) IterateMatchingKeys(skPattern StoreKey, leaves bool) iter.Seq2[*KeyMatch, error] {
+	return func(yield func(*KeyMatch, error) bool) {
+		ts.streamMatchingKeys(skPattern, func(km *KeyMatch, patternEnd bool) bool {
+			return !leaves || !km.HasChildren || patternEnd
+		}, func(km *KeyMatch) bool {
+			return yield(km, nil)
+		})
+	}
+}
+
+// Returns a sequence of the keys matching `skPattern` that have values, with
+// the same matches as GetMatchingKeyValues. See IterateMatchingKeys.
+func (ts *TreeStore) IterateMatchingKeyValues(skPattern StoreKey) iter.Seq2[*KeyValueMatch, error] {
+	return func(yield func(*KeyValueMatch, error) bool) {
+		ts.streamMatchingKeys(skPattern, func(km *KeyMatch, patternEnd bool) bool {
+			return km.HasValue
+		}, func(km *KeyMatch) bool {
+			kvm := &KeyValueMatch{
+				Key:           km.Key,
+				Metadata:      km.Metadata,
+				HasChildren:   km.HasChildren,
+				CurrentValue:  km.CurrentValue,
+				Relationships: km.Relationships,
+			}
+			return yield(kvm, nil)
+		})
+	}
+}
+
+// worker - passes the matches accepted by `include` to `yield` in batches,
+// releasing the level locks between batches
+func (ts *TreeStore) streamMatchingKeys(skPattern StoreKey, include iterateFullCallback, yield func(km *KeyMatch) bool) {
+	var after TokenSet
+	for {
+		batch := make([]*KeyMatch, 0, streamBatchSize)
+		ts.walkMatchingKeys(skPattern, after, func(segments []TokenSegment, kn *keyNode, patternEnd bool) bool {
+			return !ts.iterateFullInvokeCallback(segments, kn, patternEnd, 0, func(km *KeyMatch, patternEnd bool) bool {
+				if include(km, patternEnd) {
+					batch = append(batch, km)
+				}
+				return len(batch) < streamBatchSize
+			})
+		})
+
+		for _, km := range batch {
+			if !yield(km) {
+				return
+			}
+		}
+
+		if len(batch) < streamBatchSize {
+			return
+		}
+		after = TokenPathToTokenSet(batch[len(batch)-1].Key)
+	}
+}
@@ -0,0 +1,96 @@
+package treestore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func TestIterateMatchingKeys(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for i := 0; i < 40; i++ {
+		for j := 0; j < 20; j++ {
+			sk := MakeStoreKey("data", fmt.Sprintf("%02d", i), fmt.Sprintf("%02d", j))
+			if j%2 == 0 {
+				ts.SetKeyValue(sk, i*100+j)
+			} else {
+				ts.SetKey(sk)
+			}
+		}
+	}
+
+	for _, leaves := range []bool{false, true} {
+		all := ts.GetMatchingKeys(MakeStoreKey("data", "**"), 0, 10000, leaves)
+
+		n := 0
+		for km, err := range ts.IterateMatchingKeys(MakeStoreKey("data", "**"), leaves) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n >= len(all) || km.Key != all[n].Key {
+				t.Fatalf("leaves=%v: key %s at %d", leaves, km.Key, n)
+			}
+			n++
+		}
+		if n != len(all) {
+			t.Errorf("leaves=%v: %d streamed of %d", leaves, n, len(all))
+		}
+	}
+
+	values := ts.GetMatchingKeyValues(MakeStoreKey("data", "*", "*"), 0, 10000)
+	n := 0
+	for kvm, err := range ts.IterateMatchingKeyValues(MakeStoreKey("data", "*", "*")) {
+		if err != nil || kvm.Key != values[n].Key || kvm.CurrentValue != values[n].CurrentValue {
+			t.Fatalf("value %s at %d", kvm.Key, n)
+		}
+		n++
+	}
+	if n != 400 || len(values) != 400 {
+		t.Errorf("%d values streamed of %d", n, len(values))
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestIterateMatchingKeysBreak(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	for i := 0; i < 1000; i++ {
+		ts.SetKeyValue(MakeStoreKey("k", fmt.Sprintf("%04d", i)), i)
+	}
+
+	// the loop can change the store, since no lock is held across a yield
+	n := 0
+	for km := range ts.IterateMatchingKeyValues(MakeStoreKey("k", "*")) {
+		ts.SetKeyValue(MakeStoreKeyFromPath(km.Key), km.CurrentValue.(int)+1)
+		ts.DeleteKey(MakeStoreKeyFromPath(km.Key))
+		n++
+		if n == 600 {
+			break
+		}
+	}
+
+	if count, _ := ts.CountLevelKeys(MakeStoreKey("k")); count != 400 {
+		t.Errorf("remaining %d", count)
+	}
+
+	sentinel := 0
+	for km := range ts.IterateMatchingKeys(MakeStoreKey(), false) {
+		if km.Key != "" {
+			t.Error("sentinel key")
+		}
+		sentinel++
+	}
+	if sentinel != 1 {
+		t.Error("sentinel count")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
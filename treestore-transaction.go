@@ -0,0 +1,363 @@
+package treestore
+
+import (
+	"errors"
+	"fmt"
+)
+
+type (
+	// A set of changes that Commit applies together, under one acquisition
+	// of the exclusive lock. Create a transaction with NewTransaction.
+	Transaction struct {
+		ts         *TreeStore
+		conditions []txCondition
+		ops        []txOp
+	}
+
+	txCondition struct {
+		sk      StoreKey
+		version uint64
+	}
+
+	txOpKind int
+
+	txOp struct {
+		kind      txOpKind
+		sk        StoreKey
+		destSk    StoreKey
+		value     any
+		overwrite bool
+		attribute string
+		attrValue string
+	}
+
+	txEffectKind int
+
+	// A change in key existence made by a transaction operation
+	txEffect struct {
+		kind   txEffectKind
+		tokens TokenSet
+		src    TokenSet // txMovedTo: the source of the keys now at tokens
+		before int      // txMovedTo: the number of effects before the move
+	}
+
+	// Tracks key existence as the operations of a transaction change it, so
+	// that an operation that can't be performed is found before any change
+	// is made.
+	txView struct {
+		ts      *TreeStore
+		effects []txEffect
+	}
+)
+
+const (
+	txSetKeyValue txOpKind = iota
+	txDeleteKey
+	txDeleteKeyTree
+	txMoveKey
+	txSetMetadataAttribute
+	txClearMetadataAttribute
+)
+
+const (
+	txCreated     txEffectKind = iota // the key and its parents exist
+	txRemovedTree                     // the key and its children do not exist
+	txDeletedKey                      // the key exists only if it has children
+	txMovedTo                         // the key exists, with the children of src
+)
+
+var ErrTransactionConflict = errors.New("transaction precondition failed")
+
+// Starts a transaction. Operations are recorded and take effect when Commit
+// is called.
+func (ts *TreeStore) NewTransaction() *Transaction {
+	return &Transaction{ts: ts}
+}
+
+// Adds a precondition that the key version is `version` when the transaction
+// commits. See GetKeyValueWithVersion and SetKeyValueCas.
+func (tx *Transaction) RequireVersion(sk StoreKey, version uint64) *Transaction {
+	tx.conditions = append(tx.conditions, txCondition{sk: sk, version: version})
+	return tx
+}
+
+// Adds a SetKeyValue operation.
+func (tx *Transaction) SetKeyValue(sk StoreKey, value any) *Transaction {
+	tx.ops = append(tx.ops, txOp{kind: txSetKeyValue, sk: sk, value: value})
+	return tx
+}
+
+// Adds a DeleteKey operation. A key that does not exist is ignored.
+func (tx *Transaction) DeleteKey(sk StoreKey) *Transaction {
+	tx.ops = append(tx.ops, txOp{kind: txDeleteKey, sk: sk})
+	return tx
+}
+
+// Adds a DeleteKeyTree operation. A key that does not exist is ignored.
+func (tx *Transaction) DeleteKeyTree(sk StoreKey) *Transaction {
+	tx.ops = append(tx.ops, txOp{kind: txDeleteKeyTree, sk: sk})
+	return tx
+}
+
+// Adds a MoveKey operation. The transaction fails if the source key does not
+// exist, or if the destination key exists and `overwrite` is false.
+func (tx *Transaction) MoveKey(srcSk, destSk StoreKey, overwrite bool) *Transaction {
+	tx.ops = append(tx.ops, txOp{kind: txMoveKey, sk: srcSk, destSk: destSk, overwrite: overwrite})
+	return tx
+}
+
+// Adds a SetMetadataAttribute operation. The transaction fails if the key
+// does not exist.
+func (tx *Transaction) SetMetadataAttribute(sk StoreKey, attribute, value string) *Transaction {
+	tx.ops = append(tx.ops, txOp{kind: txSetMetadataAttribute, sk: sk, attribute: attribute, attrValue: value})
+	return tx
+}
+
+// Adds a ClearMetadataAttribute operation. The transaction fails if the key
+// does not exist.
+func (tx *Transaction) ClearMetadataAttribute(sk StoreKey, attribute string) *Transaction {
+	tx.ops = append(tx.ops, txOp{kind: txClearMetadataAttribute, sk: sk, attribute: attribute})
+	return tx
+}
+
+// Applies the operations in order, atomically. Other operations on the tree
+// store wait until the commit completes.
+//
+// The preconditions, and whether each operation can be performed after the
+// ones before it, are checked before any change is made. If a check fails,
+// the tree store is not changed and an error is returned; a failed version
+// precondition returns an error that wraps ErrTransactionConflict.
+//
+// If an operation fails to apply despite the checks, the operations already
+// applied are rolled back and an error is returned. Watchers and key event
+// subscribers may have been notified of the rolled back changes.
+//
+// The write-ahead log records the transaction as a single entry.
+func (tx *Transaction) Commit() (err error) {
+	ts := tx.ts
+	ts.acquireExclusiveLock()
+	defer ts.releaseExclusiveLock()
+
+	for _, cond := range tx.conditions {
+		var version uint64
+		_, index, kn, expired := ts.locateKeyNodeForLock(cond.sk)
+		if index >= len(cond.sk.Tokens) && !expired {
+			version = ts.cas[kn.address]
+		}
+		if version != cond.version {
+			return fmt.Errorf("%w: key %s is version %d", ErrTransactionConflict, cond.sk.Path, version)
+		}
+	}
+
+	if err = tx.validateLocked(); err != nil {
+		return
+	}
+
+	err = tx.applyAllLocked()
+	return
+}
+
+// worker - applies the operations, rolling back the ones applied if one of
+// them fails; the caller must hold the exclusive lock
+func (tx *Transaction) applyAllLocked() (err error) {
+	ts := tx.ts
+
+	// the content is frozen so that a partial commit can be undone
+	f := ts.freezeLocked()
+	defer ts.thawLocked(f)
+
+	err = ts.logAsTransaction(func() error {
+		for i, op := range tx.ops {
+			if err := tx.applyLocked(op); err != nil {
+				return fmt.Errorf("transaction operation %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ts.l.Errorf("treestore: transaction rolled back: %s", err.Error())
+		ts.rollbackLocked(f)
+	}
+	return
+}
+
+// worker - logs the mutations made by `fn` as a single write-ahead log
+// record, or discards them if `fn` fails; the caller must hold the exclusive
+// lock
+func (ts *TreeStore) logAsTransaction(fn func() error) (err error) {
+	records := []walRecord{}
+	ts.txRecords = &records
+	err = fn()
+	ts.txRecords = nil
+
+	if err == nil && len(records) > 0 {
+		ts.logMutation(&walRecord{Op: walTransaction, Records: records})
+	}
+	return
+}
+
+// worker - checks that each operation can be performed; the caller must hold
+// the exclusive lock
+func (tx *Transaction) validateLocked() error {
+	view := txView{ts: tx.ts}
+
+	for i, op := range tx.ops {
+		tokens := op.sk.Tokens
+
+		switch op.kind {
+		case txSetKeyValue:
+			view.add(txEffect{kind: txCreated, tokens: tokens})
+
+		case txDeleteKey:
+			if len(tokens) > 0 {
+				view.add(txEffect{kind: txDeletedKey, tokens: tokens})
+			}
+
+		case txDeleteKeyTree:
+			if len(tokens) > 0 {
+				view.add(txEffect{kind: txRemovedTree, tokens: tokens})
+			}
+
+		case txMoveKey:
+			if len(tokens) == 0 || len(op.destSk.Tokens) == 0 {
+				return fmt.Errorf("transaction operation %d: the sentinel key can't be moved", i)
+			}
+			if !view.exists(tokens, len(view.effects)) {
+				return fmt.Errorf("transaction operation %d: move source %s does not exist", i, op.sk.Path)
+			}
+			if !op.overwrite && view.exists(op.destSk.Tokens, len(view.effects)) {
+				return fmt.Errorf("transaction operation %d: move destination %s exists", i, op.destSk.Path)
+			}
+
+			before := len(view.effects)
+			view.add(txEffect{kind: txRemovedTree, tokens: tokens})
+			view.add(txEffect{kind: txMovedTo, tokens: op.destSk.Tokens, src: tokens, before: before})
+
+		case txSetMetadataAttribute, txClearMetadataAttribute:
+			if !view.exists(tokens, len(view.effects)) {
+				return fmt.Errorf("transaction operation %d: key %s does not exist", i, op.sk.Path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// worker - performs an operation as its public API does, without locking
+func (tx *Transaction) applyLocked(op txOp) (err error) {
+	ts := tx.ts
+
+	switch op.kind {
+	case txSetKeyValue:
+		kn, _, oldValue := ts.setKeyValueLocked(op.sk, op.value)
+		ts.logMutation(&walRecord{Op: walSetKeyValue, Sk: op.sk.Tokens, Value: op.value})
+		ts.watchSetLocked(op.sk, kn, oldValue)
+
+	case txDeleteKey:
+		ts.deleteKeyLogged(op.sk)
+
+	case txDeleteKeyTree:
+		ts.deleteKeyTreeLogged(op.sk)
+
+	case txMoveKey:
+		if _, moved := ts.moveReferencedKeyLogged(op.sk, op.destSk, op.overwrite, -1, []StoreKey{}, []StoreKey{}); !moved {
+			// validation should have prevented this
+			err = fmt.Errorf("move of %s to %s failed", op.sk.Path, op.destSk.Path)
+		}
+
+	case txSetMetadataAttribute:
+		ts.setMetadataAttributeLogged(op.sk, op.attribute, op.attrValue)
+
+	case txClearMetadataAttribute:
+		ts.clearMetadataAttributeLogged(op.sk, op.attribute)
+	}
+	return
+}
+
+func (view *txView) add(effect txEffect) {
+	view.effects = append(view.effects, effect)
+}
+
+// worker - tests if the key exists after the first `end` effects
+func (view *txView) exists(tokens TokenSet, end int) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+
+	for i := end - 1; i >= 0; i-- {
+		effect := view.effects[i]
+
+		switch effect.kind {
+		case txCreated:
+			if isTokenSetPrefix(tokens, effect.tokens) {
+				// the key, or one of its children, was created
+				return true
+			}
+
+		case txRemovedTree:
+			if isTokenSetPrefix(effect.tokens, tokens) {
+				return false
+			}
+
+		case txDeletedKey:
+			if len(tokens) == len(effect.tokens) && isTokenSetPrefix(effect.tokens, tokens) {
+				return view.hasChild(tokens, i)
+			}
+
+		case txMovedTo:
+			if isTokenSetPrefix(tokens, effect.tokens) {
+				return true
+			}
+			if isTokenSetPrefix(effect.tokens, tokens) {
+				srcTokens := append(append(TokenSet{}, effect.src...), tokens[len(effect.tokens):]...)
+				return view.exists(srcTokens, effect.before)
+			}
+		}
+	}
+
+	_, index, _, expired := view.ts.locateKeyNodeForLock(MakeStoreKeyFromTokenSegments(tokens...))
+	return index >= len(tokens) && !expired
+}
+
+// worker - tests if the key has a child after the first `end` effects
+func (view *txView) hasChild(tokens TokenSet, end int) bool {
+	for _, child := range view.childCandidates(tokens, end) {
+		if view.exists(child, end) {
+			return true
+		}
+	}
+	return false
+}
+
+// worker - returns the keys that might be children of `tokens` after the
+// first `end` effects
+func (view *txView) childCandidates(tokens TokenSet, end int) (children []TokenSet) {
+	sk := MakeStoreKeyFromTokenSegments(tokens...)
+	_, index, kn, _ := view.ts.locateKeyNodeForLock(sk)
+	if index >= len(tokens) && kn.nextLevel != nil {
+		kn.nextLevel.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+			children = append(children, AppendStoreKeySegments(sk, node.key).Tokens)
+			return true
+		})
+	}
+
+	for i := 0; i < end; i++ {
+		effect := view.effects[i]
+		if effect.kind != txCreated && effect.kind != txMovedTo {
+			continue
+		}
+
+		if len(effect.tokens) > len(tokens) && isTokenSetPrefix(tokens, effect.tokens) {
+			children = append(children, effect.tokens[:len(tokens)+1])
+		}
+
+		if effect.kind == txMovedTo && isTokenSetPrefix(effect.tokens, tokens) {
+			// children arrived with the move
+			srcTokens := append(append(TokenSet{}, effect.src...), tokens[len(effect.tokens):]...)
+			for _, srcChild := range view.childCandidates(srcTokens, effect.before) {
+				children = append(children, append(append(TokenSet{}, tokens...), srcChild[len(srcTokens)]))
+			}
+		}
+	}
+	return
+}
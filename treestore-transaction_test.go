@@ -0,0 +1,338 @@
+package treestore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func txKeyExists(ts *TreeStore, sk StoreKey) bool {
+	_, exists := ts.LocateKey(sk)
+	return exists
+}
+
+func TestTransactionCommit(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	ts.SetKeyValue(MakeStoreKey("b"), 2)
+	ts.SetKeyValue(MakeStoreKey("c", "d"), 3)
+
+	err := ts.NewTransaction().
+		SetKeyValue(MakeStoreKey("a"), 10).
+		DeleteKey(MakeStoreKey("b")).
+		MoveKey(MakeStoreKey("c"), MakeStoreKey("e"), false).
+		SetMetadataAttribute(MakeStoreKey("e", "d"), "tag", "moved").
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("a")); val != 10 {
+		t.Error("set")
+	}
+	if txKeyExists(ts, MakeStoreKey("b")) {
+		t.Error("delete")
+	}
+	if txKeyExists(ts, MakeStoreKey("c")) {
+		t.Error("move source")
+	}
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("e", "d")); val != 3 {
+		t.Error("move destination")
+	}
+	if _, val := ts.GetMetadataAttribute(MakeStoreKey("e", "d"), "tag"); val != "moved" {
+		t.Error("metadata")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestTransactionConflict(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("balance")
+
+	ts.SetKeyValue(sk, 100)
+	_, _, _, version := ts.GetKeyValueWithVersion(sk)
+
+	// another writer changes the key
+	ts.SetKeyValue(sk, 50)
+
+	err := ts.NewTransaction().
+		RequireVersion(sk, version).
+		SetKeyValue(sk, 90).
+		SetKeyValue(MakeStoreKey("ledger", "1"), -10).
+		Commit()
+	if !errors.Is(err, ErrTransactionConflict) {
+		t.Fatal("expected conflict")
+	}
+
+	if val, _, _ := ts.GetKeyValue(sk); val != 50 {
+		t.Error("value changed")
+	}
+	if txKeyExists(ts, MakeStoreKey("ledger")) {
+		t.Error("ledger created")
+	}
+
+	// a missing key has version 0
+	_, _, _, version = ts.GetKeyValueWithVersion(sk)
+	err = ts.NewTransaction().
+		RequireVersion(sk, version).
+		RequireVersion(MakeStoreKey("ledger", "1"), 0).
+		SetKeyValue(sk, 40).
+		SetKeyValue(MakeStoreKey("ledger", "1"), -10).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _, _ := ts.GetKeyValue(sk); val != 40 {
+		t.Error("value not changed")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestTransactionValidation(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("src"), 1)
+	ts.SetKeyValue(MakeStoreKey("dest"), 2)
+
+	tests := []struct {
+		name string
+		tx   *Transaction
+	}{
+		{"dest exists", ts.NewTransaction().
+			SetKeyValue(MakeStoreKey("new"), 3).
+			MoveKey(MakeStoreKey("src"), MakeStoreKey("dest"), false)},
+		{"src deleted", ts.NewTransaction().
+			SetKeyValue(MakeStoreKey("new"), 3).
+			DeleteKeyTree(MakeStoreKey("src")).
+			MoveKey(MakeStoreKey("src"), MakeStoreKey("other"), false)},
+		{"src moved", ts.NewTransaction().
+			MoveKey(MakeStoreKey("src"), MakeStoreKey("other"), false).
+			MoveKey(MakeStoreKey("src"), MakeStoreKey("new"), false)},
+		{"metadata of missing key", ts.NewTransaction().
+			SetKeyValue(MakeStoreKey("new"), 3).
+			SetMetadataAttribute(MakeStoreKey("missing"), "a", "b")},
+		{"sentinel move", ts.NewTransaction().
+			MoveKey(MakeStoreKey(), MakeStoreKey("new"), false)},
+	}
+
+	for _, test := range tests {
+		if err := test.tx.Commit(); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+
+		if txKeyExists(ts, MakeStoreKey("new")) || txKeyExists(ts, MakeStoreKey("other")) {
+			t.Errorf("%s: store changed", test.name)
+		}
+		if val, _, _ := ts.GetKeyValue(MakeStoreKey("src")); val != 1 {
+			t.Errorf("%s: source changed", test.name)
+		}
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestTransactionStagedMove(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("live", "old"), 1)
+
+	// a move of a key created earlier in the transaction, replacing a
+	// key that exists only because of its children
+	err := ts.NewTransaction().
+		SetKeyValue(MakeStoreKey("staging", "item", "name"), "fred").
+		DeleteKey(MakeStoreKey("live")).
+		MoveKey(MakeStoreKey("staging", "item"), MakeStoreKey("live"), true).
+		DeleteKey(MakeStoreKey("staging")).
+		SetMetadataAttribute(MakeStoreKey("live", "name"), "source", "staging").
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("live", "name")); val != "fred" {
+		t.Error("moved value")
+	}
+	if txKeyExists(ts, MakeStoreKey("live", "old")) || txKeyExists(ts, MakeStoreKey("staging")) {
+		t.Error("leftover keys")
+	}
+
+	// the deleted key must not exist when its children were moved away
+	err = ts.NewTransaction().
+		SetKeyValue(MakeStoreKey("x", "y"), 1).
+		MoveKey(MakeStoreKey("x", "y"), MakeStoreKey("z"), false).
+		DeleteKey(MakeStoreKey("x")).
+		SetMetadataAttribute(MakeStoreKey("x"), "a", "b").
+		Commit()
+	if err == nil {
+		t.Error("expected error")
+	}
+	if txKeyExists(ts, MakeStoreKey("z")) {
+		t.Error("store changed")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestTransactionWalReplay(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	err := ts.NewTransaction().
+		SetKeyValue(MakeStoreKey("a"), 2).
+		SetKeyValue(MakeStoreKey("b", "c"), 3).
+		MoveKey(MakeStoreKey("b"), MakeStoreKey("d"), false).
+		SetMetadataAttribute(MakeStoreKey("a"), "x", "y").
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := ts.SetKeyValue(MakeStoreKey("e"), 4)
+	ts.Close()
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	defer ts2.Close()
+
+	if val, _, _ := ts2.GetKeyValue(MakeStoreKey("a")); val != 2 {
+		t.Error("replayed set")
+	}
+	if val, _, _ := ts2.GetKeyValue(MakeStoreKey("d", "c")); val != 3 {
+		t.Error("replayed move")
+	}
+	if _, val := ts2.GetMetadataAttribute(MakeStoreKey("a"), "x"); val != "y" {
+		t.Error("replayed metadata")
+	}
+	if addr2, _ := ts2.LocateKey(MakeStoreKey("e")); addr2 != addr {
+		t.Error("replayed address")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	ts.SetMetadataAttribute(MakeStoreKey("a"), "x", "y")
+	ts.SetKeyValue(MakeStoreKey("b", "c"), 2)
+	ts.SetKeyValue(MakeStoreKey("b", "d"), 3)
+	ts.SetKeyValue(MakeStoreKey("e", "f"), 4)
+	_, _, _, version := ts.GetKeyValueWithVersion(MakeStoreKey("a"))
+	addr, _ := ts.LocateKey(MakeStoreKey("b", "c"))
+
+	// validation is skipped, so that the last operation fails after the
+	// others are applied
+	tx := ts.NewTransaction().
+		SetKeyValue(MakeStoreKey("a"), 10).
+		ClearMetadataAttribute(MakeStoreKey("a"), "x").
+		SetKeyValue(MakeStoreKey("b", "g"), 5).
+		DeleteKeyTree(MakeStoreKey("b")).
+		MoveKey(MakeStoreKey("e"), MakeStoreKey("h"), false).
+		SetKeyValue(MakeStoreKey("i"), 6).
+		MoveKey(MakeStoreKey("missing"), MakeStoreKey("j"), false)
+
+	// a save in progress is unaffected
+	f := ts.freeze()
+
+	ts.acquireExclusiveLock()
+	err := tx.applyAllLocked()
+	ts.releaseExclusiveLock()
+	if err == nil {
+		t.Fatal("partial commit succeeded")
+	}
+
+	var saved []string
+	err = (&storeWalker{ts: ts, f: f}).walk(func(dkn *diskKeyNode) error {
+		saved = append(saved, string(dkn.Key))
+		return nil
+	})
+	ts.thaw(f)
+	if err != nil || strings.Join(saved, ",") != "a,b,c,d,e,f" {
+		t.Errorf("saved keys %v", saved)
+	}
+
+	if ts.freezes != nil {
+		t.Error("freeze not released")
+	}
+
+	if val, _, _, v := ts.GetKeyValueWithVersion(MakeStoreKey("a")); val != 1 || v != version {
+		t.Error("value not restored")
+	}
+	if _, val := ts.GetMetadataAttribute(MakeStoreKey("a"), "x"); val != "y" {
+		t.Error("metadata not restored")
+	}
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("b", "d")); val != 3 {
+		t.Error("tree not restored")
+	}
+	if addr2, _ := ts.LocateKey(MakeStoreKey("b", "c")); addr2 != addr {
+		t.Error("address not restored")
+	}
+	if val, _, _ := ts.GetKeyValue(MakeStoreKey("e", "f")); val != 4 {
+		t.Error("move not restored")
+	}
+	for _, sk := range []StoreKey{MakeStoreKey("b", "g"), MakeStoreKey("h"), MakeStoreKey("i")} {
+		if txKeyExists(ts, sk) {
+			t.Errorf("key %s not removed", sk.Path)
+		}
+	}
+
+	if !ts.DiagDump() {
+		t.Error("rollback diag dump")
+	}
+
+	// the rolled back operations are not logged
+	ts.SetKeyValue(MakeStoreKey("k"), 7)
+	ts.Close()
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	defer ts2.Close()
+
+	if val, _, _ := ts2.GetKeyValue(MakeStoreKey("a")); val != 1 {
+		t.Error("replayed rolled back set")
+	}
+	if txKeyExists(ts2, MakeStoreKey("i")) {
+		t.Error("replayed rolled back key")
+	}
+	if val, _, _ := ts2.GetKeyValue(MakeStoreKey("k")); val != 7 {
+		t.Error("replayed later key")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
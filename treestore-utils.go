@@ -226,10 +226,10 @@ func SplitStoreKey(sk StoreKey) []string {
 
 // Appends a token segment to a StoreKey
 func AppendStoreKeySegments(sk StoreKey, segments ...TokenSegment) StoreKey {
-	sk2 := StoreKey{Tokens: sk.Tokens}
-	for _, seg := range segments {
-		sk2.Tokens = append(sk2.Tokens, seg)
-	}
+	// a new token array, so that keys appended to the same parent don't share one
+	sk2 := StoreKey{Tokens: make(TokenSet, 0, len(sk.Tokens)+len(segments))}
+	sk2.Tokens = append(sk2.Tokens, sk.Tokens...)
+	sk2.Tokens = append(sk2.Tokens, segments...)
 	sk2.Path = TokenSetToTokenPath(sk2.Tokens)
 
 	return sk2
@@ -265,16 +265,6 @@ func storeKeyHasBase(baseSk, candidateSk StoreKey) bool {
 	return true
 }
 
-// Returns the Unix ns tick as a byte array
-func currentUnixTimestampBytes() []byte {
-	now := time.Now().UTC().UnixNano()
-
-	b := make([]byte, 8)
-	binary.BigEndian.PutUint64(b, uint64(now))
-
-	return b
-}
-
 // Makes the byte array equivalent of the Unix ns tick
 func unixTimestampBytes(tick int64) []byte {
 	b := make([]byte, 8)
@@ -312,55 +302,3 @@ func cleanString(str string, maxChars int) string {
 
 	return clean
 }
-
-func isPattern(pattern, candidate string) bool {
-	return isPatternRunes([]rune(pattern), []rune(candidate))
-}
-
-func isPatternRunes(pattern, candidate []rune) bool {
-	cpos := 0
-	ppos := 0
-
-	for {
-		if ppos+2 <= len(pattern) && pattern[ppos] == '*' && pattern[ppos+1] == '*' {
-			ppos++
-		} else {
-			break
-		}
-	}
-
-	for {
-		if ppos >= len(pattern) {
-			break
-		}
-		if cpos >= len(candidate) {
-			break
-		}
-
-		if pattern[ppos] == '*' {
-			if ppos+1 >= len(pattern) {
-				return true
-			}
-			for {
-				if isPatternRunes(pattern[ppos+1:], candidate[cpos:]) {
-					return true
-				}
-				cpos++
-				if cpos >= len(candidate) {
-					return false
-				}
-			}
-		} else if pattern[ppos] != candidate[cpos] {
-			return false
-		}
-
-		ppos++
-		cpos++
-	}
-
-	if ppos == len(pattern)-1 && pattern[ppos] == '*' {
-		return true
-	}
-
-	return (ppos == len(pattern) && cpos == len(candidate))
-}
@@ -248,51 +248,51 @@ func TestTokenSetWrappers(t *testing.T) {
 func TestIsPattern(t *testing.T) {
 	sample := "cat dog fox mouse cow"
 
-	if isPattern("", sample) {
+	if segmentMatcher("")([]byte(sample)) {
 		t.Error("empty string matches nothing")
 	}
 
-	if isPattern("cat", sample) {
+	if segmentMatcher("cat")([]byte(sample)) {
 		t.Error("partial match is not a match")
 	}
 
-	if !isPattern("*", sample) {
+	if !segmentMatcher("*")([]byte(sample)) {
 		t.Error("wildcard matches all")
 	}
 
-	if !isPattern("**", sample) {
+	if !segmentMatcher("**")([]byte(sample)) {
 		t.Error("extra wildcards match")
 	}
 
-	if !isPattern("cat*", sample) {
+	if !segmentMatcher("cat*")([]byte(sample)) {
 		t.Error("wildcard prefix matches")
 	}
 
-	if !isPattern("*cat*", sample) {
+	if !segmentMatcher("*cat*")([]byte(sample)) {
 		t.Error("wildcard can match nothing")
 	}
 
-	if !isPattern("*cat dog fox mouse cow*", sample) {
+	if !segmentMatcher("*cat dog fox mouse cow*")([]byte(sample)) {
 		t.Error("wildcard can match nothing 2")
 	}
 
-	if !isPattern("cat*cow", sample) {
+	if !segmentMatcher("cat*cow")([]byte(sample)) {
 		t.Error("mid match")
 	}
 
-	if !isPattern("cat*ox*cow", sample) {
+	if !segmentMatcher("cat*ox*cow")([]byte(sample)) {
 		t.Error("mid match recursive with multiple matches")
 	}
 
-	if isPattern("cat*o*z*cow", sample) {
+	if segmentMatcher("cat*o*z*cow")([]byte(sample)) {
 		t.Error("mid match recursive with multiple mismatches")
 	}
 
-	if !isPattern("cat dog fox mouse cow", sample) {
+	if !segmentMatcher("cat dog fox mouse cow")([]byte(sample)) {
 		t.Error("exact match")
 	}
 
-	if isPattern("cat dog fox mouse cows", sample) {
+	if segmentMatcher("cat dog fox mouse cows")([]byte(sample)) {
 		t.Error("exact match plus extra is mismatch")
 	}
 }
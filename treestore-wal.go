@@ -0,0 +1,530 @@
+package treestore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+type (
+	walOp int
+
+	// A single mutation recorded in the write-ahead log. Store keys are kept
+	// as token sets so that binary segments survive the round trip.
+	walRecord struct {
+		Sequence         uint64
+		Op               walOp
+		Timestamp        int64
+		NextAddress      uint64
+		Sk               TokenSet
+		Sk2              TokenSet
+		Value            any
+		Flags            int
+		Expiration       int64
+		HasRelationships bool
+		Relationships    []StoreAddress
+		Attribute        string
+		AttributeValue   string
+		Option           bool
+		Refs             []TokenSet
+		Unrefs           []TokenSet
+		Fields           []EscapedSubPath
+		JsonData         []byte
+		Nodes            []diskKeyNode
+		Records          []walRecord
+		Retention        HistoryRetention
+	}
+
+	writeAheadLog struct {
+		mu         sync.Mutex
+		fileName   string
+		fh         afero.File
+		syncWrites bool
+	}
+)
+
+const (
+	walSetKey walOp = iota + 1
+	walSetKeyValue
+	walSetKeyValueEx
+	walSetKeyTtl
+	walSetKeyValueTtl
+	walDeleteKeyWithValue
+	walDeleteKey
+	walDeleteKeyTree
+	walSetMetadataAttribute
+	walClearMetadataAttribute
+	walClearKeyMetadata
+	walMoveReferencedKey
+	walDefineAutoLinkKey
+	walRemoveAutoLinkKey
+	walSetKeyJson
+	walStageKeyJson
+	walReplaceKeyJson
+	walCreateKeyJson
+	walMergeKeyJson
+	walImport
+	walPurge
+	walLoadSubtree
+	walTransaction
+	walSetHistoryRetention
+)
+
+// each record is framed with its length and a checksum, so that a torn write
+// at the tail of the log can be detected
+const walFrameHeaderSize = 8
+
+var errWalTornRecord = errors.New("torn write-ahead log record")
+
+// Enables the write-ahead log. Every mutation made through the public API is
+// appended to `fileName`, allowing changes made since the last Save to be
+// recovered after a crash.
+//
+// If the log file already exists, records that are newer than the currently
+// loaded snapshot are replayed before new mutations are recorded. Call Load
+// first (if there is a snapshot), then enable the log, before the store is
+// put to use.
+//
+// Save and SaveOnline trim the log once the snapshot is written. Load replays the log on
+// top of the loaded snapshot when the log is enabled.
+//
+// Specify `syncWrites` to fsync the log after each record. Otherwise the
+// records are written through to the operating system, which survives a
+// process crash but not necessarily a power loss.
+func (ts *TreeStore) EnableWriteAheadLog(l lane.Lane, fileName string, syncWrites bool) (err error) {
+	if ts.wal.Load() != nil {
+		err = errors.New("write-ahead log is already enabled")
+		l.Errorf("failed to enable write-ahead log %s: %s", fileName, err.Error())
+		return
+	}
+
+	var fh afero.File
+	if fh, err = ts.fileSystem().OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		l.Errorf("failed to open write-ahead log %s: %s", fileName, err.Error())
+		return
+	}
+
+	wal := &writeAheadLog{
+		fileName:   fileName,
+		fh:         fh,
+		syncWrites: syncWrites,
+	}
+
+	if err = ts.replayWriteAheadLogFile(l, wal); err != nil {
+		fh.Close()
+		return
+	}
+
+	if !ts.wal.CompareAndSwap(nil, wal) {
+		fh.Close()
+		err = errors.New("write-ahead log is already enabled")
+		l.Errorf("failed to enable write-ahead log %s: %s", fileName, err.Error())
+	}
+	return
+}
+
+// Stops recording mutations and closes the write-ahead log file. The log
+// content is left in place.
+func (ts *TreeStore) DisableWriteAheadLog(l lane.Lane) (err error) {
+	wal := ts.wal.Swap(nil)
+	if wal == nil {
+		return
+	}
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if err = wal.fh.Close(); err != nil {
+		l.Errorf("failed to close write-ahead log %s: %s", wal.fileName, err.Error())
+	}
+	wal.fh = nil
+	return
+}
+
+// worker - appends a mutation to the write-ahead log, if enabled, and counts it
+// toward autosave. The caller must hold the locks of the mutation, so that the
+// log order matches the order the changes were applied.
+func (ts *TreeStore) logMutation(rec *walRecord) {
+	if ts.walReplaying.Load() {
+		return
+	}
+
+	if ts.txRecords != nil {
+		// logged together when the transaction completes
+		rec.Timestamp = ts.currentTick()
+		rec.NextAddress = ts.nextAddress.Load()
+		*ts.txRecords = append(*ts.txRecords, *rec)
+		return
+	}
+
+	ts.countMutation()
+
+	wal := ts.wal.Load()
+	if wal == nil {
+		return
+	}
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.fh == nil {
+		// disabled concurrently
+		return
+	}
+
+	rec.Sequence = ts.walSequence.Add(1)
+	rec.Timestamp = ts.currentTick()
+	rec.NextAddress = ts.nextAddress.Load()
+
+	if err := wal.writeRecord(rec); err != nil {
+		ts.l.Errorf("failed to write mutation %d to write-ahead log %s: %s", rec.Sequence, wal.fileName, err.Error())
+	}
+}
+
+// worker - serializes and appends one framed record
+func (wal *writeAheadLog) writeRecord(rec *walRecord) (err error) {
+	if err = writeWalFrame(wal.fh, rec); err != nil {
+		return
+	}
+
+	if wal.syncWrites {
+		err = wal.fh.Sync()
+	}
+	return
+}
+
+// worker - serializes a record with its length and checksum
+func writeWalFrame(w io.Writer, rec *walRecord) (err error) {
+	var payload bytes.Buffer
+	if err = gob.NewEncoder(&payload).Encode(rec); err != nil {
+		return
+	}
+
+	frame := make([]byte, walFrameHeaderSize, walFrameHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(frame[0:4], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload.Bytes()))
+	frame = append(frame, payload.Bytes()...)
+
+	_, err = w.Write(frame)
+	return
+}
+
+// worker - reads the next framed record; returns io.EOF at the clean end of
+// the log, or errWalTornRecord if the tail is incomplete or corrupt
+func readWalRecord(r io.Reader) (rec *walRecord, size int64, err error) {
+	header := make([]byte, walFrameHeaderSize)
+	var n int
+	if n, err = io.ReadFull(r, header); err != nil {
+		if n > 0 {
+			err = errWalTornRecord
+		}
+		return
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		err = errWalTornRecord
+		return
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		err = errWalTornRecord
+		return
+	}
+
+	rec = &walRecord{}
+	if err = gob.NewDecoder(bytes.NewReader(payload)).Decode(rec); err != nil {
+		return
+	}
+
+	size = int64(walFrameHeaderSize + len(payload))
+	return
+}
+
+// Replays the enabled write-ahead log on top of the current store content.
+func (ts *TreeStore) replayWriteAheadLog(l lane.Lane) (err error) {
+	wal := ts.wal.Load()
+	if wal == nil {
+		return
+	}
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	return ts.replayWriteAheadLogFile(l, wal)
+}
+
+// worker - applies the records newer than the current sequence, then positions
+// the file for appending, discarding a torn tail if there is one
+func (ts *TreeStore) replayWriteAheadLogFile(l lane.Lane, wal *writeAheadLog) (err error) {
+	if _, err = wal.fh.Seek(0, io.SeekStart); err != nil {
+		l.Errorf("failed to seek write-ahead log %s: %s", wal.fileName, err.Error())
+		return
+	}
+
+	ts.walReplaying.Store(true)
+	defer func() {
+		ts.walReplaying.Store(false)
+		ts.replayTick.Store(0)
+	}()
+
+	var validEnd int64
+	replayed := 0
+	for {
+		rec, size, readErr := readWalRecord(wal.fh)
+		if readErr != nil {
+			if readErr == errWalTornRecord {
+				l.Warnf("write-ahead log %s has a torn record at offset %d; discarding it", wal.fileName, validEnd)
+			} else if readErr != io.EOF {
+				err = readErr
+				l.Errorf("failed to read write-ahead log %s: %s", wal.fileName, err.Error())
+				return
+			}
+			break
+		}
+		validEnd += size
+
+		if rec.Sequence <= ts.walSequence.Load() {
+			// already part of the loaded snapshot
+			continue
+		}
+
+		if err = ts.applyWalRecord(rec); err != nil {
+			l.Errorf("failed to replay mutation %d from %s: %s", rec.Sequence, wal.fileName, err.Error())
+			return
+		}
+		ts.walSequence.Store(rec.Sequence)
+		replayed++
+	}
+
+	if err = wal.fh.Truncate(validEnd); err != nil {
+		l.Errorf("failed to trim write-ahead log %s: %s", wal.fileName, err.Error())
+		return
+	}
+	if _, err = wal.fh.Seek(validEnd, io.SeekStart); err != nil {
+		l.Errorf("failed to seek write-ahead log %s: %s", wal.fileName, err.Error())
+		return
+	}
+
+	l.Tracef("treestore: write-ahead log: replayed:%d sequence:%d", replayed, ts.walSequence.Load())
+	return
+}
+
+// worker - drops the records that a snapshot has captured, given the last
+// sequence the snapshot includes. When the snapshot holds everything, the log
+// is simply emptied; otherwise the newer records are copied to a replacement
+// log that is renamed over the original.
+func (ts *TreeStore) trimWriteAheadLog(l lane.Lane, sequence uint64) (err error) {
+	wal := ts.wal.Load()
+	if wal == nil {
+		return
+	}
+
+	wal.mu.Lock()
+	defer wal.mu.Unlock()
+
+	if wal.fh == nil {
+		// disabled concurrently
+		return
+	}
+
+	if sequence >= ts.walSequence.Load() {
+		if err = wal.fh.Truncate(0); err != nil {
+			l.Errorf("failed to truncate write-ahead log %s: %s", wal.fileName, err.Error())
+			return
+		}
+		if _, err = wal.fh.Seek(0, io.SeekStart); err != nil {
+			l.Errorf("failed to seek write-ahead log %s: %s", wal.fileName, err.Error())
+		}
+		return
+	}
+
+	afs := ts.fileSystem()
+	tempFileName := wal.fileName + ".tmp"
+	var tempFh afero.File
+	if tempFh, err = afs.Create(tempFileName); err != nil {
+		l.Errorf("failed to create %s: %s", tempFileName, err.Error())
+		return
+	}
+
+	kept, err := wal.copyRecordsAfter(sequence, tempFh)
+	if err == nil {
+		err = tempFh.Sync()
+	}
+	if closeErr := tempFh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		l.Errorf("failed to write %s: %s", tempFileName, err.Error())
+		afs.Remove(tempFileName)
+		return
+	}
+
+	wal.fh.Close()
+	wal.fh = nil
+
+	renameErr := afs.Rename(tempFileName, wal.fileName)
+	if renameErr != nil {
+		l.Errorf("failed to rename %s to %s: %s", tempFileName, wal.fileName, renameErr.Error())
+		afs.Remove(tempFileName)
+	}
+	syncDir(afs, filepath.Dir(wal.fileName))
+
+	// reopen whichever log is in place so that recording continues
+	var fh afero.File
+	if fh, err = afs.OpenFile(wal.fileName, os.O_RDWR, 0644); err != nil {
+		l.Errorf("failed to reopen write-ahead log %s: %s", wal.fileName, err.Error())
+		return
+	}
+	if _, err = fh.Seek(0, io.SeekEnd); err != nil {
+		l.Errorf("failed to seek write-ahead log %s: %s", wal.fileName, err.Error())
+		fh.Close()
+		return
+	}
+	wal.fh = fh
+
+	if renameErr != nil {
+		// the untrimmed log remains in place
+		err = renameErr
+		return
+	}
+
+	l.Tracef("treestore: write-ahead log: trimmed through sequence:%d kept:%d", sequence, kept)
+	return
+}
+
+// worker - copies the framed records that follow `sequence` to `w`; the
+// caller must hold the log mutex
+func (wal *writeAheadLog) copyRecordsAfter(sequence uint64, w io.Writer) (kept int, err error) {
+	if _, err = wal.fh.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	for {
+		rec, _, readErr := readWalRecord(wal.fh)
+		if readErr != nil {
+			if readErr != io.EOF {
+				err = readErr
+			}
+			return
+		}
+
+		if rec.Sequence <= sequence {
+			continue
+		}
+
+		if err = writeWalFrame(w, rec); err != nil {
+			return
+		}
+		kept++
+	}
+}
+
+func walTokenSets(sks []StoreKey) []TokenSet {
+	sets := make([]TokenSet, 0, len(sks))
+	for _, sk := range sks {
+		sets = append(sets, sk.Tokens)
+	}
+	return sets
+}
+
+func walStoreKeys(sets []TokenSet) []StoreKey {
+	sks := make([]StoreKey, 0, len(sets))
+	for _, tokens := range sets {
+		sks = append(sks, MakeStoreKeyFromTokenSegments(tokens...))
+	}
+	return sks
+}
+
+// worker - re-executes a logged mutation through the public API
+func (ts *TreeStore) applyWalRecord(rec *walRecord) (err error) {
+	ts.replayTick.Store(rec.Timestamp)
+
+	sk := MakeStoreKeyFromTokenSegments(rec.Sk...)
+	sk2 := MakeStoreKeyFromTokenSegments(rec.Sk2...)
+
+	switch rec.Op {
+	case walSetKey:
+		ts.SetKey(sk)
+	case walSetKeyValue:
+		ts.SetKeyValue(sk, rec.Value)
+	case walSetKeyValueEx:
+		var relationships []StoreAddress
+		if rec.HasRelationships {
+			relationships = rec.Relationships
+			if relationships == nil {
+				relationships = []StoreAddress{}
+			}
+		}
+		ts.SetKeyValueEx(sk, rec.Value, SetExFlags(rec.Flags), rec.Expiration, relationships)
+	case walSetKeyTtl:
+		ts.SetKeyTtl(sk, rec.Expiration)
+	case walSetKeyValueTtl:
+		ts.SetKeyValueTtl(sk, rec.Expiration)
+	case walDeleteKeyWithValue:
+		ts.DeleteKeyWithValue(sk, rec.Option)
+	case walDeleteKey:
+		ts.DeleteKey(sk)
+	case walDeleteKeyTree:
+		ts.DeleteKeyTree(sk)
+	case walSetMetadataAttribute:
+		ts.SetMetadataAttribute(sk, rec.Attribute, rec.AttributeValue)
+	case walClearMetadataAttribute:
+		ts.ClearMetadataAttribute(sk, rec.Attribute)
+	case walClearKeyMetadata:
+		ts.ClearKeyMetadata(sk)
+	case walMoveReferencedKey:
+		ts.MoveReferencedKey(sk, sk2, rec.Option, rec.Expiration, walStoreKeys(rec.Refs), walStoreKeys(rec.Unrefs))
+	case walDefineAutoLinkKey:
+		fields := make([]SubPath, 0, len(rec.Fields))
+		for _, field := range rec.Fields {
+			fields = append(fields, UnescapeSubPath(field))
+		}
+		ts.DefineAutoLinkKey(sk, sk2, fields)
+	case walRemoveAutoLinkKey:
+		ts.RemoveAutoLinkKey(sk, sk2)
+	case walSetKeyJson:
+		_, _, err = ts.SetKeyJson(sk, rec.JsonData, JsonOptions(rec.Flags))
+	case walStageKeyJson:
+		_, _, err = ts.StageKeyJson(sk, rec.JsonData, JsonOptions(rec.Flags))
+	case walReplaceKeyJson:
+		_, _, err = ts.ReplaceKeyJson(sk, rec.JsonData, JsonOptions(rec.Flags))
+	case walCreateKeyJson:
+		_, _, err = ts.CreateKeyJson(sk, rec.JsonData, JsonOptions(rec.Flags))
+	case walMergeKeyJson:
+		_, err = ts.MergeKeyJson(sk, rec.JsonData, JsonOptions(rec.Flags))
+	case walImport:
+		err = ts.Import(sk, rec.JsonData)
+	case walPurge:
+		ts.Purge()
+	case walLoadSubtree:
+		ts.graftSubtree(sk, rec.Nodes)
+	case walTransaction:
+		for i := range rec.Records {
+			if err = ts.applyWalRecord(&rec.Records[i]); err != nil {
+				break
+			}
+		}
+	case walSetHistoryRetention:
+		err = ts.SetHistoryRetention(rec.Retention)
+	default:
+		err = fmt.Errorf("unknown write-ahead log operation %d", rec.Op)
+	}
+
+	// keep address assignment aligned with the original execution
+	if rec.NextAddress > ts.nextAddress.Load() {
+		ts.nextAddress.Store(rec.NextAddress)
+	}
+	return
+}
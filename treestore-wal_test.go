@@ -0,0 +1,475 @@
+package treestore
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
+)
+
+func walTestMutations(t *testing.T, ts *TreeStore) {
+	ts.SetKey(MakeStoreKey("empty"))
+	ts.SetKeyValue(MakeStoreKey("counter"), 10)
+	ts.SetKeyValue(MakeStoreKey("counter"), 11)
+	ts.CalculateKeyValue(MakeStoreKey("counter"), "i+1")
+	ts.SetMetadataAttribute(MakeStoreKey("counter"), "unit", "each")
+
+	_, _, err := ts.SetKeyJson(MakeStoreKey("records"), []byte(`{"a":{"name":"cat"},"b":{"name":"dog"}}`), JsonStringValuesAsKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, exists := ts.LocateKey(MakeStoreKey("records", "b"))
+	if !exists {
+		t.Fatal("locate record")
+	}
+	ts.SetKeyValueEx(MakeStoreKey("pointer"), "to b", 0, 0, []StoreAddress{target})
+
+	ts.DefineAutoLinkKey(MakeStoreKey("records"), MakeStoreKey("names"), []SubPath{MakeSubPath("name")})
+
+	ts.SetKeyValue(MakeStoreKey("temp", "x"), "x")
+	ts.DeleteKey(MakeStoreKey("temp", "x"))
+	ts.MoveKey(MakeStoreKey("empty"), MakeStoreKey("moved"), false)
+}
+
+func walTestVerify(t *testing.T, ts *TreeStore) {
+	val, _, _ := ts.GetKeyValue(MakeStoreKey("counter"))
+	if val != 12 {
+		t.Errorf("counter value %v", val)
+	}
+
+	exists, unit := ts.GetMetadataAttribute(MakeStoreKey("counter"), "unit")
+	if !exists || unit != "each" {
+		t.Error("metadata")
+	}
+
+	hasLink, rv := ts.GetRelationshipValue(MakeStoreKey("pointer"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/records/b" {
+		t.Error("relationship")
+	}
+
+	hasLink, rv = ts.GetRelationshipValue(MakeStoreKey("names", "cat"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/records/a" {
+		t.Error("auto-link")
+	}
+
+	_, exists = ts.LocateKey(MakeStoreKey("temp", "x"))
+	if exists {
+		t.Error("deleted key")
+	}
+
+	_, exists = ts.LocateKey(MakeStoreKey("empty"))
+	if exists {
+		t.Error("move source")
+	}
+	_, exists = ts.LocateKey(MakeStoreKey("moved"))
+	if !exists {
+		t.Error("move destination")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("diag dump")
+	}
+}
+
+func TestWalReplayWithoutSnapshot(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	walTestMutations(t, ts)
+	walTestVerify(t, ts)
+
+	// simulate a crash - nothing saved
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	walTestVerify(t, ts2)
+
+	if ts2.nextAddress.Load() != ts.nextAddress.Load() {
+		t.Error("next address")
+	}
+
+	addr1, _ := ts.LocateKey(MakeStoreKey("names", "dog"))
+	addr2, _ := ts2.LocateKey(MakeStoreKey("names", "dog"))
+	if addr1 == 0 || addr1 != addr2 {
+		t.Error("replayed address")
+	}
+}
+
+func TestWalReplayAcrossExpiration(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	sk := MakeStoreKey("a")
+	expireNs := time.Now().UTC().Add(200 * time.Millisecond).UnixNano()
+	addr, _, _ := ts.SetKeyValueEx(sk, 1, 0, expireNs, nil)
+	ts.SetKeyValueEx(sk, 2, SetExMustExist, -1, nil)
+	ts.SetKeyValueEx(sk, 3, SetExMustExist, 0, nil)
+	ts.SetKeyValueEx(MakeStoreKey("b"), "to a", 0, 0, []StoreAddress{addr})
+
+	// the key expiration passes before the log is replayed
+	time.Sleep(300 * time.Millisecond)
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	addr2, exists := ts2.LocateKey(sk)
+	if !exists || addr2 != addr {
+		t.Errorf("replayed address %d, expected %d", addr2, addr)
+	}
+
+	values, _ := ts2.GetKeyValueHistory(sk, 0, 0, 10, false)
+	if len(values) != 3 {
+		t.Errorf("replayed history %d", len(values))
+	}
+
+	hasLink, rv := ts2.GetRelationshipValue(MakeStoreKey("b"), 0)
+	if !hasLink || rv == nil || rv.Sk.Path != "/a" {
+		t.Error("relationship")
+	}
+
+	if ttl := ts2.GetKeyTtl(sk); ttl != 0 {
+		t.Errorf("replayed expiration %d", ttl)
+	}
+}
+
+func TestWalReplayOnSnapshot(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("counter"), 10)
+	ts.SetKeyValue(MakeStoreKey("temp", "x"), "x")
+
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat("/test.wal")
+	if err != nil || info.Size() != 0 {
+		t.Error("log not truncated")
+	}
+
+	ts.SetKeyValue(MakeStoreKey("counter"), 11)
+	ts.DeleteKeyTree(MakeStoreKey("temp"))
+
+	// load, then enable the log
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err = ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// enable the log, then load
+	ts3 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err = ts3.EnableWriteAheadLog(ts3.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+	if err = ts3.Load(ts3.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tsv := range []*TreeStore{ts2, ts3} {
+		val, _, _ := tsv.GetKeyValue(MakeStoreKey("counter"))
+		if val != 11 {
+			t.Errorf("counter value %v", val)
+		}
+
+		val, exists := tsv.GetKeyValueAtTime(MakeStoreKey("counter"), -1)
+		if val != 11 || !exists {
+			t.Error("history")
+		}
+
+		_, exists = tsv.LocateKey(MakeStoreKey("temp"))
+		if exists {
+			t.Error("deleted tree")
+		}
+
+		if !tsv.DiagDump() {
+			t.Error("diag dump")
+		}
+	}
+}
+
+func TestWalSkipsSnapshotRecords(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.MergeKeyJson(MakeStoreKey("list"), []byte(`[1,2]`), 0)
+
+	// snapshot taken without truncating the log, as if the process
+	// crashed right after the snapshot was written
+	wal := ts.wal.Swap(nil)
+	if err := ts.Save(ts.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+	ts.wal.Store(wal)
+
+	ts.MergeKeyJson(MakeStoreKey("list"), []byte(`[3]`), 0)
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonData, err := ts2.GetKeyAsJson(MakeStoreKey("list"), 0)
+	if err != nil || string(jsonData) != "[1,2,3]" {
+		t.Errorf("list replay %s", string(jsonData))
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("diag dump")
+	}
+}
+
+func TestWalTornTail(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	ts.SetKeyValue(MakeStoreKey("b"), 2)
+
+	if err := ts.DisableWriteAheadLog(ts.l); err != nil {
+		t.Fatal(err)
+	}
+
+	// chop the last record
+	info, err := fs.Stat("/test.wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh, err := fs.OpenFile("/test.wal", os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Truncate(info.Size() - 3)
+	fh.Close()
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err = ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ := ts2.GetKeyValue(MakeStoreKey("a"))
+	if val != 1 {
+		t.Error("first record")
+	}
+
+	_, exists := ts2.LocateKey(MakeStoreKey("b"))
+	if exists {
+		t.Error("torn record applied")
+	}
+
+	// new records follow the last good record
+	ts2.SetKeyValue(MakeStoreKey("c"), 3)
+
+	ts3 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err = ts3.EnableWriteAheadLog(ts3.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	val, _, _ = ts3.GetKeyValue(MakeStoreKey("c"))
+	if val != 3 {
+		t.Error("record after torn tail")
+	}
+
+	if !ts3.DiagDump() {
+		t.Error("diag dump")
+	}
+}
+
+func TestWalTrimKeepsNewerRecords(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+
+	// capture the snapshot content, then mutate before the snapshot is written,
+	// as happens during SaveOnline
+	f := ts.freeze()
+
+	ts.SetKeyValue(MakeStoreKey("b"), 2)
+
+	if err := ts.writeSnapshot(ts.l, &storeWalker{ts: ts, f: f}, "/test.db", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.trimWriteAheadLog(ts.l, f.walSequence); err != nil {
+		t.Fatal(err)
+	}
+	ts.thaw(f)
+
+	// recording continues after the trim
+	ts.SetKeyValue(MakeStoreKey("c"), 3)
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts2.Load(ts2.l, "/test.db"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, exists := ts2.LocateKey(MakeStoreKey("b"))
+	if exists {
+		t.Error("snapshot has later key")
+	}
+
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range []string{"a", "b", "c"} {
+		val, _, _ := ts2.GetKeyValue(MakeStoreKey(key))
+		if val != i+1 {
+			t.Errorf("key %s value %v", key, val)
+		}
+	}
+
+	// the trimmed log holds only the records after the snapshot
+	ts3 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	if err := ts3.EnableWriteAheadLog(ts3.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	_, exists = ts3.LocateKey(MakeStoreKey("a"))
+	if exists {
+		t.Error("trimmed record replayed")
+	}
+
+	if !ts2.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+type renameFailFs struct {
+	afero.Fs
+}
+
+func (rfs renameFailFs) Rename(oldname, newname string) error {
+	return os.ErrPermission
+}
+
+func TestWalTrimRenameFailure(t *testing.T) {
+	afs := afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts.SetFileSystem(afs)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a"), 1)
+	sequence := ts.walSequence.Load()
+	ts.SetKeyValue(MakeStoreKey("b"), 2)
+
+	ts.SetFileSystem(renameFailFs{afs})
+	if err := ts.trimWriteAheadLog(ts.l, sequence); err == nil {
+		t.Error("rename failure not reported")
+	}
+	ts.SetFileSystem(afs)
+
+	// the untrimmed log remains in use
+	ts.SetKeyValue(MakeStoreKey("c"), 3)
+
+	ts2 := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	ts2.SetFileSystem(afs)
+	if err := ts2.EnableWriteAheadLog(ts2.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range []string{"a", "b", "c"} {
+		val, _, _ := ts2.GetKeyValue(MakeStoreKey(key))
+		if val != i+1 {
+			t.Errorf("key %s value %v", key, val)
+		}
+	}
+}
+
+func TestWalSkipsNoopDeletes(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	ts.DeleteKey(MakeStoreKey("missing"))
+	ts.DeleteKeyTree(MakeStoreKey("missing", "child"))
+	ts.DeleteKeyWithValue(MakeStoreKey("missing"), true)
+	if ts.walSequence.Load() != 0 {
+		t.Error("no-op deletes logged")
+	}
+
+	ts.SetKeyValue(MakeStoreKey("a", "b"), 1)
+	ts.DeleteKeyWithValue(MakeStoreKey("a"), false)
+	if ts.walSequence.Load() != 1 {
+		t.Error("delete of key without value logged")
+	}
+
+	ts.DeleteKeyTree(MakeStoreKey("a"))
+	if ts.walSequence.Load() != 2 {
+		t.Error("delete not logged")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestWalDisableDuringMutations(t *testing.T) {
+	fs = afero.NewMemMapFs()
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	if err := ts.EnableWriteAheadLog(ts.l, "/test.wal", false); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			ts.SetKeyValue(MakeStoreKey("counter"), i)
+		}
+	}()
+
+	if err := ts.DisableWriteAheadLog(ts.l); err != nil {
+		t.Error(err)
+	}
+	<-done
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
@@ -0,0 +1,309 @@
+package treestore
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	WatchOp int
+
+	// Describes a change to a key that matches a watch pattern.
+	WatchEvent struct {
+		Op       WatchOp
+		Sk       StoreKey
+		Address  StoreAddress // the key address; 0 if a WatchTree key no longer exists
+		OldValue any          // nil if the key did not have a value, or for WatchTree
+		NewValue any          // nil if the key does not have a value
+	}
+
+	WatchOptions struct {
+		BufferSize int // capacity of the event channel; the default is 64
+		MaxPending int // undelivered events that end the watch with ErrWatchOverflow; the default is 10000
+	}
+
+	// A subscription to changes of keys matching a pattern, created by Watch.
+	// Events are delivered in the order the changes were made.
+	Watcher struct {
+		ts      *TreeStore
+		pattern []patternSegment
+		opts    WatchOptions
+		events  chan *WatchEvent
+		mu      sync.Mutex
+		pending []*WatchEvent
+		err     error
+		wake    chan struct{}
+		stop    chan struct{}
+		done    chan struct{}
+		closing sync.Once
+	}
+
+	watchRegistry struct {
+		mu       sync.Mutex
+		watchers atomic.Pointer[[]*Watcher]
+	}
+)
+
+const (
+	WatchSet    WatchOp = iota // a key was created, or its value was set
+	WatchDelete                // a key or its value was deleted
+	WatchExpire                // an expired key was removed
+	WatchTree                  // a key and its children were replaced, moved or imported
+)
+
+var ErrWatchOverflow = errors.New("watch events were not received in time")
+
+// Subscribes to changes of the keys that match `skPattern`, which uses the
+// same pattern syntax as GetMatchingKeys. An empty pattern watches the
+// sentinel key.
+//
+// Value changes made by SetKey, SetKeyValue, SetKeyValueEx and
+// CalculateKeyValue raise WatchSet. The delete APIs raise WatchDelete for each
+// key removed, and expired keys raise WatchExpire as described in
+// SubscribeKeyEvents. The APIs that replace a whole subtree - the json APIs,
+// Import, LoadSubtree and the move APIs - raise a single WatchTree for the
+// subtree key, which is delivered when the pattern can match the key or any
+// of its children. Changes to metadata and expiration are not reported.
+//
+// Events are queued without blocking the tree store. If the receiver falls
+// more than MaxPending events behind, the watch ends: the channel closes once
+// the queued events are received, and Err returns ErrWatchOverflow.
+//
+// Call Close when the watch is no longer needed.
+func (ts *TreeStore) Watch(skPattern StoreKey, opts WatchOptions) (w *Watcher) {
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 64
+	}
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = 10000
+	}
+
+	w = &Watcher{
+		ts:      ts,
+		pattern: ts.compileKeyPattern(skPattern.Tokens),
+		opts:    opts,
+		events:  make(chan *WatchEvent, opts.BufferSize),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	ts.watches.mu.Lock()
+	var watchers []*Watcher
+	if cur := ts.watches.watchers.Load(); cur != nil {
+		watchers = slices.Clone(*cur)
+	}
+	watchers = append(watchers, w)
+	ts.watches.watchers.Store(&watchers)
+	ts.watches.mu.Unlock()
+
+	go w.deliver()
+	return
+}
+
+// Returns the channel of change events. The channel is closed when the
+// watch ends.
+func (w *Watcher) Events() <-chan *WatchEvent {
+	return w.events
+}
+
+// Returns ErrWatchOverflow if the watch ended because events were not
+// received in time.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Ends the watch and closes the event channel. Events not yet received are
+// discarded.
+func (w *Watcher) Close() {
+	w.closing.Do(func() {
+		w.ts.removeWatcher(w)
+		close(w.stop)
+		<-w.done
+	})
+}
+
+// worker - moves queued events to the channel
+func (w *Watcher) deliver() {
+	defer close(w.done)
+	defer close(w.events)
+
+	for {
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			overflowed := w.err != nil
+			w.mu.Unlock()
+			if overflowed {
+				return
+			}
+
+			select {
+			case <-w.stop:
+				return
+			case <-w.wake:
+			}
+			continue
+		}
+
+		ev := w.pending[0]
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+
+		select {
+		case <-w.stop:
+			return
+		case w.events <- ev:
+		}
+	}
+}
+
+// worker - queues an event for delivery
+func (w *Watcher) enqueue(ev *WatchEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return
+	}
+
+	if len(w.pending) >= w.opts.MaxPending {
+		w.err = ErrWatchOverflow
+	} else {
+		w.pending = append(w.pending, ev)
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// worker - tests if the watch pattern matches the event key
+func (w *Watcher) isMatch(ev *WatchEvent) bool {
+	patternSegs := w.pattern
+	candidate := ev.Sk.Tokens
+
+	if len(patternSegs) == 0 {
+		return len(candidate) == 0
+	}
+
+	if ev.Op != WatchTree {
+		return len(candidate) > 0 && w.ts.iterateFullWorkerIsMatch(patternSegs, candidate)
+	}
+
+	// the pattern can match a child of the subtree if a leading part of the
+	// pattern matches the subtree key
+	if len(candidate) == 0 {
+		return true
+	}
+	for n := 1; n <= len(patternSegs); n++ {
+		if w.ts.iterateFullWorkerIsMatch(patternSegs[:n], candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ts *TreeStore) removeWatcher(w *Watcher) {
+	ts.watches.mu.Lock()
+	defer ts.watches.mu.Unlock()
+
+	cur := ts.watches.watchers.Load()
+	if cur == nil {
+		return
+	}
+
+	watchers := slices.DeleteFunc(slices.Clone(*cur), func(candidate *Watcher) bool {
+		return candidate == w
+	})
+	if len(watchers) == 0 {
+		ts.watches.watchers.Store(nil)
+	} else {
+		ts.watches.watchers.Store(&watchers)
+	}
+}
+
+// worker - ends every watch
+func (ts *TreeStore) closeWatchers() {
+	if cur := ts.watches.watchers.Load(); cur != nil {
+		for _, w := range *cur {
+			w.Close()
+		}
+	}
+}
+
+// worker - returns the active watchers, or nil if there are none
+func (ts *TreeStore) activeWatchers() []*Watcher {
+	if ts.walReplaying.Load() {
+		return nil
+	}
+
+	watchers := ts.watches.watchers.Load()
+	if watchers == nil {
+		return nil
+	}
+	return *watchers
+}
+
+// worker - queues the event for the watchers with a matching pattern; the
+// caller must hold the write lock on ts.keyNodeMu, which orders the events
+func (ts *TreeStore) notifyWatchers(ev *WatchEvent) {
+	for _, w := range ts.activeWatchers() {
+		if w.isMatch(ev) {
+			w.enqueue(ev)
+		}
+	}
+}
+
+// worker - raises WatchSet for a key node after its value was set
+func (ts *TreeStore) watchSetLocked(sk StoreKey, kn *keyNode, oldValue any) {
+	if ts.activeWatchers() == nil {
+		return
+	}
+
+	ev := &WatchEvent{
+		Op:       WatchSet,
+		Sk:       sk,
+		Address:  kn.address,
+		OldValue: oldValue,
+	}
+	if kn.current != nil {
+		ev.NewValue = kn.current.value
+	}
+	ts.notifyWatchers(ev)
+}
+
+// worker - raises WatchTree for a key after its subtree was replaced
+func (ts *TreeStore) watchTreeLocked(sk StoreKey) {
+	if ts.activeWatchers() == nil {
+		return
+	}
+
+	ev := &WatchEvent{
+		Op: WatchTree,
+		Sk: sk,
+	}
+
+	kn := &ts.dbNode
+	expired := false
+	if len(sk.Tokens) > 0 {
+		var index int
+		_, index, kn, expired = ts.locateKeyNodeForLock(sk)
+		if index < len(sk.Tokens) {
+			kn = nil
+		}
+	}
+
+	if kn != nil && !expired {
+		ev.Address = kn.address
+		if kn.current != nil {
+			ev.NewValue = kn.current.value
+		}
+	}
+	ts.notifyWatchers(ev)
+}
@@ -0,0 +1,204 @@
+package treestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jimsnab/go-lane"
+)
+
+func receiveWatchEvents(t *testing.T, w *Watcher, count int) (events []*WatchEvent) {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for len(events) < count {
+		select {
+		case ev, ok := <-w.Events():
+			if !ok {
+				t.Fatalf("watch ended after %d events", len(events))
+			}
+			events = append(events, ev)
+		case <-timeout:
+			t.Fatalf("received %d of %d events", len(events), count)
+		}
+	}
+
+	select {
+	case ev := <-w.Events():
+		if ev != nil {
+			t.Fatalf("unexpected event %+v", ev)
+		}
+	case <-time.After(time.Millisecond * 10):
+	}
+	return
+}
+
+func TestWatchSetAndDelete(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	w := ts.Watch(MakeStoreKey("users", "*"), WatchOptions{})
+	defer w.Close()
+
+	addr, _ := ts.SetKeyValue(MakeStoreKey("users", "fred"), 1)
+	ts.SetKeyValue(MakeStoreKey("users", "fred"), 2)
+	ts.SetKeyValue(MakeStoreKey("groups", "admin"), 3)
+	ts.SetKeyValue(MakeStoreKey("users", "fred", "age"), 30)
+	ts.SetKeyValueEx(MakeStoreKey("users", "fred"), 4, 0, -1, nil)
+	ts.CalculateKeyValue(MakeStoreKey("users", "fred"), "i+1")
+	ts.DeleteKeyWithValue(MakeStoreKey("users", "fred"), false)
+
+	events := receiveWatchEvents(t, w, 5)
+
+	expected := []struct {
+		op     WatchOp
+		oldVal any
+		newVal any
+	}{
+		{WatchSet, nil, 1},
+		{WatchSet, 1, 2},
+		{WatchSet, 2, 4},
+		{WatchSet, 4, 5},
+		{WatchDelete, 5, nil},
+	}
+
+	for i, ex := range expected {
+		ev := events[i]
+		if ev.Op != ex.op || ev.Sk.Path != "/users/fred" || ev.Address != addr || ev.OldValue != ex.oldVal || ev.NewValue != ex.newVal {
+			t.Errorf("event %d: %+v", i, ev)
+		}
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestWatchMultiLevel(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	w := ts.Watch(MakeStoreKey("a", "**"), WatchOptions{})
+	defer w.Close()
+
+	ts.SetKey(MakeStoreKey("a"))
+	ts.SetKey(MakeStoreKey("a", "b"))
+	ts.SetKey(MakeStoreKey("a", "b", "c"))
+	ts.SetKey(MakeStoreKey("x", "b", "c"))
+
+	events := receiveWatchEvents(t, w, 2)
+	if events[0].Sk.Path != "/a/b" || events[1].Sk.Path != "/a/b/c" {
+		t.Error("multi-level events")
+	}
+
+	ts.DeleteKeyTree(MakeStoreKey("a"))
+	events = receiveWatchEvents(t, w, 2)
+	if events[0].Op != WatchDelete || events[0].Sk.Path != "/a/b" || events[1].Sk.Path != "/a/b/c" {
+		t.Error("delete tree events")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestWatchExpire(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	w := ts.Watch(MakeStoreKey("temp"), WatchOptions{})
+	defer w.Close()
+
+	ts.SetKeyValueEx(MakeStoreKey("temp"), "t", 0, 1, nil)
+	ts.SweepExpiredKeys(100)
+
+	events := receiveWatchEvents(t, w, 2)
+	if events[0].Op != WatchSet || events[1].Op != WatchExpire || events[1].OldValue != "t" {
+		t.Error("expire event")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestWatchTree(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	w := ts.Watch(MakeStoreKey("root", "*", "name"), WatchOptions{})
+	defer w.Close()
+
+	// may contain a match
+	ts.SetKeyJson(MakeStoreKey("root"), []byte(`{"1":{"name":"fred"}}`), 0)
+	ts.SetKeyJson(MakeStoreKey("root", "2"), []byte(`{"name":"wilma"}`), 0)
+
+	// can't contain a match
+	ts.SetKeyJson(MakeStoreKey("other"), []byte(`{"1":{"name":"barney"}}`), 0)
+	ts.SetKeyJson(MakeStoreKey("root", "2", "age"), []byte(`{"value":30}`), 0)
+
+	ts.MoveKey(MakeStoreKey("root", "1"), MakeStoreKey("root", "3"), false)
+
+	events := receiveWatchEvents(t, w, 4)
+
+	expected := []TokenPath{"/root", "/root/2", "/root/1", "/root/3"}
+	for i, path := range expected {
+		if events[i].Op != WatchTree || events[i].Sk.Path != path {
+			t.Errorf("event %d: %+v", i, events[i])
+		}
+	}
+
+	if events[2].Address != 0 || events[3].Address == 0 {
+		t.Error("moved addresses")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	w := ts.Watch(MakeStoreKey("*"), WatchOptions{BufferSize: 1, MaxPending: 5})
+	defer w.Close()
+
+	for i := 0; i < 20; i++ {
+		ts.SetKeyValue(MakeStoreKey("counter"), i)
+	}
+
+	n := 0
+	for range w.Events() {
+		n++
+	}
+
+	if w.Err() != ErrWatchOverflow {
+		t.Error("overflow error")
+	}
+	if n < 5 || n >= 20 {
+		t.Errorf("received %d", n)
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestWatchClose(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	w1 := ts.Watch(MakeStoreKey("a"), WatchOptions{})
+	w2 := ts.Watch(MakeStoreKey("a"), WatchOptions{})
+
+	w1.Close()
+	w1.Close()
+
+	ts.SetKey(MakeStoreKey("a"))
+	if _, ok := <-w1.Events(); ok {
+		t.Error("closed watch received an event")
+	}
+	receiveWatchEvents(t, w2, 1)
+
+	ts.Close()
+	if _, ok := <-w2.Events(); ok {
+		t.Error("store close did not end the watch")
+	}
+	if w2.Err() != nil {
+		t.Error("close error")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
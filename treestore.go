@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/jimsnab/go-lane"
+	"github.com/spf13/afero"
 )
 
 type (
@@ -24,6 +25,25 @@ type (
 		activeLocks  atomic.Int32
 		deferredRefs []*deferredRef
 		sanityAddr   map[StoreAddress]TokenPath
+		wal          atomic.Pointer[writeAheadLog]
+		walSequence  atomic.Uint64
+		walReplaying atomic.Bool
+		replayTick   atomic.Int64
+		saveMu       sync.Mutex
+		fs           afero.Fs
+		snapshotOpts SnapshotOptions
+		migrations   []migration
+		autosave     atomic.Pointer[autosaver]
+		expirations  expirationIndex
+		sweeper      atomic.Pointer[expirationSweeper]
+		keyEvents    keyEventSubscribers
+		watches      watchRegistry
+		txRecords    *[]walRecord
+		frozenTick   int64
+		freezes      []*storeFreeze
+		retention    HistoryRetention
+		compactor    atomic.Pointer[historyCompactor]
+		extPatterns  bool
 	}
 
 	StoreAddress uint64
@@ -42,8 +62,12 @@ type (
 		current    *valueInstance
 		history    *avlTree[*valueInstance]
 		expiration int64
-		metadata   map[string]string
-		autoLinks  *keyAutoLinks
+		// set when retention has discarded values, so the history might not
+		// reach back to the first value of the key
+		historyPruned bool
+		expiryPos     int // 1-based position in the expiration index, or 0 if not indexed
+		metadata      map[string]string
+		autoLinks     *keyAutoLinks
 	}
 
 	valueInstance struct {
@@ -100,6 +124,33 @@ func newKeyTree(parent *keyNode) *keyTree {
 	}
 }
 
+// Returns the Unix ns tick used to timestamp value history. During write-ahead
+// log replay, the tick of the original mutation is returned instead.
+func (ts *TreeStore) currentTick() int64 {
+	if tick := ts.replayTick.Load(); tick != 0 {
+		return tick
+	}
+	return time.Now().UTC().UnixNano()
+}
+
+// Returns the time that key expiration is compared to; a snapshot is frozen at
+// the moment it was taken, and write-ahead log replay uses the tick of the
+// original mutation
+func (ts *TreeStore) expirationTick() int64 {
+	if ts.frozenTick != 0 {
+		return ts.frozenTick
+	}
+	if tick := ts.replayTick.Load(); tick != 0 {
+		return tick
+	}
+	return time.Now().UTC().UnixNano()
+}
+
+// Returns the current tick as a history key byte array
+func (ts *TreeStore) currentTimestampBytes() []byte {
+	return unixTimestampBytes(ts.currentTick())
+}
+
 // Looks up the store key using the full key path, returning a read lock if a value exists
 // for the key.
 //
@@ -124,7 +175,7 @@ func (ts *TreeStore) getKeyNodeForValueRead(sk StoreKey) (kn *keyNode, lockedLev
 	address := ts.keys[sk.Path]
 	if address != 0 {
 		kn = ts.addresses[address]
-		if /*kn == nil ||*/ kn.expiration > 0 && kn.expiration < time.Now().UTC().UnixNano() {
+		if /*kn == nil ||*/ kn.isExpired(ts.expirationTick()) {
 			// not found because it is expired
 			kn = nil
 			ts.keyNodeMu.RUnlock()
@@ -157,7 +208,7 @@ func (ts *TreeStore) getKeyNodeForWrite(sk StoreKey) (kn *keyNode, lockedLevel *
 	address := ts.keys[sk.Path]
 	if address != 0 {
 		kn = ts.addresses[address]
-		if kn == nil || kn.expiration > 0 && kn.expiration < time.Now().UTC().UnixNano() {
+		if kn == nil || kn.isExpired(ts.expirationTick()) {
 			// not found because it is expired
 			kn = nil
 			ts.keyNodeMu.RUnlock()
@@ -198,7 +249,7 @@ func (ts *TreeStore) locateKeyNodeForLock(sk StoreKey) (level *keyTree, tokenInd
 		kn = avlNode.value
 	}
 
-	expired = kn.isExpired()
+	expired = kn.isExpired(ts.expirationTick())
 	return
 }
 
@@ -326,6 +377,7 @@ func (ts *TreeStore) createRestOfKey(sk StoreKey, parentLevel *keyTree, index in
 	// add middle levels if necessary
 	for end := len(sk.Tokens); index < end; index++ {
 		if kn != nil {
+			ts.preserveKeyNode(kn)
 			kn.nextLevel = newKeyTree(kn)
 
 			// moving the lock ensures proper cleanup; there won't be lock contention
@@ -352,6 +404,7 @@ func (ts *TreeStore) createRestOfKeyExclusive(sk StoreKey, parentLevel *keyTree,
 	// add middle levels if necessary
 	for end := len(sk.Tokens); tokenIndex < end; tokenIndex++ {
 		if kn != nil {
+			ts.preserveKeyNode(kn)
 			kn.nextLevel = newKeyTree(kn)
 			level = kn.nextLevel
 		}
@@ -375,14 +428,23 @@ func (ts *TreeStore) removeKeyFromIndexLocked(sk StoreKey) (removed bool) {
 }
 
 func (ts *TreeStore) repurposeExpiredKn(sk StoreKey, kn *keyNode) {
+	if ts.keyEventsWanted() {
+		ev := ts.newKeyEvent(MakeStoreKeyFromTokenSegments(ts.getTokenSet(kn)...), kn)
+		ev.Kind = KeyEventExpired
+		defer ts.raiseKeyEvent(ev)
+	}
+
+	ts.preserveKeyNode(kn)
 	delete(ts.keys, sk.Path)
 	delete(ts.addresses, kn.address)
+	ts.dropKeyVersion(kn)
 	ts.purgeIndicies(kn)
 	kn.address = StoreAddress(ts.nextAddress.Add(1))
 	ts.addresses[kn.address] = kn
 	kn.current = nil
-	kn.expiration = 0
+	ts.setKeyExpiration(kn, 0)
 	kn.history = nil
+	kn.historyPruned = false
 	kn.metadata = nil
 
 	ts.addAutoLinks(sk.Tokens, kn, false)
@@ -459,6 +521,11 @@ func (ts *TreeStore) SetKey(sk StoreKey) (address StoreAddress, exists bool) {
 
 	address = kn.address
 	exists = !created
+
+	if created {
+		ts.logMutation(&walRecord{Op: walSetKey, Sk: sk.Tokens})
+		ts.watchSetLocked(sk, kn, nil)
+	}
 	return
 }
 
@@ -483,6 +550,11 @@ func (ts *TreeStore) SetKeyIfExists(testKey, sk StoreKey) (address StoreAddress,
 
 		address = kn.address
 		exists = !created
+
+		if created {
+			ts.logMutation(&walRecord{Op: walSetKey, Sk: sk.Tokens})
+			ts.watchSetLocked(sk, kn, nil)
+		}
 	}
 
 	return
@@ -491,29 +563,45 @@ func (ts *TreeStore) SetKeyIfExists(testKey, sk StoreKey) (address StoreAddress,
 // Set a key with a value, without an expiration, adding to value history if the
 // key already exists.
 func (ts *TreeStore) SetKeyValue(sk StoreKey, value any) (address StoreAddress, firstValue bool) {
-	newLeaf := &valueInstance{
-		value: value,
-	}
-
-	now := currentUnixTimestampBytes()
-
 	// the key node linkage may change
 	ts.keyNodeMu.Lock()
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
+	kn, created, oldValue := ts.setKeyValueLocked(sk, value)
+
+	address = kn.address
+	firstValue = created
+
+	ts.logMutation(&walRecord{Op: walSetKeyValue, Sk: sk.Tokens, Value: value})
+	ts.watchSetLocked(sk, kn, oldValue)
+	return
+}
+
+// worker - caller must hold a write lock on ts.keyNodeMu
+func (ts *TreeStore) setKeyValueLocked(sk StoreKey, value any) (kn *keyNode, created bool, oldValue any) {
+	newLeaf := &valueInstance{
+		value: value,
+	}
+
+	now := ts.currentTimestampBytes()
+
 	kn, ll, created := ts.ensureKeyWithValue(sk)
 	defer ts.completeKeyNodeWrite(ll)
 
+	ts.preserveKeyNode(kn)
 	if kn.history == nil {
 		kn.history = newAvlTree[*valueInstance]()
 	}
 
+	if kn.current != nil {
+		oldValue = kn.current.value
+	}
+
 	kn.current = newLeaf
 	kn.history.Set(now, newLeaf)
-
-	address = kn.address
-	firstValue = created
+	ts.pruneHistoryLocked(kn)
+	ts.bumpKeyVersion(kn)
 	return
 }
 
@@ -539,7 +627,25 @@ func (ts *TreeStore) SetKeyValueEx(sk StoreKey, value any, flags SetExFlags, exp
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
-	return ts.setKeyValueExLocked(sk, value, flags, expireNs, relationships)
+	// resolve a relative expiration so the write-ahead log is exact
+	if expireNs < -1 {
+		expireNs = time.Now().UTC().UnixNano() - expireNs
+	}
+
+	address, exists, originalValue = ts.setKeyValueExLocked(sk, value, flags, expireNs, relationships)
+	if address != 0 {
+		ts.watchSetLocked(sk, ts.addresses[address], originalValue)
+		ts.logMutation(&walRecord{
+			Op:               walSetKeyValueEx,
+			Sk:               sk.Tokens,
+			Value:            value,
+			Flags:            int(flags),
+			Expiration:       expireNs,
+			HasRelationships: relationships != nil,
+			Relationships:    relationships,
+		})
+	}
+	return
 }
 
 func (ts *TreeStore) setKeyValueExLocked(sk StoreKey, value any, flags SetExFlags, expireNs int64, relationships []StoreAddress) (address StoreAddress, exists bool, originalValue any) {
@@ -597,21 +703,24 @@ func (ts *TreeStore) setKeyValueExLocked(sk StoreKey, value any, flags SetExFlag
 			newLeaf.relationships = kn.current.relationships
 		}
 
-		now := currentUnixTimestampBytes()
+		now := ts.currentTimestampBytes()
+		ts.preserveKeyNode(kn)
 		if kn.history == nil {
 			kn.history = newAvlTree[*valueInstance]()
 		}
 
 		kn.current = newLeaf
 		kn.history.Set(now, newLeaf)
+		ts.pruneHistoryLocked(kn)
 		ts.keys[sk.Path] = kn.address
+		ts.bumpKeyVersion(kn)
 	}
 
 	if expireNs < -1 {
 		expireNs = time.Now().UTC().UnixNano() - expireNs
 	}
 	if expireNs >= 0 {
-		kn.expiration = expireNs
+		ts.setKeyExpiration(kn, expireNs)
 	}
 
 	address = kn.address
@@ -674,9 +783,10 @@ func (ts *TreeStore) SetKeyTtl(sk StoreKey, expiration int64) (exists bool) {
 
 	if index >= len(sk.Tokens) && !expired {
 		if expiration >= 0 {
-			kn.expiration = expiration
+			ts.setKeyExpiration(kn, expiration)
 		}
 		exists = true
+		ts.logMutation(&walRecord{Op: walSetKeyTtl, Sk: sk.Tokens, Expiration: expiration})
 	}
 	return
 }
@@ -715,8 +825,9 @@ func (ts *TreeStore) SetKeyValueTtl(sk StoreKey, expiration int64) (exists bool)
 	kn, ll := ts.getKeyNodeForWrite(sk)
 	if kn != nil {
 		if expiration >= 0 {
-			kn.expiration = expiration
+			ts.setKeyExpiration(kn, expiration)
 		}
+		ts.logMutation(&walRecord{Op: walSetKeyValueTtl, Sk: sk.Tokens, Expiration: expiration})
 		ts.completeKeyNodeWrite(ll)
 		exists = true
 	}
@@ -731,15 +842,9 @@ func (ts *TreeStore) SetKeyValueTtl(sk StoreKey, expiration int64) (exists bool)
 func (ts *TreeStore) GetKeyValueAtTime(sk StoreKey, tickNs int64) (value any, exists bool) {
 	kn, ll := ts.getKeyNodeForValueRead(sk)
 	if kn != nil {
-		if tickNs < 0 {
-			tickNs = time.Now().UTC().UnixNano() - tickNs
-		}
-		if kn.history != nil && tickNs >= 0 {
-			item := kn.history.FindLeft(unixTimestampBytes(tickNs))
-			if item != nil {
-				value = item.value.value
-				exists = true
-			}
+		if vi := kn.valueAt(ts.resolveTick(tickNs)); vi != nil {
+			value = vi.value
+			exists = true
 		}
 		ts.completeKeyNodeRead(ll)
 	}
@@ -760,7 +865,17 @@ func (ts *TreeStore) DeleteKeyWithValue(sk StoreKey, clean bool) (removed bool,
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
-	return ts.deleteKeyWithValueLocked(sk, clean)
+	ev := ts.keyEventLocked(sk)
+	found, expired := ts.locateDeleteTargetLocked(sk)
+	removed, originalValue = ts.deleteKeyWithValueLocked(sk, clean)
+	if removed || (found && expired) {
+		ts.logMutation(&walRecord{Op: walDeleteKeyWithValue, Sk: sk.Tokens, Option: clean})
+	}
+
+	if ev != nil && (removed || ev.Kind == KeyEventExpired) {
+		ts.raiseKeyEvent(ev)
+	}
+	return
 }
 
 func (ts *TreeStore) deleteKeyWithValueLocked(sk StoreKey, clean bool) (removed bool, originalValue any) {
@@ -768,11 +883,14 @@ func (ts *TreeStore) deleteKeyWithValueLocked(sk StoreKey, clean bool) (removed
 	if end == 0 {
 		ts.dbNodeLevel.lock.Lock() // ensure any pending operations on this keynode complete
 		ts.activeLocks.Add(1)
+		ts.preserveKeyNode(&ts.dbNode)
 		if ts.dbNode.current != nil {
 			originalValue = ts.dbNode.current.value
 			removed = true
 			ts.dbNode.current = nil
 			ts.dbNode.history = nil
+			ts.dbNode.historyPruned = false
+			ts.bumpKeyVersion(&ts.dbNode)
 		}
 		ts.dbNode.metadata = nil
 		ts.removeKeyFromIndexLocked(sk)
@@ -792,17 +910,23 @@ func (ts *TreeStore) deleteKeyWithValueLocked(sk StoreKey, clean bool) (removed
 	if ts.removeKeyFromIndexLocked(sk) || expired {
 		ts.removeAutoLinks(sk.Tokens, kn, false)
 
+		ts.preserveKeyNode(kn)
 		if kn.current != nil {
 			originalValue = kn.current.value
 			kn.current = nil
+			ts.bumpKeyVersion(kn)
 		}
 		kn.history = nil
+		kn.historyPruned = false
 		kn.metadata = nil
 
 		if kn.nextLevel == nil {
 			for tokenIndex := end - 1; tokenIndex >= 0; tokenIndex-- {
 				// permanently delete the node
+				ts.preserveRemovedKeyNode(level, kn)
 				delete(ts.addresses, kn.address)
+				ts.dropKeyVersion(kn)
+				ts.dropKeyExpiration(kn)
 				level.tree.Delete(kn.key)
 				kn.ownerTree = nil
 				ts.purgeIndicies(kn)
@@ -824,6 +948,7 @@ func (ts *TreeStore) deleteKeyWithValueLocked(sk StoreKey, clean bool) (removed
 				}
 
 				level.parent = nil
+				ts.preserveKeyNode(kn)
 				kn.nextLevel = nil
 
 				// stop if not cleaning
@@ -872,7 +997,34 @@ func (ts *TreeStore) DeleteKey(sk StoreKey) (keyRemoved, valueRemoved bool, orig
 	defer ts.sanityCheck()
 	defer ts.keyNodeMu.Unlock()
 
+	return ts.deleteKeyLogged(sk)
+}
+
+// worker - performs DeleteKey; the caller must hold the write lock on ts.keyNodeMu
+func (ts *TreeStore) deleteKeyLogged(sk StoreKey) (keyRemoved, valueRemoved bool, originalValue any) {
+	ev := ts.keyEventLocked(sk)
+	found, _ := ts.locateDeleteTargetLocked(sk)
 	keyRemoved, valueRemoved, originalValue, _ = ts.deleteKeyLocked(sk)
+	if found {
+		ts.logMutation(&walRecord{Op: walDeleteKey, Sk: sk.Tokens})
+	}
+
+	if ev != nil && (keyRemoved || valueRemoved || ev.Kind == KeyEventExpired) {
+		ts.raiseKeyEvent(ev)
+	}
+	return
+}
+
+// worker - determines if a delete will find the key node of `sk`, so that a
+// delete that changes nothing is not logged. The sentinel is always found. The
+// caller must hold the write lock on ts.keyNodeMu.
+func (ts *TreeStore) locateDeleteTargetLocked(sk StoreKey) (found, expired bool) {
+	if len(sk.Tokens) == 0 {
+		return true, false
+	}
+
+	_, index, _, expired := ts.locateKeyNodeForLock(sk)
+	found = index >= len(sk.Tokens)
 	return
 }
 
@@ -895,16 +1047,26 @@ func (ts *TreeStore) deleteKeyLocked(sk StoreKey) (keyRemoved, valueRemoved bool
 		ts.activeLocks.Add(-1)
 	}()
 
+	ts.preserveKeyNode(kn)
 	if ts.removeKeyFromIndexLocked(sk) || expired {
 		if !expired {
 			valueRemoved = true
 			originalValue = kn.current.value
 		}
-		kn.current = nil
+		if kn.current != nil {
+			kn.current = nil
+			ts.bumpKeyVersion(kn)
+		}
 	}
 	kn.history = nil
+	kn.historyPruned = false
 	kn.metadata = nil
 
+	if expired && kn.nextLevel != nil {
+		// the expired key remains as the parent of its children
+		ts.setKeyExpiration(kn, 0)
+	}
+
 	if kn.nextLevel == nil {
 		if kn.ownerTree != nil {
 			parent = kn.ownerTree.parent
@@ -936,10 +1098,13 @@ func (ts *TreeStore) deleteKeyUpToLocked(rootSk, deleteSk StoreKey) (keyRemoved,
 }
 
 func (ts *TreeStore) deleteKeyNodeLocked(sk StoreKey, level *keyTree, kn *keyNode) {
+	ts.preserveRemovedKeyNode(level, kn)
 	ts.removeAutoLinks(sk.Tokens, kn, false)
 
 	// permanently delete the node
 	delete(ts.addresses, kn.address)
+	ts.dropKeyVersion(kn)
+	ts.dropKeyExpiration(kn)
 	level.tree.Delete(kn.key)
 	kn.ownerTree = nil
 
@@ -947,6 +1112,7 @@ func (ts *TreeStore) deleteKeyNodeLocked(sk StoreKey, level *keyTree, kn *keyNod
 	if level.tree.nodes == 0 {
 		parent := level.parent
 		if parent != nil {
+			ts.preserveKeyNode(parent)
 			parent.nextLevel = nil
 			level.parent = nil
 		}
@@ -961,6 +1127,11 @@ func (ts *TreeStore) SetMetadataAttribute(sk StoreKey, attribute, value string)
 	ts.keyNodeMu.RLock()
 	defer ts.keyNodeMu.RUnlock()
 
+	return ts.setMetadataAttributeLogged(sk, attribute, value)
+}
+
+// worker - performs SetMetadataAttribute; the caller must hold a lock on ts.keyNodeMu
+func (ts *TreeStore) setMetadataAttributeLogged(sk StoreKey, attribute, value string) (keyExists bool, originalValue string) {
 	level, index, kn, expired := ts.locateKeyNodeForWriteLocked(sk)
 	defer ts.completeKeyNodeWrite(level)
 
@@ -969,6 +1140,7 @@ func (ts *TreeStore) SetMetadataAttribute(sk StoreKey, attribute, value string)
 	}
 	keyExists = true
 
+	ts.preserveKeyNode(kn)
 	if kn.metadata != nil {
 		originalValue = kn.metadata[attribute]
 	} else {
@@ -976,6 +1148,8 @@ func (ts *TreeStore) SetMetadataAttribute(sk StoreKey, attribute, value string)
 	}
 
 	kn.metadata[attribute] = value
+
+	ts.logMutation(&walRecord{Op: walSetMetadataAttribute, Sk: sk.Tokens, Attribute: attribute, AttributeValue: value})
 	return
 }
 
@@ -985,6 +1159,11 @@ func (ts *TreeStore) ClearMetadataAttribute(sk StoreKey, attribute string) (attr
 	ts.keyNodeMu.RLock()
 	defer ts.keyNodeMu.RUnlock()
 
+	return ts.clearMetadataAttributeLogged(sk, attribute)
+}
+
+// worker - performs ClearMetadataAttribute; the caller must hold a lock on ts.keyNodeMu
+func (ts *TreeStore) clearMetadataAttributeLogged(sk StoreKey, attribute string) (attributeExists bool, originalValue string) {
 	level, index, kn, expired := ts.locateKeyNodeForWriteLocked(sk)
 	defer ts.completeKeyNodeWrite(level)
 
@@ -995,11 +1174,13 @@ func (ts *TreeStore) ClearMetadataAttribute(sk StoreKey, attribute string) (attr
 	if kn.metadata != nil {
 		originalValue, attributeExists = kn.metadata[attribute]
 		if attributeExists {
+			ts.preserveKeyNode(kn)
 			if len(kn.metadata) == 1 {
 				kn.metadata = nil
 			} else {
 				delete(kn.metadata, attribute)
 			}
+			ts.logMutation(&walRecord{Op: walClearMetadataAttribute, Sk: sk.Tokens, Attribute: attribute})
 		}
 	}
 
@@ -1019,7 +1200,9 @@ func (ts *TreeStore) ClearKeyMetadata(sk StoreKey) {
 		return
 	}
 
+	ts.preserveKeyNode(kn)
 	kn.metadata = nil
+	ts.logMutation(&walRecord{Op: walClearKeyMetadata, Sk: sk.Tokens})
 }
 
 // Fetches a key's metadata value for a specific attribute
@@ -1093,7 +1276,7 @@ func (ts *TreeStore) KeyFromAddress(addr StoreAddress) (sk StoreKey, exists bool
 
 func (ts *TreeStore) keyFromAddressLocked(addr StoreAddress) (sk StoreKey, exists bool) {
 	kn, tokens := ts.getTokenSetForAddressLocked(addr)
-	if kn != nil && !kn.isExpired() {
+	if kn != nil && !kn.isExpired(ts.expirationTick()) {
 		exists = true
 		sk = MakeStoreKeyFromTokenSegments(tokens...)
 	}
@@ -1148,7 +1331,7 @@ func (ts *TreeStore) GetRelationshipValue(sk StoreKey, relationshipIndex int) (h
 	defer ts.keyNodeMu.RUnlock()
 
 	kn, tokens := ts.getTokenSetForAddressLocked(targetAddr)
-	if kn == nil || kn.isExpired() {
+	if kn == nil || kn.isExpired(ts.expirationTick()) {
 		return
 	}
 
@@ -1167,29 +1350,47 @@ func (ts *TreeStore) GetRelationshipValue(sk StoreKey, relationshipIndex int) (h
 func (ts *TreeStore) discardChildren(sk StoreKey, kn *keyNode) {
 	level := kn.nextLevel
 	if level != nil {
-		level.tree.Iterate(func(node *avlNode[*keyNode]) bool {
-			childSk := AppendStoreKeySegments(sk, node.key)
-			ts.discardChildren(childSk, node.value)
-			delete(ts.addresses, node.value.address)
-			if node.value.current != nil {
-				delete(ts.keys, childSk.Path)
-			}
-			return true
-		})
-		level.tree.Clear()
+		ts.discardLevel(sk, level)
+		ts.preserveKeyNode(kn)
 		kn.nextLevel = nil
 	}
 }
 
+// worker - removes the key nodes of a level and its sublevels from the indexes;
+// the discarded key nodes are not changed, so that a freeze can still read them
+func (ts *TreeStore) discardLevel(sk StoreKey, level *keyTree) {
+	level.tree.Iterate(func(node *avlNode[*keyNode]) bool {
+		childSk := AppendStoreKeySegments(sk, node.key)
+		if node.value.nextLevel != nil {
+			ts.discardLevel(childSk, node.value.nextLevel)
+		}
+		delete(ts.addresses, node.value.address)
+		ts.dropKeyVersion(node.value)
+		ts.dropKeyExpiration(node.value)
+		if node.value.current != nil {
+			delete(ts.keys, childSk.Path)
+		}
+		return true
+	})
+
+	if ts.freezes == nil {
+		level.tree.Clear()
+	}
+}
+
 // worker - removes the node's value (if any) as well as all child keys
 // the caller must hold a write lock on ts.keyNodeMu
 func (ts *TreeStore) resetNode(sk StoreKey, kn *keyNode) {
+	ts.preserveKeyNode(kn)
 	ts.removeAutoLinks(sk.Tokens, kn, true)
 
 	ts.discardChildren(sk, kn)
-	kn.current = nil
+	if kn.current != nil {
+		kn.current = nil
+		ts.bumpKeyVersion(kn)
+	}
 	kn.metadata = nil
-	kn.expiration = 0
+	ts.setKeyExpiration(kn, 0)
 	delete(ts.keys, sk.Path)
 }
 
@@ -49,6 +49,36 @@ func TestGetHistory(t *testing.T) {
 	}
 }
 
+func TestGetHistoryRelative(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	sk := MakeStoreKey("test")
+
+	ts.SetKeyValue(sk, 1)
+	time.Sleep(50 * time.Millisecond)
+	ts.SetKeyValue(sk, 2)
+
+	// a relative time is before the current time
+	val, exists := ts.GetKeyValueAtTime(sk, -int64(25*time.Millisecond))
+	if val != 1 || !exists {
+		t.Error("value 25 ms ago")
+	}
+
+	val, exists = ts.GetKeyValueAtTime(sk, -int64(time.Hour))
+	if val != nil || exists {
+		t.Error("value an hour ago")
+	}
+
+	val, exists = ts.GetKeyValueAtTime(sk, -1)
+	if val != 2 || !exists {
+		t.Error("current value")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
 func TestGetHistorySentinel(t *testing.T) {
 	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
 
@@ -79,3 +109,152 @@ func TestGetHistorySentinel(t *testing.T) {
 		t.Error("final diag dump")
 	}
 }
+
+func TestGetKeyValueHistory(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+	sk := MakeStoreKey("test")
+
+	for i := 0; i < 5; i++ {
+		ts.SetKeyValue(sk, i)
+	}
+
+	all, nextNs := ts.GetKeyValueHistory(sk, 0, 0, 100, false)
+	if len(all) != 5 || nextNs != 0 {
+		t.Fatalf("all history %d", len(all))
+	}
+	for i, hv := range all {
+		if hv.Value != i || (i > 0 && hv.Timestamp <= all[i-1].Timestamp) {
+			t.Errorf("entry %d: %+v", i, hv)
+		}
+	}
+
+	// ascending pages
+	values, nextNs := ts.GetKeyValueHistory(sk, 0, 0, 2, false)
+	if len(values) != 2 || values[1].Value != 1 || nextNs != all[2].Timestamp {
+		t.Error("first page")
+	}
+	values, nextNs = ts.GetKeyValueHistory(sk, nextNs, 0, 2, false)
+	if len(values) != 2 || values[0].Value != 2 || nextNs != all[4].Timestamp {
+		t.Error("second page")
+	}
+	values, nextNs = ts.GetKeyValueHistory(sk, nextNs, 0, 2, false)
+	if len(values) != 1 || values[0].Value != 4 || nextNs != 0 {
+		t.Error("last page")
+	}
+
+	// descending within a range
+	values, nextNs = ts.GetKeyValueHistory(sk, all[1].Timestamp, all[3].Timestamp, 2, true)
+	if len(values) != 2 || values[0].Value != 3 || values[1].Value != 2 || nextNs != all[1].Timestamp {
+		t.Error("descending page")
+	}
+	values, nextNs = ts.GetKeyValueHistory(sk, all[1].Timestamp, nextNs, 2, true)
+	if len(values) != 1 || values[0].Value != 1 || nextNs != 0 {
+		t.Error("descending last page")
+	}
+
+	if values, _ = ts.GetKeyValueHistory(MakeStoreKey("missing"), 0, 0, 10, false); values != nil {
+		t.Error("missing key")
+	}
+	if values, _ = ts.GetKeyValueHistory(sk, 0, 0, 0, false); values != nil {
+		t.Error("zero limit")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestGetKeyValueHistoryRelationships(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	target, _ := ts.SetKey(MakeStoreKey("target"))
+	sk := MakeStoreKey("source")
+	ts.SetKeyValueEx(sk, "a", 0, -1, []StoreAddress{target})
+	ts.SetKeyValueEx(sk, "b", 0, -1, []StoreAddress{})
+
+	values, _ := ts.GetKeyValueHistory(sk, 0, 0, 10, true)
+	if len(values) != 2 || values[0].Value != "b" || len(values[0].Relationships) != 0 {
+		t.Fatal("newest value")
+	}
+	if values[1].Value != "a" || len(values[1].Relationships) != 1 || values[1].Relationships[0] != target {
+		t.Error("relationships")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestGetKeyAsJsonAtTime(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("user", "name"), "fred")
+	ts.SetKeyValue(MakeStoreKey("user", "age"), 30)
+	ts.SetKeyJson(MakeStoreKey("user", "pets"), []byte(`["dino"]`), 0)
+	tick := time.Now().UTC().UnixNano()
+
+	ts.SetKeyValue(MakeStoreKey("user", "age"), 31)
+	ts.SetKeyValue(MakeStoreKey("user", "email"), "fred@example.com")
+	ts.SetKeyValue(MakeStoreKey("user", "pets", "\x00\x00\x00\x00"), "hoppy")
+
+	jsonData, err := ts.GetKeyAsJsonAtTime(MakeStoreKey("user"), tick, 0)
+	if err != nil || string(jsonData) != `{"age":30,"name":"fred","pets":["dino"]}` {
+		t.Errorf("past json %s", string(jsonData))
+	}
+
+	jsonData, _ = ts.GetKeyAsJsonAtTime(MakeStoreKey("user"), -1, 0)
+	if string(jsonData) != `{"age":31,"email":"fred@example.com","name":"fred","pets":["hoppy"]}` {
+		t.Errorf("relative json %s", string(jsonData))
+	}
+
+	jsonData, _ = ts.GetKeyAsJsonAtTime(MakeStoreKey("user"), 1, 0)
+	if string(jsonData) != `{}` {
+		t.Errorf("json before values %s", string(jsonData))
+	}
+
+	ts.SetKeyJson(MakeStoreKey("pet"), []byte(`{"kind":"dino","age":3}`), JsonStringValuesAsKeys)
+	tick = time.Now().UTC().UnixNano()
+	ts.SetKeyValue(MakeStoreKey("pet", "age"), 4)
+
+	jsonData, _ = ts.GetKeyAsJsonAtTime(MakeStoreKey("pet"), tick, JsonStringValuesAsKeys)
+	if string(jsonData) != `{"age":3,"kind":"dino"}` {
+		t.Errorf("past json with string keys %s", string(jsonData))
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}
+
+func TestGetMatchingKeyValuesAtTime(t *testing.T) {
+	ts := NewTreeStore(lane.NewTestingLane(context.Background()), 0)
+
+	ts.SetKeyValue(MakeStoreKey("sensors", "a"), 1.5)
+	ts.SetKeyValueEx(MakeStoreKey("sensors", "b"), 2.5, 0, -1, []StoreAddress{1})
+	tick := time.Now().UTC().UnixNano()
+
+	ts.SetKeyValueEx(MakeStoreKey("sensors", "b"), 3.5, 0, -1, []StoreAddress{})
+	ts.SetKeyValue(MakeStoreKey("sensors", "c"), 4.5)
+
+	values := ts.GetMatchingKeyValuesAtTime(MakeStoreKey("sensors", "*"), tick, 0, 100)
+	if len(values) != 2 || values[0].CurrentValue != 1.5 || values[1].CurrentValue != 2.5 {
+		t.Fatal("past values")
+	}
+	if len(values[1].Relationships) != 1 {
+		t.Error("past relationships")
+	}
+
+	values = ts.GetMatchingKeyValuesAtTime(MakeStoreKey("sensors", "*"), tick, 1, 1)
+	if len(values) != 1 || values[0].Key != "/sensors/b" {
+		t.Error("past values page")
+	}
+
+	values = ts.GetMatchingKeyValues(MakeStoreKey("sensors", "*"), 0, 100)
+	if len(values) != 3 || values[1].CurrentValue != 3.5 {
+		t.Error("current values")
+	}
+
+	if !ts.DiagDump() {
+		t.Error("final diag dump")
+	}
+}